@@ -0,0 +1,94 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/google/uuid"
+)
+
+const defaultAnswerCacheSimilarity float64 = 0.95
+
+type (
+	// AnswerCacheStore represents the storage of the semantic answer cache.
+	AnswerCacheStore interface {
+		FetchCollectionVersion(ctx context.Context, in storage.FetchCollectionVersionInput) (string, error)
+		FetchCachedAnswer(ctx context.Context, in storage.FetchCachedAnswerInput) (*storage.CachedAnswer, error)
+		StoreCachedAnswer(ctx context.Context, in storage.StoreCachedAnswerInput) error
+	}
+
+	// AnswerCache serves answers to questions similar to ones already asked
+	// in the same collection. Entries are tied to the collection version,
+	// so any change to the collection invalidates them.
+	AnswerCache struct {
+		store         AnswerCacheStore
+		minSimilarity float64
+	}
+)
+
+// NewAnswerCache returns a new semantic answer cache that serves a cached answer
+// when the cosine similarity between the questions is at least minSimilarity.
+// Values outside (0, 1] fall back to 0.95.
+func NewAnswerCache(store AnswerCacheStore, minSimilarity float64) *AnswerCache {
+	if minSimilarity <= 0 || minSimilarity > 1 {
+		minSimilarity = defaultAnswerCacheSimilarity
+	}
+
+	return &AnswerCache{
+		store:         store,
+		minSimilarity: minSimilarity,
+	}
+}
+
+// get returns the cached answer for the question vector, if any.
+func (c *AnswerCache) get(ctx context.Context, in AskInput, version string, vector []float32) (string, bool, error) {
+	cached, err := c.store.FetchCachedAnswer(ctx, storage.FetchCachedAnswerInput{
+		UserID:            in.UserID,
+		CollectionID:      in.CollectionID,
+		CollectionVersion: version,
+		Vector:            vector,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("could not fetch cached answer: %w", err)
+	}
+
+	if cached.Similarity < c.minSimilarity {
+		return "", false, nil
+	}
+	return cached.Answer, true, nil
+}
+
+// add stores the answer for the question vector.
+func (c *AnswerCache) add(ctx context.Context, in AskInput, version string, vector []float32, answer string) error {
+	if err := c.store.StoreCachedAnswer(ctx, storage.StoreCachedAnswerInput{
+		ID:                "ans-" + uuid.NewString(),
+		UserID:            in.UserID,
+		CollectionID:      in.CollectionID,
+		CollectionVersion: version,
+		Question:          in.Question,
+		Vector:            vector,
+		Answer:            answer,
+		CreatedAt:         time.Now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("could not store cached answer: %w", err)
+	}
+	return nil
+}
+
+// version returns the current version of the collection.
+func (c *AnswerCache) version(ctx context.Context, in AskInput) (string, error) {
+	version, err := c.store.FetchCollectionVersion(ctx, storage.FetchCollectionVersionInput{
+		UserID:       in.UserID,
+		CollectionID: in.CollectionID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not fetch collection version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,125 @@
+package chatbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryAnswerCacheStore struct {
+	version string
+	answers []storage.StoreCachedAnswerInput
+}
+
+func (m *memoryAnswerCacheStore) FetchCollectionVersion(ctx context.Context, in storage.FetchCollectionVersionInput) (string, error) {
+	return m.version, nil
+}
+
+func (m *memoryAnswerCacheStore) FetchCachedAnswer(ctx context.Context, in storage.FetchCachedAnswerInput) (*storage.CachedAnswer, error) {
+	var best *storage.CachedAnswer
+	for _, a := range m.answers {
+		if a.CollectionID != in.CollectionID || a.CollectionVersion != in.CollectionVersion {
+			continue
+		}
+
+		sim := cosineSimilarity(a.Vector, in.Vector)
+		if best == nil || sim > best.Similarity {
+			best = &storage.CachedAnswer{Question: a.Question, Answer: a.Answer, Similarity: sim}
+		}
+	}
+
+	if best == nil {
+		return nil, storage.ErrNotFound
+	}
+	return best, nil
+}
+
+func (m *memoryAnswerCacheStore) StoreCachedAnswer(ctx context.Context, in storage.StoreCachedAnswerInput) error {
+	m.answers = append(m.answers, in)
+	return nil
+}
+
+func TestAskWithAnswerCache(t *testing.T) {
+	var completitions int
+
+	vectors := map[string][]float32{
+		"What is the refund policy?":      {1, 0, 0},
+		"What's the refund policy?":       {0.99, 0.05, 0},
+		"How do I reset my password?":     {0, 1, 0},
+		"What is the refund policy, now?": {1, 0, 0},
+	}
+
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: vectors[in.Input]}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			completitions++
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: in.Messages[1].Content}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "emb-1", Text: "context"}}, nil
+		},
+	}
+
+	store := &memoryAnswerCacheStore{version: "v1"}
+	svc := NewService("test-api-key", &client, &client, &repo, WithAnswerCache(NewAnswerCache(store, 0.95)))
+
+	ask := func(question string) *AskResult {
+		res, err := svc.Ask(context.Background(), AskInput{
+			UserID:       "test-user",
+			CollectionID: "coll-1",
+			Question:     question,
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	first := ask("What is the refund policy?")
+	assert.False(t, first.Cached)
+
+	similar := ask("What's the refund policy?")
+	assert.True(t, similar.Cached)
+	assert.Equal(t, first.Answer, similar.Answer)
+
+	different := ask("How do I reset my password?")
+	assert.False(t, different.Cached)
+
+	// Changing the collection invalidates the cached answers.
+	store.version = "v2"
+
+	changed := ask("What is the refund policy, now?")
+	assert.False(t, changed.Cached)
+
+	assert.Equal(t, 3, completitions)
+
+	// Answers built from other context are neither served nor cached.
+	thresholded, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: "coll-1",
+		Question:     "What is the refund policy, now?",
+		MaxDistance:  0.5,
+	})
+	require.NoError(t, err)
+	assert.False(t, thresholded.Cached)
+
+	svc = NewService("test-api-key", &client, &client, &repo, WithAnswerCache(NewAnswerCache(store, 0.95)), WithTopK(3))
+
+	wider := ask("What is the refund policy, now?")
+	assert.False(t, wider.Cached)
+
+	assert.Equal(t, 5, completitions)
+	assert.Len(t, store.answers, 3)
+}
@@ -0,0 +1,241 @@
+// Package apikey issues, revokes and authenticates API keys.
+//
+// Keys are only ever shown once, when issued. The store keeps their SHA-256
+// hash, along with the user they act on behalf of and what they may do.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/google/uuid"
+)
+
+// Prefix starts every key, which makes leaked keys easy to recognize.
+const Prefix string = "cbk_"
+
+// Scope is what a key is allowed to do.
+type Scope string
+
+const (
+	// ScopeRead allows asking questions and reading collections.
+	ScopeRead Scope = "read"
+	// ScopeTrain allows training collections.
+	ScopeTrain Scope = "train"
+	// ScopeAdmin allows everything, including deleting collections.
+	ScopeAdmin Scope = "admin"
+)
+
+var (
+	// ErrInvalidKey is returned when a key is unknown or revoked.
+	ErrInvalidKey = errors.New("invalid api key")
+
+	errInvalidScope = errors.New("invalid scope")
+)
+
+// ParseScopes parses a comma separated list of scopes.
+func ParseScopes(s string) ([]Scope, error) {
+	var scopes []Scope
+	for _, name := range strings.Split(s, ",") {
+		scope := Scope(strings.TrimSpace(name))
+		switch scope {
+		case ScopeRead, ScopeTrain, ScopeAdmin:
+			scopes = append(scopes, scope)
+		default:
+			return nil, fmt.Errorf("%w: %q", errInvalidScope, scope)
+		}
+	}
+	return scopes, nil
+}
+
+type (
+	// Store persists API keys. It is implemented by *storage.Postgres.
+	Store interface {
+		StoreAPIKey(ctx context.Context, in storage.StoreAPIKeyInput) error
+		FetchAPIKey(ctx context.Context, in storage.FetchAPIKeyInput) (*storage.APIKey, error)
+		ListAPIKeys(ctx context.Context, in storage.ListAPIKeysInput) ([]storage.APIKey, error)
+		RevokeAPIKey(ctx context.Context, in storage.RevokeAPIKeyInput) error
+	}
+
+	// Key represents an API key, without its secret.
+	Key struct {
+		ID     string
+		UserID string
+		Name   string
+		Scopes []Scope
+		// CollectionIDs restricts the key to these collections. Empty means any collection.
+		CollectionIDs []string
+		CreatedAt     time.Time
+		RevokedAt     *time.Time
+	}
+
+	// IssueInput represents the input for issuing a key.
+	IssueInput struct {
+		UserID        string
+		Name          string
+		Scopes        []Scope
+		CollectionIDs []string
+	}
+)
+
+// HasScope reports whether the key has the scope. Admin keys have every scope.
+func (k *Key) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCollection reports whether the key may access the collection.
+// Keys restricted to collections may not create new ones, so an empty
+// collection ID is only allowed for unrestricted keys.
+func (k *Key) AllowsCollection(collectionID string) bool {
+	if len(k.CollectionIDs) == 0 {
+		return true
+	}
+
+	for _, id := range k.CollectionIDs {
+		if id == collectionID {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the key has the scope on the collection.
+func (k *Key) Allows(scope Scope, collectionID string) bool {
+	return k.HasScope(scope) && k.AllowsCollection(collectionID)
+}
+
+// Manager issues, revokes and authenticates API keys.
+type Manager struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewManager returns a new Manager persisting keys in the store.
+func NewManager(store Store) *Manager {
+	return &Manager{store: store, now: time.Now}
+}
+
+// Issue creates a new key and returns it along with its secret,
+// which is not stored and cannot be retrieved again.
+func (m *Manager) Issue(ctx context.Context, in IssueInput) (*Key, string, error) {
+	if in.UserID == "" {
+		return nil, "", errors.New("user is required")
+	}
+
+	if len(in.Scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("could not generate key: %w", err)
+	}
+
+	secret := Prefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	key := Key{
+		ID:            "key-" + uuid.NewString(),
+		UserID:        in.UserID,
+		Name:          in.Name,
+		Scopes:        in.Scopes,
+		CollectionIDs: in.CollectionIDs,
+		CreatedAt:     m.now().UTC(),
+	}
+
+	if err := m.store.StoreAPIKey(ctx, storage.StoreAPIKeyInput{
+		ID:            key.ID,
+		UserID:        key.UserID,
+		Name:          key.Name,
+		KeyHash:       hash(secret),
+		Scopes:        scopeNames(key.Scopes),
+		CollectionIDs: key.CollectionIDs,
+		CreatedAt:     key.CreatedAt,
+	}); err != nil {
+		return nil, "", fmt.Errorf("could not issue key: %w", err)
+	}
+	return &key, secret, nil
+}
+
+// Authenticate returns the key matching the secret, or ErrInvalidKey.
+func (m *Manager) Authenticate(ctx context.Context, secret string) (*Key, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return nil, ErrInvalidKey
+	}
+
+	stored, err := m.store.FetchAPIKey(ctx, storage.FetchAPIKeyInput{KeyHash: hash(secret)})
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, fmt.Errorf("could not authenticate key: %w", err)
+	}
+	return toKey(stored), nil
+}
+
+// List returns the keys of the user, including revoked ones.
+func (m *Manager) List(ctx context.Context, userID string) ([]Key, error) {
+	stored, err := m.store.ListAPIKeys(ctx, storage.ListAPIKeysInput{UserID: userID})
+	if err != nil {
+		return nil, fmt.Errorf("could not list keys: %w", err)
+	}
+
+	keys := make([]Key, 0, len(stored))
+	for i := range stored {
+		keys = append(keys, *toKey(&stored[i]))
+	}
+	return keys, nil
+}
+
+// Revoke revokes the key, which stops authenticating immediately.
+func (m *Manager) Revoke(ctx context.Context, keyID string) error {
+	if err := m.store.RevokeAPIKey(ctx, storage.RevokeAPIKeyInput{
+		ID:        keyID,
+		RevokedAt: m.now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("could not revoke key: %w", err)
+	}
+	return nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func scopeNames(scopes []Scope) []string {
+	names := make([]string, 0, len(scopes))
+	for _, s := range scopes {
+		names = append(names, string(s))
+	}
+	return names
+}
+
+func toKey(k *storage.APIKey) *Key {
+	scopes := make([]Scope, 0, len(k.Scopes))
+	for _, s := range k.Scopes {
+		scopes = append(scopes, Scope(s))
+	}
+
+	return &Key{
+		ID:            k.ID,
+		UserID:        k.UserID,
+		Name:          k.Name,
+		Scopes:        scopes,
+		CollectionIDs: k.CollectionIDs,
+		CreatedAt:     k.CreatedAt,
+		RevokedAt:     k.RevokedAt,
+	}
+}
@@ -0,0 +1,135 @@
+package apikey
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueAndAuthenticate(t *testing.T) {
+	stored := map[string]storage.APIKey{}
+
+	store := mockStore{
+		StoreAPIKeyFunc: func(ctx context.Context, in storage.StoreAPIKeyInput) error {
+			stored[in.KeyHash] = storage.APIKey{
+				ID:            in.ID,
+				UserID:        in.UserID,
+				Name:          in.Name,
+				KeyHash:       in.KeyHash,
+				Scopes:        in.Scopes,
+				CollectionIDs: in.CollectionIDs,
+				CreatedAt:     in.CreatedAt,
+			}
+			return nil
+		},
+		FetchAPIKeyFunc: func(ctx context.Context, in storage.FetchAPIKeyInput) (*storage.APIKey, error) {
+			k, ok := stored[in.KeyHash]
+			if !ok {
+				return nil, storage.ErrNotFound
+			}
+			return &k, nil
+		},
+	}
+
+	m := NewManager(&store)
+
+	issued, secret, err := m.Issue(context.TODO(), IssueInput{
+		UserID:        "user-1",
+		Name:          "ci",
+		Scopes:        []Scope{ScopeRead},
+		CollectionIDs: []string{"coll-1"},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(secret, Prefix))
+	assert.True(t, strings.HasPrefix(issued.ID, "key-"))
+
+	for hash := range stored {
+		assert.NotContains(t, hash, strings.TrimPrefix(secret, Prefix))
+	}
+
+	key, err := m.Authenticate(context.TODO(), secret)
+	require.NoError(t, err)
+
+	assert.Equal(t, issued.ID, key.ID)
+	assert.Equal(t, "user-1", key.UserID)
+	assert.Equal(t, []Scope{ScopeRead}, key.Scopes)
+
+	_, err = m.Authenticate(context.TODO(), secret+"x")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = m.Authenticate(context.TODO(), "not-a-key")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestKeyAllows(t *testing.T) {
+	testCases := []struct {
+		name         string
+		key          Key
+		scope        Scope
+		collectionID string
+		expected     bool
+	}{
+		{
+			name:         "scope granted",
+			key:          Key{Scopes: []Scope{ScopeRead}},
+			scope:        ScopeRead,
+			collectionID: "coll-1",
+			expected:     true,
+		},
+		{
+			name:         "scope missing",
+			key:          Key{Scopes: []Scope{ScopeRead}},
+			scope:        ScopeTrain,
+			collectionID: "coll-1",
+			expected:     false,
+		},
+		{
+			name:         "admin has every scope",
+			key:          Key{Scopes: []Scope{ScopeAdmin}},
+			scope:        ScopeTrain,
+			collectionID: "coll-1",
+			expected:     true,
+		},
+		{
+			name:         "collection allowed",
+			key:          Key{Scopes: []Scope{ScopeRead}, CollectionIDs: []string{"coll-1"}},
+			scope:        ScopeRead,
+			collectionID: "coll-1",
+			expected:     true,
+		},
+		{
+			name:         "collection not allowed",
+			key:          Key{Scopes: []Scope{ScopeRead}, CollectionIDs: []string{"coll-1"}},
+			scope:        ScopeRead,
+			collectionID: "coll-2",
+			expected:     false,
+		},
+		{
+			name:         "restricted key cannot create collections",
+			key:          Key{Scopes: []Scope{ScopeTrain}, CollectionIDs: []string{"coll-1"}},
+			scope:        ScopeTrain,
+			collectionID: "",
+			expected:     false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.key.Allows(tc.scope, tc.collectionID))
+		})
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read, train")
+	require.NoError(t, err)
+	assert.Equal(t, []Scope{ScopeRead, ScopeTrain}, scopes)
+
+	_, err = ParseScopes("read,write")
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package apikey
+
+import (
+	"context"
+
+	"github.com/alesr/chatbot/storage"
+)
+
+var _ Store = &mockStore{}
+
+type mockStore struct {
+	StoreAPIKeyFunc  func(ctx context.Context, in storage.StoreAPIKeyInput) error
+	FetchAPIKeyFunc  func(ctx context.Context, in storage.FetchAPIKeyInput) (*storage.APIKey, error)
+	ListAPIKeysFunc  func(ctx context.Context, in storage.ListAPIKeysInput) ([]storage.APIKey, error)
+	RevokeAPIKeyFunc func(ctx context.Context, in storage.RevokeAPIKeyInput) error
+}
+
+func (m *mockStore) StoreAPIKey(ctx context.Context, in storage.StoreAPIKeyInput) error {
+	return m.StoreAPIKeyFunc(ctx, in)
+}
+
+func (m *mockStore) FetchAPIKey(ctx context.Context, in storage.FetchAPIKeyInput) (*storage.APIKey, error) {
+	return m.FetchAPIKeyFunc(ctx, in)
+}
+
+func (m *mockStore) ListAPIKeys(ctx context.Context, in storage.ListAPIKeysInput) ([]storage.APIKey, error) {
+	return m.ListAPIKeysFunc(ctx, in)
+}
+
+func (m *mockStore) RevokeAPIKey(ctx context.Context, in storage.RevokeAPIKeyInput) error {
+	return m.RevokeAPIKeyFunc(ctx, in)
+}
@@ -3,44 +3,113 @@ package chatbot
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/metrics"
 	"github.com/alesr/chatbot/storage"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	defaultModel     OpenAIModel = "text-embedding-ada-002"
 	defaultChunkSize int         = 500
+	defaultTopK      int         = 1
 )
 
+// ErrModelMismatch is returned when a question is asked, or data trained into
+// an existing collection, with an embedding model or dimensions other than
+// the ones the collection is embedded with.
+var ErrModelMismatch = errors.New("embedding model does not match the collection")
+
 type (
 	// OpenAIModel represents the model used by OpenAI.
 	OpenAIModel string
 
-	// Client represents the OpenAI client for creating completitions and embeddings.
-	Client interface {
+	// Embedder creates the embeddings of chunks and questions.
+	Embedder interface {
 		CreateEmbedding(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error)
+	}
+
+	// ChatModel creates the completitions answering questions.
+	ChatModel interface {
 		CreateChatCompletition(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error)
 	}
 
+	// ModelEmbedder is an Embedder with its own default embedding model,
+	// such as a self-hosted one. Data trained and collections reindexed
+	// without a model are embedded and stored under it.
+	ModelEmbedder interface {
+		Embedder
+		EmbeddingModel() string
+	}
+
+	// StreamingChatModel is a ChatModel that can also stream completitions.
+	// AskStream streams answers as they are generated when the chat model implements it.
+	StreamingChatModel interface {
+		ChatModel
+		CreateChatCompletitionStream(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error)
+	}
+
+	// Client is an Embedder and a ChatModel from the same provider,
+	// such as the OpenAI client.
+	Client interface {
+		Embedder
+		ChatModel
+	}
+
+	// StreamingClient is a Client that can also stream completitions.
+	StreamingClient interface {
+		Client
+		CreateChatCompletitionStream(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error)
+	}
+
+	// EmbedderFunc adapts a function, such as the CreateEmbedding
+	// method of a client, to an Embedder.
+	EmbedderFunc func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error)
+
+	// ChatModelFunc adapts a function, such as the CreateChatCompletition
+	// method of a client, to a ChatModel. Completitions are not streamed.
+	ChatModelFunc func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error)
+
 	// Repository represents the storage repository for storing
 	// embeddings and fetching nearest neighbors.
 	Repository interface {
 		StoreEmbeddings(ctx context.Context, in storage.StoreEmbeddingInput) error
-		FetchNearestNeighbor(ctx context.Context, in storage.FetchNearestNeighborInput) (text string, distance float64, errr error)
+		FetchNearestNeighbors(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error)
+		FetchKeywordMatches(ctx context.Context, in storage.FetchKeywordMatchesInput) ([]storage.Chunk, error)
+		ListCollections(ctx context.Context, in storage.ListCollectionsInput) ([]storage.Collection, error)
+		FetchCollection(ctx context.Context, in storage.FetchCollectionInput) (*storage.Collection, error)
+		DeleteCollection(ctx context.Context, in storage.DeleteCollectionInput) error
+		FetchEmbeddings(ctx context.Context, in storage.FetchEmbeddingsInput) ([]storage.Embedding, error)
+		ReplaceCollection(ctx context.Context, in storage.ReplaceCollectionInput) error
 	}
 
 	// TrainInput represents the input for training.
 	TrainInput struct {
 		UserID string
-		Model  OpenAIModel
 		Data   []io.Reader
+
+		// Model is the embedding model. Defaults to the model of the existing
+		// collection, and must match it when set, or to the model of a
+		// ModelEmbedder, or text-embedding-ada-002.
+		Model OpenAIModel
+
+		// CollectionID adds the data to an existing collection of the user.
+		// A new collection is created when it is empty.
+		CollectionID string
+
+		// Metadata is stored with every chunk of the data
+		// and can be used to filter retrieval.
+		Metadata map[string]any
 	}
 
 	// AskInput represents the input for asking questions.
@@ -48,6 +117,44 @@ type (
 		UserID       string
 		CollectionID string
 		Question     string
+
+		// Model is the embedding model of the question. Defaults to the model
+		// the collection is embedded with, and must match it when set.
+		Model OpenAIModel
+
+		// Dimensions is the number of dimensions of the question. Defaults to
+		// the dimensions of the collection, and must match them when set.
+		Dimensions int
+
+		// Filters restrict retrieval to chunks whose metadata matches all of them.
+		Filters []storage.Filter
+
+		// MaxDistance, when positive, discards chunks found by vector search
+		// that are farther than it from the question.
+		MaxDistance float64
+
+		// History holds the previous messages of the conversation, oldest first.
+		History []openaicli.Message
+
+		// ChatModel is the model answering the question.
+		// Defaults to the default model of the chat model client.
+		ChatModel OpenAIModel
+	}
+
+	// AskResult represents the answer to a question.
+	AskResult struct {
+		Answer string
+
+		// Chunks are the chunks used as context. They are empty for cached answers.
+		Chunks []storage.Chunk
+
+		// Cached is set when the answer was served by the answer cache.
+		Cached bool
+
+		// PromptTokens and CompletionTokens are the tokens used by the completition.
+		// They are zero for cached answers.
+		PromptTokens     int64
+		CompletionTokens int64
 	}
 
 	// Service represents the chatbot service.
@@ -55,147 +162,645 @@ type (
 	// and asking questions by fetching nearest neighbors and
 	// creating completitions.
 	Service struct {
-		apiKey string
-		client Client
-		repo   Repository
+		apiKey         string
+		embedder       Embedder
+		embeddingModel OpenAIModel
+		chat           ChatModel
+		repo           Repository
+		textLanguage   string
+		hybrid         *HybridSearch
+		reranker       Reranker
+		topK           int
+
+		embeddingCache *EmbeddingCache
+		answerCache    *AnswerCache
+
+		ledger UsageLedger
+		prices PriceTable
+
+		quotaStore  QuotaStore
+		quotaPolicy QuotaPolicy
+
+		tracer  trace.Tracer
+		metrics *metrics.Collector
+
+		logger     Logger
+		redactText bool
 	}
+
+	// Option configures optional behaviour of the Service.
+	Option func(*Service)
 )
 
-// NewService returns a new chatbot service.
-func NewService(apiKey string, client Client, repo Repository) *Service {
-	return &Service{
-		apiKey: apiKey,
-		client: client,
-		repo:   repo,
+// WithTextSearchLanguage sets the Postgres text search configuration
+// (e.g. "english", "portuguese", "simple") used to index chunks
+// and to parse questions in keyword search.
+func WithTextSearchLanguage(lang string) Option {
+	return func(s *Service) {
+		s.textLanguage = lang
 	}
 }
 
-// Train trains the chatbot by creating embeddings for the given data.
-// It stores both the input data as well as the embeddings in the repository,
-// and returns the collection ID.
-func (s *Service) Train(ctx context.Context, in TrainInput) (string, error) {
-	var collectionID string = "coll-" + uuid.NewString()
-	readDataCh := make(chan []string)
-	errCh := make(chan error)
-
-	go func() {
-		var wg sync.WaitGroup
-		wg.Add(len(in.Data))
-
-		for _, d := range in.Data {
-			go func(d io.Reader) {
-				defer wg.Done()
-
-				chunks, err := readData([]io.Reader{d}, defaultChunkSize)
-				if err != nil {
-					errCh <- fmt.Errorf("could not read data: %w", err)
-					return
-				}
+// WithHybridSearch enables hybrid retrieval in Ask, fusing
+// vector and keyword rankings according to the given configuration.
+func WithHybridSearch(cfg HybridSearch) Option {
+	return func(s *Service) {
+		s.hybrid = &cfg
+	}
+}
 
-				readDataCh <- chunks
-			}(d)
+// WithTopK sets how many retrieved chunks are included
+// as context in the prompt. Defaults to 1.
+func WithTopK(k int) Option {
+	return func(s *Service) {
+		if k > 0 {
+			s.topK = k
 		}
+	}
+}
 
-		wg.Wait()
-		close(readDataCh)
-	}()
+// WithReranker sets the reranker applied to the retrieved
+// candidates before the top K chunks are selected.
+func WithReranker(r Reranker) Option {
+	return func(s *Service) {
+		s.reranker = r
+	}
+}
 
-	go func() {
-		var wg sync.WaitGroup
+// WithEmbeddingCache sets the cache consulted before
+// creating embeddings for chunks and questions.
+func WithEmbeddingCache(c *EmbeddingCache) Option {
+	return func(s *Service) {
+		s.embeddingCache = c
+	}
+}
 
-		for chunks := range readDataCh {
-			wg.Add(len(chunks))
+// WithAnswerCache sets the semantic cache used to answer repeated questions.
+func WithAnswerCache(c *AnswerCache) Option {
+	return func(s *Service) {
+		s.answerCache = c
+	}
+}
 
-			for _, chunk := range chunks {
-				go func(chunk string) {
-					defer wg.Done()
+// WithUsageLedger records the tokens of every client call made by the Service
+// in the ledger, and sets the prices used to estimate the cost of the usage.
+func WithUsageLedger(ledger UsageLedger, prices PriceTable) Option {
+	return func(s *Service) {
+		s.ledger = ledger
+		s.prices = prices
+	}
+}
 
-					if err := s.processChunk(
-						ctx, in.UserID, collectionID, chunk, string(in.Model),
-					); err != nil {
-						errCh <- fmt.Errorf("error occurred during training: %w", err)
-						return
-					}
-				}(chunk)
-			}
-		}
+// WithPrices sets the prices used to estimate costs, such as those
+// of reindexing, when the usage is not recorded in a ledger.
+func WithPrices(prices PriceTable) Option {
+	return func(s *Service) {
+		s.prices = prices
+	}
+}
 
-		wg.Wait()
-		close(errCh)
+// WithQuotas enforces the quotas returned by the policy for each user
+// in Train and Ask, reading the current usage from the store.
+//
+// Token quotas count the tokens recorded by WithUsageLedger, so without
+// a ledger nothing is counted and they are never reached; NewService logs
+// a warning if so. They are checked before each call against the tokens
+// already used, so the call that reaches a quota may exceed it by its own tokens.
+func WithQuotas(store QuotaStore, policy QuotaPolicy) Option {
+	return func(s *Service) {
+		s.quotaStore = store
+		s.quotaPolicy = policy
+	}
+}
+
+// WithMetrics records Train and Ask calls, and the distance
+// of the nearest chunk to each question, in the collector.
+func WithMetrics(m *metrics.Collector) Option {
+	return func(s *Service) {
+		s.metrics = m
+	}
+}
+
+// CreateEmbedding calls f(ctx, in).
+func (f EmbedderFunc) CreateEmbedding(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+	return f(ctx, in)
+}
+
+// CreateChatCompletition calls f(ctx, in).
+func (f ChatModelFunc) CreateChatCompletition(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+	return f(ctx, in)
+}
+
+// NewService returns a new chatbot service, creating embeddings with the
+// embedder and answers with the chat model. Both can come from different
+// providers; pass the same client twice to use one provider for both.
+func NewService(apiKey string, embedder Embedder, chat ChatModel, repo Repository, opts ...Option) *Service {
+	s := &Service{
+		apiKey:       apiKey,
+		embedder:     embedder,
+		chat:         chat,
+		repo:         repo,
+		textLanguage: storage.DefaultTextSearchLanguage,
+		topK:         defaultTopK,
+		tracer:       noop.NewTracerProvider().Tracer(tracerName),
+		logger:       nopLogger{},
+	}
+
+	s.embeddingModel = defaultModel
+	if e, ok := embedder.(ModelEmbedder); ok {
+		s.embeddingModel = OpenAIModel(e.EmbeddingModel())
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.quotaPolicy != nil && s.ledger == nil {
+		s.logger.WarnContext(context.Background(), "token quotas are not enforced without a usage ledger")
+	}
+	return s
+}
+
+// Train trains the chatbot by creating embeddings for the given data.
+// It stores both the input data as well as the embeddings in the repository,
+// and returns the collection ID.
+// Training an existing collection that the user does not own returns storage.ErrNotFound.
+// With quotas configured, the data is read and checked against them
+// before any embedding is created.
+func (s *Service) Train(ctx context.Context, in TrainInput) (_ string, err error) {
+	var collectionID string = in.CollectionID
+	if collectionID == "" {
+		collectionID = "coll-" + uuid.NewString()
+	}
+
+	ctx, span := s.startSpan(ctx, "chatbot.Train",
+		attribute.String("chatbot.user_id", in.UserID),
+		attribute.String("chatbot.collection_id", collectionID),
+	)
+
+	start := time.Now()
+	defer func() {
+		s.metrics.ObserveServiceCall("train", time.Since(start), err)
+		endSpan(span, err)
 	}()
 
-	for err := range errCh {
-		if err != nil {
+	chunks, err := readDataConcurrently(in.Data, defaultChunkSize)
+	if err != nil {
+		return "", fmt.Errorf("could not read data: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("chatbot.chunks", len(chunks)))
+
+	s.logger.InfoContext(ctx, "data chunked",
+		"user_id", in.UserID,
+		"collection_id", collectionID,
+		"readers", len(in.Data),
+		"chunks", len(chunks),
+		"chunk_size", defaultChunkSize,
+	)
+
+	var existing *storage.Collection
+	if in.CollectionID != "" {
+		if existing, err = s.repo.FetchCollection(ctx, storage.FetchCollectionInput{
+			UserID:       in.UserID,
+			CollectionID: in.CollectionID,
+		}); err != nil {
 			return "", err
 		}
 	}
 
+	// Chunks added to a collection are embedded like the ones it has,
+	// which may have been reindexed with another model.
+	var dimensions int
+	if existing != nil {
+		if in.Model != "" && string(in.Model) != existing.Model {
+			return "", fmt.Errorf("%w: %s is embedded with %s", ErrModelMismatch, in.CollectionID, existing.Model)
+		}
+
+		dimensions = existing.Dimensions
+		in.Model = OpenAIModel(existing.Model)
+	}
+
+	if in.Model == "" {
+		in.Model = s.embeddingModel
+	}
+
+	if err := s.checkTrainQuotas(ctx, in.UserID, len(chunks), existing); err != nil {
+		s.logger.WarnContext(ctx, "training rejected", "user_id", in.UserID, "error", err)
+		return "", err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	for i, chunk := range chunks {
+		i, chunk := i, chunk
+
+		g.Go(func() error {
+			if err := s.processChunk(
+				gctx, in.UserID, collectionID, chunk, string(in.Model), dimensions, in.Metadata,
+			); err != nil {
+				s.logger.ErrorContext(gctx, "could not train chunk",
+					"user_id", in.UserID,
+					"collection_id", collectionID,
+					"chunk", i,
+					"error", err,
+				)
+				return fmt.Errorf("error occurred during training: %w", err)
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return "", err
+	}
+
+	s.logger.InfoContext(ctx, "training finished",
+		"user_id", in.UserID,
+		"collection_id", collectionID,
+		"chunks", len(chunks),
+		"duration", time.Since(start),
+	)
 	return collectionID, nil
 }
 
 // processChunk creates embeddings for the given chunk of data,
-func (s *Service) processChunk(ctx context.Context, userID, collectionID, chunk, model string) error {
-	embedd, err := s.client.CreateEmbedding(ctx, openaicli.EmbbedingRequest{
-		Model: model,
-		Input: chunk,
-	})
+func (s *Service) processChunk(ctx context.Context, userID, collectionID, chunk, model string, dimensions int, metadata map[string]any) (err error) {
+	ctx, span := s.startSpan(ctx, "chatbot.processChunk")
+	defer func() { endSpan(span, err) }()
+
+	embedd, err := s.embed(ctx, usageScope{
+		userID:       userID,
+		collectionID: collectionID,
+		operation:    OperationTrainEmbedding,
+	}, model, dimensions, chunk)
 	if err != nil {
-		return fmt.Errorf("could not create embeddings: %w", err)
+		return err
 	}
 
-	if err := s.repo.StoreEmbeddings(ctx,
+	storeCtx, storeSpan := s.startSpan(ctx, "chatbot.store")
+
+	err = s.repo.StoreEmbeddings(storeCtx,
 		storage.StoreEmbeddingInput{
 			ID:           "emb-" + uuid.NewString(),
 			UserID:       userID,
 			CollectionID: collectionID,
 			Model:        string(model),
+			Dimensions:   dimensions,
 			Text:         chunk,
-			Tokens:       int64(embedd.Usage.TotalTokens),
-			Vector:       embedd.Data[0].Embedding,
+			Tokens:       embedd.Tokens,
+			Vector:       embedd.Vector,
+			Language:     s.textLanguage,
+			Metadata:     metadata,
 			CreatedAt:    time.Now().UTC(),
-		}); err != nil {
+		})
+
+	endSpan(storeSpan, err)
+
+	if err != nil {
 		return fmt.Errorf("could not store vector: %w", err)
 	}
+
+	s.logger.DebugContext(ctx, "chunk stored",
+		"user_id", userID,
+		"collection_id", collectionID,
+		"tokens", embedd.Tokens,
+		"cached", embedd.Cached,
+	)
 	return nil
 }
 
-// Ask asks the chatbot a question by fetching the nearest neighbor and creating a chat completition.
-func (s *Service) Ask(ctx context.Context, userID, collectionID, question string) (string, error) {
-	embedd, err := s.client.CreateEmbedding(ctx, openaicli.EmbbedingRequest{
-		Model: string(defaultModel),
-		Input: question,
+// embedding represents the embedding of a text.
+// Cached is set when it was served by the embedding cache.
+type embedding struct {
+	Vector []float32
+	Tokens int64
+	Cached bool
+}
+
+// embed returns the embedding of the text, consulting
+// the embedding cache, if any, before calling the embedder.
+// Calls to the embedder are recorded in the usage ledger under the scope.
+// Dimensions of 0 leave the length of the vector to the model.
+func (s *Service) embed(ctx context.Context, scope usageScope, model string, dimensions int, text string) (_ *embedding, err error) {
+	ctx, span := s.startSpan(ctx, "chatbot.embed", attribute.String("chatbot.model", model))
+	defer func() { endSpan(span, err) }()
+
+	var key embeddingCacheKey
+
+	if s.embeddingCache != nil {
+		key = newEmbeddingCacheKey(model, dimensions, text)
+
+		cached, ok, err := s.embeddingCache.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			span.SetAttributes(attribute.Bool("chatbot.cached", true))
+			s.logger.DebugContext(ctx, "embedding served from cache",
+				"operation", scope.operation,
+				"model", model,
+			)
+			return &embedding{Vector: cached.Vector, Tokens: cached.Tokens, Cached: true}, nil
+		}
+	}
+
+	embedd, err := s.embedder.CreateEmbedding(ctx, openaicli.EmbbedingRequest{
+		Model:      model,
+		Input:      text,
+		Dimensions: dimensions,
 	})
 	if err != nil {
-		return "", fmt.Errorf("could not create embeddings: %w", err)
+		return nil, fmt.Errorf("could not create embeddings: %w", err)
+	}
+
+	if len(embedd.Data) == 0 {
+		return nil, fmt.Errorf("could not create embeddings: empty response")
+	}
+
+	emb := embedding{
+		Vector: embedd.Data[0].Embedding,
+		Tokens: int64(embedd.Usage.TotalTokens),
+	}
+
+	s.logger.DebugContext(ctx, "embedding created",
+		"operation", scope.operation,
+		"model", model,
+		"tokens", emb.Tokens,
+	)
+
+	if err := s.recordUsage(ctx, scope, model, int64(embedd.Usage.TotalTokens), 0); err != nil {
+		return nil, err
+	}
+
+	if s.embeddingCache != nil {
+		if err := s.embeddingCache.add(ctx, key, storage.CachedEmbedding{
+			Vector: emb.Vector,
+			Tokens: emb.Tokens,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &emb, nil
+}
+
+// Ask asks the chatbot a question by fetching the nearest neighbor and creating a chat completition.
+// With an answer cache configured, questions similar enough to one already answered
+// in the same, unchanged collection are answered from the cache.
+// Questions with filters, history, a maximum distance or a chat model,
+// and services retrieving other than the default number of chunks,
+// always bypass the cache.
+func (s *Service) Ask(ctx context.Context, in AskInput) (*AskResult, error) {
+	return s.ask(ctx, in, nil)
+}
+
+// AskStream is like Ask, but also calls onDelta with each piece of the answer
+// as it is generated. Answers are streamed only if the chat model is a StreamingChatModel,
+// otherwise onDelta is called once with the whole answer.
+func (s *Service) AskStream(ctx context.Context, in AskInput, onDelta func(delta string) error) (*AskResult, error) {
+	return s.ask(ctx, in, onDelta)
+}
+
+func (s *Service) ask(ctx context.Context, in AskInput, onDelta func(delta string) error) (_ *AskResult, err error) {
+	ctx, span := s.startSpan(ctx, "chatbot.Ask",
+		attribute.String("chatbot.user_id", in.UserID),
+		attribute.String("chatbot.collection_id", in.CollectionID),
+	)
+
+	start := time.Now()
+	defer func() {
+		s.metrics.ObserveServiceCall("ask", time.Since(start), err)
+		endSpan(span, err)
+	}()
+
+	if err := s.checkTokenQuotas(ctx, in.UserID); err != nil {
+		s.logger.WarnContext(ctx, "question rejected", "user_id", in.UserID, "error", err)
+		return nil, err
+	}
+
+	if in, err = s.resolveEmbedding(ctx, in); err != nil {
+		return nil, err
+	}
+
+	embedd, err := s.embed(ctx, in.usageScope(OperationQuestionEmbedding), in.embeddingModel(), in.Dimensions, in.Question)
+	if err != nil {
+		return nil, err
+	}
+
+	useCache := s.cachesAnswer(in)
+
+	var version string
+	if useCache {
+		if version, err = s.answerCache.version(ctx, in); err != nil {
+			return nil, err
+		}
+
+		answer, ok, err := s.answerCache.get(ctx, in, version, embedd.Vector)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			span.SetAttributes(attribute.Bool("chatbot.cached", true))
+			s.logger.InfoContext(ctx, "answer served from cache",
+				"user_id", in.UserID,
+				"collection_id", in.CollectionID,
+				"question", s.redact(in.Question),
+				"answer", s.redact(answer),
+			)
+
+			if onDelta != nil {
+				if err := onDelta(answer); err != nil {
+					return nil, err
+				}
+			}
+			return &AskResult{Answer: answer, Cached: true}, nil
+		}
+	}
+
+	chunks, err := s.retrieve(ctx, in, embedd.Vector)
+	if err != nil {
+		return nil, err
 	}
 
-	text, _, err := s.repo.FetchNearestNeighbor(ctx, storage.FetchNearestNeighborInput{
-		UserID:       userID,
-		CollectionID: collectionID,
-		Vector:       embedd.Data[0].Embedding,
+	s.logger.DebugContext(ctx, "chunks retrieved",
+		"user_id", in.UserID,
+		"collection_id", in.CollectionID,
+		"chunks", len(chunks),
+	)
+
+	messages := make([]openaicli.Message, 0, len(in.History)+2)
+	messages = append(messages, openaicli.Message{
+		Role:    "system",
+		Content: contextText(chunks),
 	})
+	messages = append(messages, in.History...)
+	messages = append(messages, openaicli.Message{
+		Role:    "user",
+		Content: in.Question,
+	})
+
+	completition, err := s.complete(ctx, openaicli.CompletitionRequest{
+		Model:    string(in.ChatModel),
+		Messages: messages,
+	}, onDelta)
+	if err != nil {
+		return nil, fmt.Errorf("could not create completition: %w", err)
+	}
+
+	if len(completition.Choices) == 0 {
+		return nil, fmt.Errorf("could not create completition: empty response")
+	}
+
+	model := completition.Model
+	if model == "" {
+		model = in.chatModel()
+	}
+
+	if err := s.recordUsage(ctx, in.usageScope(OperationCompletion), model,
+		int64(completition.Usage.PromptTokens), int64(completition.Usage.CompletionTokens),
+	); err != nil {
+		return nil, err
+	}
+
+	answer := completition.Choices[0].Message.Content
+
+	s.logger.InfoContext(ctx, "completion created",
+		"user_id", in.UserID,
+		"collection_id", in.CollectionID,
+		"model", model,
+		"prompt_tokens", completition.Usage.PromptTokens,
+		"completion_tokens", completition.Usage.CompletionTokens,
+		"question", s.redact(in.Question),
+		"answer", s.redact(answer),
+	)
+
+	if useCache {
+		if err := s.answerCache.add(ctx, in, version, embedd.Vector, answer); err != nil {
+			return nil, err
+		}
+	}
+
+	return &AskResult{
+		Answer:           answer,
+		Chunks:           chunks,
+		PromptTokens:     int64(completition.Usage.PromptTokens),
+		CompletionTokens: int64(completition.Usage.CompletionTokens),
+	}, nil
+}
+
+// cachesAnswer reports whether the answer to the question is cached. Only
+// answers built from the default retrieval are, as the cache is shared by
+// every question asked to the collection.
+func (s *Service) cachesAnswer(in AskInput) bool {
+	return s.answerCache != nil &&
+		len(in.Filters) == 0 &&
+		len(in.History) == 0 &&
+		in.MaxDistance <= 0 &&
+		in.ChatModel == "" &&
+		s.topK == defaultTopK
+}
+
+// complete creates the completition, streaming it to onDelta if set.
+func (s *Service) complete(ctx context.Context, req openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error) {
+	if onDelta == nil {
+		return s.chat.CreateChatCompletition(ctx, req)
+	}
+
+	if streamer, ok := s.chat.(StreamingChatModel); ok {
+		return streamer.CreateChatCompletitionStream(ctx, req, onDelta)
+	}
+
+	completition, err := s.chat.CreateChatCompletition(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("could not fetch nearest neighbor: %w", err)
-	}
-
-	completition, err := s.client.CreateChatCompletition(ctx, openaicli.CompletitionRequest{
-		Model: string(defaultModel),
-		Messages: []openaicli.Message{
-			{
-				Role:    "system",
-				Content: text,
-			},
-			{
-				Role:    "user",
-				Content: question,
-			},
-		},
+		return nil, err
+	}
+
+	if len(completition.Choices) > 0 {
+		if err := onDelta(completition.Choices[0].Message.Content); err != nil {
+			return nil, err
+		}
+	}
+	return completition, nil
+}
+
+// resolveEmbedding sets the model and dimensions of the question to the ones
+// the collection is embedded with, which change when it is reindexed.
+// It returns ErrModelMismatch if the input asks for others.
+func (s *Service) resolveEmbedding(ctx context.Context, in AskInput) (AskInput, error) {
+	collection, err := s.repo.FetchCollection(ctx, storage.FetchCollectionInput{
+		UserID:       in.UserID,
+		CollectionID: in.CollectionID,
 	})
 	if err != nil {
-		return "", fmt.Errorf("could not create completition: %w", err)
+		return in, err
+	}
+
+	if (in.Model != "" && string(in.Model) != collection.Model) ||
+		(in.Dimensions != 0 && in.Dimensions != collection.Dimensions) {
+		return in, fmt.Errorf("%w: %s is embedded with %s", ErrModelMismatch, in.CollectionID, collection.Model)
+	}
+
+	in.Model = OpenAIModel(collection.Model)
+	in.Dimensions = collection.Dimensions
+	return in, nil
+}
+
+// embeddingModel returns the model used to embed the question.
+func (in AskInput) embeddingModel() string {
+	if in.Model == "" {
+		return string(defaultModel)
+	}
+	return string(in.Model)
+}
+
+// chatModel returns the model answering the question.
+func (in AskInput) chatModel() string {
+	if in.ChatModel == "" {
+		return openaicli.DefaultChatModel
+	}
+	return string(in.ChatModel)
+}
+
+func (in AskInput) usageScope(op UsageOperation) usageScope {
+	return usageScope{
+		userID:       in.UserID,
+		collectionID: in.CollectionID,
+		operation:    op,
 	}
+}
+
+// readDataConcurrently reads each reader in its own goroutine
+// and returns the chunks of all of them, in the order of the readers.
+func readDataConcurrently(data []io.Reader, chunkSize int) ([]string, error) {
+	results := make([][]string, len(data))
+
+	var g errgroup.Group
+
+	for i, d := range data {
+		i, d := i, d
+
+		g.Go(func() error {
+			chunks, err := readData([]io.Reader{d}, chunkSize)
+			if err != nil {
+				return err
+			}
 
-	return completition.Choices[0].Message.Content, nil
+			results[i] = chunks
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var chunks []string
+	for _, r := range results {
+		chunks = append(chunks, r...)
+	}
+	return chunks, nil
 }
 
 // readData reads the data from the given readers and returns a slice of strings.
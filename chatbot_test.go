@@ -6,13 +6,20 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/alesr/chatbot/client/offline"
 	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/client/openaicli/openaitest"
 	"github.com/alesr/chatbot/storage"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// fetchDefaultCollection returns a collection embedded with the default model.
+func fetchDefaultCollection(ctx context.Context, in storage.FetchCollectionInput) (*storage.Collection, error) {
+	return &storage.Collection{ID: in.CollectionID, Model: string(defaultModel)}, nil
+}
+
 func TestTrain(t *testing.T) {
 	client := mockClient{
 		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
@@ -35,7 +42,7 @@ func TestTrain(t *testing.T) {
 		},
 	}
 
-	svc := NewService("test-api-key", &client, &repo)
+	svc := NewService("test-api-key", &client, &client, &repo)
 
 	input := TrainInput{
 		UserID: "test-user",
@@ -59,7 +66,66 @@ func TestTrain(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTrainExistingCollection(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+	}
+
+	var storedIn string
+
+	repo := mockRepository{
+		FetchCollectionFunc: func(ctx context.Context, in storage.FetchCollectionInput) (*storage.Collection, error) {
+			if in.CollectionID != "coll-1" {
+				return nil, storage.ErrNotFound
+			}
+			return &storage.Collection{ID: in.CollectionID, Model: string(defaultModel), Chunks: 1}, nil
+		},
+		StoreEmbeddingsFunc: func(ctx context.Context, in storage.StoreEmbeddingInput) error {
+			storedIn = in.CollectionID
+			return nil
+		},
+	}
+
+	svc := NewService("test-api-key", &client, &client, &repo)
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID:       "test-user",
+		Model:        defaultModel,
+		CollectionID: "coll-1",
+		Data:         []io.Reader{strings.NewReader("word1 word2 word3")},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "coll-1", collectionID)
+	assert.Equal(t, "coll-1", storedIn)
+
+	// Chunks embedded by another model would not be comparable with the collection.
+	storedIn = ""
+	_, err = svc.Train(context.Background(), TrainInput{
+		UserID:       "test-user",
+		Model:        "text-embedding-3-small",
+		CollectionID: "coll-1",
+		Data:         []io.Reader{strings.NewReader("word1 word2 word3")},
+	})
+	assert.ErrorIs(t, err, ErrModelMismatch)
+	assert.Empty(t, storedIn)
+
+	_, err = svc.Train(context.Background(), TrainInput{
+		UserID:       "test-user",
+		Model:        defaultModel,
+		CollectionID: "coll-2",
+		Data:         []io.Reader{strings.NewReader("word1 word2 word3")},
+	})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
 func TestAsk(t *testing.T) {
+	var chatModel string
+
 	client := mockClient{
 		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
 			return &openaicli.EmbeddingResponse{
@@ -74,6 +140,7 @@ func TestAsk(t *testing.T) {
 			}, nil
 		},
 		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			chatModel = in.Model
 			return &openaicli.CompletitionResponse{
 				Choices: []openaicli.Choice{
 					{
@@ -90,21 +157,28 @@ func TestAsk(t *testing.T) {
 		StoreEmbeddingsFunc: func(ctx context.Context, in storage.StoreEmbeddingInput) error {
 			return nil
 		},
-		FetchNearestNeighborFunc: func(ctx context.Context, in storage.FetchNearestNeighborInput) (text string, distance float64, err error) {
-			return "", 0.0, nil
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "emb-1", Text: "context"}}, nil
 		},
 	}
 
-	svc := NewService("test-api-key", &client, &repo)
+	svc := NewService("test-api-key", &client, &client, &repo)
 
 	userID := "test-user"
 	collectionID := "coll-" + uuid.NewString()
 	question := "What is the meaning of life?"
 
-	answer, err := svc.Ask(context.Background(), userID, collectionID, question)
+	answer, err := svc.Ask(context.Background(), AskInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+		Question:     question,
+		ChatModel:    "gpt-4o-mini",
+	})
 	require.NoError(t, err)
 
-	assert.Equal(t, "42", answer)
+	assert.Equal(t, "42", answer.Answer)
+	assert.Equal(t, "gpt-4o-mini", chatModel)
 }
 
 func TestReadData(t *testing.T) {
@@ -178,3 +252,337 @@ func TestReadData(t *testing.T) {
 		})
 	}
 }
+
+func TestAskStream(t *testing.T) {
+	embeddingFunc := func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+		return &openaicli.EmbeddingResponse{
+			Data: []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+		}, nil
+	}
+
+	completitionFunc := func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+		return &openaicli.CompletitionResponse{
+			Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "Hello world"}}},
+		}, nil
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "emb-1", Text: "context"}}, nil
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		client       Client
+		expectDeltas []string
+	}{
+		{
+			name: "streaming client",
+			client: &mockStreamingClient{
+				mockClient: mockClient{CreateEmbeddingFunc: embeddingFunc},
+				CreateChatCompletitionStreamFunc: func(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error) {
+					for _, d := range []string{"Hello", " world"} {
+						require.NoError(t, onDelta(d))
+					}
+					return completitionFunc(ctx, in)
+				},
+			},
+			expectDeltas: []string{"Hello", " world"},
+		},
+		{
+			name: "non streaming client",
+			client: &mockClient{
+				CreateEmbeddingFunc:        embeddingFunc,
+				CreateChatCompletitionFunc: completitionFunc,
+			},
+			expectDeltas: []string{"Hello world"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewService("test-api-key", tc.client, tc.client, &repo)
+
+			var deltas []string
+
+			result, err := svc.AskStream(context.Background(), AskInput{
+				UserID:       "test-user",
+				CollectionID: "coll-1",
+				Question:     "question",
+			}, func(delta string) error {
+				deltas = append(deltas, delta)
+				return nil
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectDeltas, deltas)
+			assert.Equal(t, "Hello world", result.Answer)
+		})
+	}
+}
+
+func TestAskWithHistoryAndMaxDistance(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			assert.Equal(t, "text-embedding-3-small", in.Model)
+
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			assert.Equal(t, []openaicli.Message{
+				{Role: "system", Content: "near"},
+				{Role: "user", Content: "first question"},
+				{Role: "assistant", Content: "first answer"},
+				{Role: "user", Content: "follow up"},
+			}, in.Messages)
+
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "answer"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: func(ctx context.Context, in storage.FetchCollectionInput) (*storage.Collection, error) {
+			return &storage.Collection{ID: in.CollectionID, Model: "text-embedding-3-small"}, nil
+		},
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{
+				{ID: "emb-1", Text: "near", Distance: 0.2},
+				{ID: "emb-2", Text: "far", Distance: 0.8},
+			}, nil
+		},
+	}
+
+	svc := NewService("test-api-key", &client, &client, &repo, WithTopK(2))
+
+	result, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: "coll-1",
+		Question:     "follow up",
+		MaxDistance:  0.5,
+		History: []openaicli.Message{
+			{Role: "user", Content: "first question"},
+			{Role: "assistant", Content: "first answer"},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, result.Chunks, 1)
+	assert.Equal(t, "emb-1", result.Chunks[0].ID)
+}
+
+func TestServiceWithMemoryRepository(t *testing.T) {
+	vectors := map[string][]float32{
+		"Vacation is 25 days per year.": {1, 0},
+		"Expenses are paid monthly.":    {0, 1},
+		"How many vacation days?":       {0.9, 0.1},
+	}
+
+	var prompt string
+
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: vectors[strings.TrimSpace(in.Input)]}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			prompt = in.Messages[0].Content
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "25 days"}}},
+			}, nil
+		},
+	}
+
+	svc := NewService("test-api-key", &client, &client, storage.NewMemory())
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID: "user-1",
+		Data: []io.Reader{
+			strings.NewReader("Vacation is 25 days per year."),
+			strings.NewReader("Expenses are paid monthly."),
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Question:     "How many vacation days?",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "25 days", result.Answer)
+	require.Len(t, result.Chunks, 1)
+	assert.Equal(t, "Vacation is 25 days per year.", strings.TrimSpace(result.Chunks[0].Text))
+	assert.Contains(t, prompt, "Vacation is 25 days per year.")
+
+	collections, err := svc.ListCollections(context.Background(), "user-1")
+	require.NoError(t, err)
+	require.Len(t, collections, 1)
+	assert.Equal(t, int64(2), collections[0].Chunks)
+}
+
+// modelEmbedder is an Embedder with its own default model, like a self-hosted client.
+type modelEmbedder struct {
+	EmbedderFunc
+	model string
+}
+
+func (e modelEmbedder) EmbeddingModel() string {
+	return e.model
+}
+
+func TestServiceWithModelEmbedder(t *testing.T) {
+	var models []string
+
+	embedder := modelEmbedder{
+		model: "nomic-embed-text",
+		EmbedderFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			models = append(models, in.Model)
+			return &openaicli.EmbeddingResponse{
+				Usage: openaicli.Usage{TotalTokens: 1},
+				Data:  []openaicli.Embedding{{Embedding: []float32{1, 0}}},
+			}, nil
+		},
+	}
+
+	chat := ChatModelFunc(func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+		return &openaicli.CompletitionResponse{
+			Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "25 days"}}},
+		}, nil
+	})
+
+	store := &memoryEmbeddingCacheStore{items: make(map[string]storage.CachedEmbedding)}
+	svc := NewService("test-api-key", embedder, chat, storage.NewMemory(), WithEmbeddingCache(NewEmbeddingCache(store, 0)))
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID: "user-1",
+		Data:   []io.Reader{strings.NewReader("Vacation is 25 days per year.")},
+	})
+	require.NoError(t, err)
+
+	collection, err := svc.Collection(context.Background(), "user-1", collectionID)
+	require.NoError(t, err)
+	assert.Equal(t, "nomic-embed-text", collection.Model)
+
+	_, err = svc.Ask(context.Background(), AskInput{UserID: "user-1", CollectionID: collectionID, Question: "How many vacation days?"})
+	require.NoError(t, err)
+
+	// Texts are embedded, stored and cached under the model that embeds them.
+	assert.Equal(t, []string{"nomic-embed-text", "nomic-embed-text"}, models)
+	require.Len(t, store.items, 2)
+	for key := range store.items {
+		assert.True(t, strings.HasPrefix(key, "nomic-embed-text"))
+	}
+}
+
+var (
+	_ Embedder           = (*openaicli.Client)(nil)
+	_ StreamingChatModel = (*openaicli.Client)(nil)
+)
+
+func TestServiceWithSeparateProviders(t *testing.T) {
+	srv := openaitest.NewServer()
+	defer srv.Close()
+
+	srv.Reply("Vacation is 25 days per year.", "Still 25 days.")
+
+	svc := NewService("test-api-key", offline.New(), srv.Client(), storage.NewMemory())
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID: "user-1",
+		Data:   []io.Reader{strings.NewReader("Employees get 25 vacation days per year.")},
+	})
+	require.NoError(t, err)
+
+	in := AskInput{UserID: "user-1", CollectionID: collectionID, Question: "How many vacation days?"}
+
+	result, err := svc.Ask(context.Background(), in)
+	require.NoError(t, err)
+	assert.Equal(t, "Vacation is 25 days per year.", result.Answer)
+
+	var deltas []string
+	result, err = svc.AskStream(context.Background(), in, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Still 25 days.", result.Answer)
+	assert.Len(t, deltas, 3)
+
+	// Only the completitions reach the OpenAI API.
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	for _, req := range requests {
+		assert.Equal(t, "/v1/chat/completions", req.Path)
+		assert.Contains(t, req.Completion.Messages[0].Content, "Employees get 25 vacation days per year.")
+	}
+
+	// Adapted functions are not streamed.
+	client := srv.Client()
+	svc = NewService("test-api-key", EmbedderFunc(offline.New().CreateEmbedding), ChatModelFunc(client.CreateChatCompletition), storage.NewMemory())
+
+	collectionID, err = svc.Train(context.Background(), TrainInput{
+		UserID: "user-1",
+		Data:   []io.Reader{strings.NewReader("Employees get 25 vacation days per year.")},
+	})
+	require.NoError(t, err)
+
+	deltas = nil
+	result, err = svc.AskStream(context.Background(), AskInput{UserID: "user-1", CollectionID: collectionID, Question: "How many vacation days?"}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{result.Answer}, deltas)
+	assert.False(t, srv.Requests()[2].Completion.Stream)
+}
+
+func TestServiceWithFakeOpenAI(t *testing.T) {
+	srv := openaitest.NewServer()
+	defer srv.Close()
+
+	srv.Reply("Vacation is 25 days per year.")
+
+	client := srv.Client()
+	svc := NewService("test-api-key", client, client, storage.NewMemory())
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID: "user-1",
+		Data:   []io.Reader{strings.NewReader("Employees get 25 vacation days per year.")},
+	})
+	require.NoError(t, err)
+
+	var answer strings.Builder
+	result, err := svc.AskStream(context.Background(), AskInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Question:     "How many vacation days?",
+	}, func(delta string) error {
+		answer.WriteString(delta)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Vacation is 25 days per year.", result.Answer)
+	assert.Equal(t, result.Answer, answer.String())
+	assert.Positive(t, result.PromptTokens)
+
+	requests := srv.Requests()
+	require.Len(t, requests, 3)
+
+	assert.Equal(t, "/v1/embeddings", requests[0].Path)
+	assert.Equal(t, "/v1/embeddings", requests[1].Path)
+	assert.Equal(t, "How many vacation days?", requests[1].Embedding.Input)
+
+	completion := requests[2].Completion
+	require.NotNil(t, completion)
+	assert.True(t, completion.Stream)
+	assert.Contains(t, completion.Messages[0].Content, "Employees get 25 vacation days per year.")
+}
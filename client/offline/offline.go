@@ -0,0 +1,214 @@
+// Package offline provides a chatbot client that needs no network or API key,
+// for local development and CI.
+//
+// Embeddings hash the words, word pairs and character trigrams of the text
+// into a fixed number of dimensions, so texts sharing words are close to
+// each other, and the same text always has the same vector. Completions
+// answer with the context retrieved for the question instead of generating
+// an answer.
+package offline
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/alesr/chatbot/client/openaicli"
+)
+
+const (
+	// DefaultDimensions is the length of the vectors, matching the OpenAI embedding models.
+	DefaultDimensions int = 1536
+
+	// NoContextReply answers questions for which no context was retrieved.
+	NoContextReply string = "I could not find anything about that."
+
+	model string = "offline"
+)
+
+// Client implements chatbot.Client and chatbot.StreamingClient without calling any API.
+type Client struct {
+	dimensions int
+}
+
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// WithDimensions sets the length of the vectors. Defaults to DefaultDimensions.
+// Lengths that are not positive are ignored.
+func WithDimensions(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.dimensions = n
+		}
+	}
+}
+
+// New returns a new Client.
+func New(opts ...Option) *Client {
+	c := &Client{dimensions: DefaultDimensions}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// CreateEmbedding embeds the input by feature hashing.
+// The dimensions of the request take precedence over the client's.
+func (c *Client) CreateEmbedding(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dimensions := c.dimensions
+	if in.Dimensions > 0 {
+		dimensions = in.Dimensions
+	}
+
+	tokens := len(tokenize(in.Input))
+
+	return &openaicli.EmbeddingResponse{
+		Object: "list",
+		Model:  model,
+		Data: []openaicli.Embedding{{
+			Object:    "embedding",
+			Embedding: Embed(in.Input, dimensions),
+		}},
+		Usage: openaicli.Usage{PromptTokens: tokens, TotalTokens: tokens},
+	}, nil
+}
+
+// CreateChatCompletition answers with the context of the system messages.
+func (c *Client) CreateChatCompletition(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	answer := Reply(in.Messages)
+
+	var promptTokens int
+	for _, m := range in.Messages {
+		promptTokens += len(tokenize(m.Content))
+	}
+
+	completionTokens := len(tokenize(answer))
+
+	return &openaicli.CompletitionResponse{
+		Object: "chat.completion",
+		Model:  model,
+		Choices: []openaicli.Choice{{
+			FinishReason: "stop",
+			Message:      openaicli.Message{Role: "assistant", Content: answer},
+		}},
+		Usage: openaicli.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}, nil
+}
+
+// CreateChatCompletitionStream answers like CreateChatCompletition, streaming the answer word by word.
+func (c *Client) CreateChatCompletitionStream(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error) {
+	resp, err := c.CreateChatCompletition(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := resp.Choices[0].Message.Content
+
+	for start := 0; start < len(answer); {
+		end := strings.IndexByte(answer[start+1:], ' ')
+		if end < 0 {
+			end = len(answer)
+		} else {
+			end += start + 1
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := onDelta(answer[start:end]); err != nil {
+			return nil, err
+		}
+		start = end
+	}
+	return resp, nil
+}
+
+// Reply returns the content of the system messages, which carry the retrieved context,
+// or NoContextReply if it is empty.
+func Reply(messages []openaicli.Message) string {
+	var parts []string
+	for _, m := range messages {
+		if m.Role == "system" && strings.TrimSpace(m.Content) != "" {
+			parts = append(parts, strings.TrimSpace(m.Content))
+		}
+	}
+
+	if len(parts) == 0 {
+		return NoContextReply
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Embed hashes the lowercase words of the text, each pair of consecutive words
+// and the character trigrams of each word into a unit vector of the given length,
+// or of DefaultDimensions if the length is not positive.
+func Embed(text string, dimensions int) []float32 {
+	if dimensions <= 0 {
+		dimensions = DefaultDimensions
+	}
+
+	words := tokenize(text)
+	vec := make([]float64, dimensions)
+
+	add := func(feature string, weight float64) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+
+		// The sign bit spreads collisions evenly around zero.
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%uint64(dimensions)] += weight
+	}
+
+	for i, w := range words {
+		add("w:"+w, 1)
+
+		if i > 0 {
+			add("b:"+words[i-1]+" "+w, 1)
+		}
+
+		padded := []rune("^" + w + "$")
+		for j := 0; j+3 <= len(padded); j++ {
+			add("c:"+string(padded[j:j+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+
+	out := make([]float32, dimensions)
+	for i, v := range vec {
+		if norm > 0 {
+			out[i] = float32(v / norm)
+		}
+	}
+	return out
+}
+
+// tokenize splits the text into lowercase words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
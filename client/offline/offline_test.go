@@ -0,0 +1,78 @@
+package offline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestEmbed(t *testing.T) {
+	c := New()
+
+	embed := func(text string) []float32 {
+		resp, err := c.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Input: text})
+		require.NoError(t, err)
+		require.Len(t, resp.Data, 1)
+		return resp.Data[0].Embedding
+	}
+
+	vacation := embed("Employees get 25 vacation days per year.")
+
+	assert.Len(t, vacation, DefaultDimensions)
+	assert.Equal(t, vacation, embed("Employees get 25 vacation days per year."))
+	assert.InDelta(t, 1, cosine(vacation, vacation), 1e-6)
+
+	similar := cosine(vacation, embed("How many vacation days do employees get?"))
+	unrelated := cosine(vacation, embed("Neptune is the eighth planet from the Sun."))
+
+	assert.Greater(t, similar, unrelated)
+	assert.Greater(t, similar, 0.3)
+
+	assert.Len(t, Embed("dimensions", 8), 8)
+	assert.Len(t, Embed("dimensions", 0), DefaultDimensions)
+	assert.Len(t, Embed("dimensions", -1), DefaultDimensions)
+
+	resp, err := New(WithDimensions(0)).CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Input: "text", Dimensions: -4})
+	require.NoError(t, err)
+	assert.Len(t, resp.Data[0].Embedding, DefaultDimensions)
+}
+
+func TestReply(t *testing.T) {
+	c := New()
+
+	messages := []openaicli.Message{
+		{Role: "system", Content: "Vacation is 25 days per year."},
+		{Role: "user", Content: "How many vacation days?"},
+	}
+
+	resp, err := c.CreateChatCompletition(context.TODO(), openaicli.CompletitionRequest{Messages: messages})
+	require.NoError(t, err)
+	assert.Equal(t, "Vacation is 25 days per year.", resp.Choices[0].Message.Content)
+	assert.Equal(t, 6, resp.Usage.CompletionTokens)
+
+	var deltas []string
+	resp, err = c.CreateChatCompletitionStream(context.TODO(), openaicli.CompletitionRequest{Messages: messages}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Vacation", " is", " 25", " days", " per", " year."}, deltas)
+	assert.Equal(t, "Vacation is 25 days per year.", resp.Choices[0].Message.Content)
+
+	resp, err = c.CreateChatCompletition(context.TODO(), openaicli.CompletitionRequest{
+		Messages: []openaicli.Message{{Role: "system"}, {Role: "user", Content: "anything?"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, NoContextReply, resp.Choices[0].Message.Content)
+}
@@ -0,0 +1,311 @@
+// Package ollama is a chatbot client for the native API of Ollama,
+// to run the chatbot with self-hosted models.
+//
+// The client is a chatbot.ModelEmbedder, so the chatbot trains with its embedding
+// model by default. OpenAI embedding model names are rejected rather than mapped,
+// so embeddings are stored under the model that created them. Completions
+// always use the chat model of the client.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alesr/chatbot/client/openaicli"
+)
+
+const (
+	// DefaultBaseURL is the address Ollama listens on by default.
+	DefaultBaseURL string = "http://localhost:11434"
+
+	// DefaultEmbeddingModel is the embedding model used unless WithEmbeddingModel is given.
+	DefaultEmbeddingModel string = "nomic-embed-text"
+
+	// DefaultChatModel is the chat model used unless WithChatModel is given.
+	DefaultChatModel string = "llama3.2"
+)
+
+// ErrUnsupportedModel is returned when embedding with an OpenAI model name.
+var ErrUnsupportedModel = errors.New("unsupported embedding model")
+
+// Client implements chatbot.Client and chatbot.StreamingClient over the Ollama API.
+type Client struct {
+	baseURL        string
+	httpClient     *http.Client
+	embeddingModel string
+	chatModel      string
+}
+
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// WithBaseURL sets the address of the Ollama server. Defaults to DefaultBaseURL.
+func WithBaseURL(url string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithEmbeddingModel sets the model embedding texts requested without a model.
+// Defaults to DefaultEmbeddingModel.
+func WithEmbeddingModel(model string) Option {
+	return func(c *Client) {
+		c.embeddingModel = model
+	}
+}
+
+// WithChatModel sets the model answering questions. Defaults to DefaultChatModel.
+func WithChatModel(model string) Option {
+	return func(c *Client) {
+		c.chatModel = model
+	}
+}
+
+// New returns a new Client.
+func New(httpClient *http.Client, opts ...Option) *Client {
+	c := &Client{
+		baseURL:        DefaultBaseURL,
+		httpClient:     httpClient,
+		embeddingModel: DefaultEmbeddingModel,
+		chatModel:      DefaultChatModel,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// EmbeddingModel returns the model embedding texts requested without a model.
+func (c *Client) EmbeddingModel() string {
+	return c.embeddingModel
+}
+
+type embedRequest struct {
+	Model      string `json:"model"`
+	Input      string `json:"input"`
+	Dimensions int    `json:"dimensions,omitempty"`
+}
+
+type embedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+type chatRequest struct {
+	Model    string    `json:"model"`
+	Messages []message `json:"messages"`
+	Stream   bool      `json:"stream"`
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatResponse is the response of a chat, or one line of a streamed chat.
+// The counts are only set once Done.
+type chatResponse struct {
+	Model           string    `json:"model"`
+	CreatedAt       time.Time `json:"created_at"`
+	Message         message   `json:"message"`
+	Done            bool      `json:"done"`
+	DoneReason      string    `json:"done_reason"`
+	PromptEvalCount int       `json:"prompt_eval_count"`
+	EvalCount       int       `json:"eval_count"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// CreateEmbedding embeds the input with /api/embed.
+func (c *Client) CreateEmbedding(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+	model, err := c.embeddingModelFor(in.Model)
+	if err != nil {
+		return nil, fmt.Errorf("could not create embedding: %w", err)
+	}
+
+	var resp embedResponse
+	if err := c.post(ctx, "/api/embed", embedRequest{
+		Model:      model,
+		Input:      in.Input,
+		Dimensions: in.Dimensions,
+	}, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&resp)
+	}); err != nil {
+		return nil, fmt.Errorf("could not create embedding: %w", err)
+	}
+
+	if len(resp.Embeddings) == 0 {
+		return nil, errors.New("could not create embedding: empty response")
+	}
+
+	return &openaicli.EmbeddingResponse{
+		Object: "list",
+		Model:  resp.Model,
+		Data: []openaicli.Embedding{{
+			Object:    "embedding",
+			Embedding: resp.Embeddings[0],
+		}},
+		Usage: openaicli.Usage{
+			PromptTokens: resp.PromptEvalCount,
+			TotalTokens:  resp.PromptEvalCount,
+		},
+	}, nil
+}
+
+// CreateChatCompletition answers the messages with /api/chat.
+func (c *Client) CreateChatCompletition(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+	var resp chatResponse
+	if err := c.post(ctx, "/api/chat", c.chatRequest(in, false), func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&resp)
+	}); err != nil {
+		return nil, fmt.Errorf("could not create chat completion: %w", err)
+	}
+	return toCompletition(resp, resp.Message.Content), nil
+}
+
+// CreateChatCompletitionStream answers the messages with a streamed /api/chat,
+// calling onDelta with each piece of the answer as it arrives. Returning an
+// error from onDelta aborts the stream.
+func (c *Client) CreateChatCompletitionStream(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error) {
+	var (
+		last   chatResponse
+		answer strings.Builder
+	)
+
+	if err := c.post(ctx, "/api/chat", c.chatRequest(in, true), func(body io.Reader) error {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var chunk struct {
+				chatResponse
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				return fmt.Errorf("could not decode stream line: %w", err)
+			}
+
+			if chunk.Error != "" {
+				return fmt.Errorf("stream failed: %s", chunk.Error)
+			}
+
+			if chunk.Message.Content != "" {
+				answer.WriteString(chunk.Message.Content)
+
+				if err := onDelta(chunk.Message.Content); err != nil {
+					return err
+				}
+			}
+
+			last = chunk.chatResponse
+			if chunk.Done {
+				return nil
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("could not read stream: %w", err)
+		}
+		return errors.New("stream ended before done")
+	}); err != nil {
+		return nil, fmt.Errorf("could not create chat completion: %w", err)
+	}
+	return toCompletition(last, answer.String()), nil
+}
+
+func (c *Client) chatRequest(in openaicli.CompletitionRequest, stream bool) chatRequest {
+	messages := make([]message, 0, len(in.Messages))
+	for _, m := range in.Messages {
+		role := m.Role
+		if role == "" {
+			role = "user"
+		}
+		messages = append(messages, message{Role: role, Content: m.Content})
+	}
+	return chatRequest{Model: c.chatModel, Messages: messages, Stream: stream}
+}
+
+// embeddingModelFor returns the Ollama model embedding with the requested model.
+func (c *Client) embeddingModelFor(model string) (string, error) {
+	switch {
+	case model == "":
+		return c.embeddingModel, nil
+	case strings.HasPrefix(model, "text-embedding-"):
+		return "", fmt.Errorf("%w: %s is an OpenAI model", ErrUnsupportedModel, model)
+	default:
+		return model, nil
+	}
+}
+
+func toCompletition(resp chatResponse, answer string) *openaicli.CompletitionResponse {
+	finishReason := resp.DoneReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+
+	return &openaicli.CompletitionResponse{
+		Object:  "chat.completion",
+		Model:   resp.Model,
+		Created: int(resp.CreatedAt.Unix()),
+		Choices: []openaicli.Choice{{
+			FinishReason: finishReason,
+			Message:      openaicli.Message{Role: "assistant", Content: answer},
+		}},
+		Usage: openaicli.Usage{
+			PromptTokens:     resp.PromptEvalCount,
+			CompletionTokens: resp.EvalCount,
+			TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+		},
+	}
+}
+
+// post sends the request body as JSON to the API path and decodes the response with decode.
+func (c *Client) post(ctx context.Context, path string, in any, decode func(body io.Reader) error) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("could not marshal data: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
+			return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, errResp.Error)
+		}
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if err := decode(resp.Body); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
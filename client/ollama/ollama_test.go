@@ -0,0 +1,216 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ chatbot.Client          = (*Client)(nil)
+	_ chatbot.StreamingClient = (*Client)(nil)
+	_ chatbot.ModelEmbedder   = (*Client)(nil)
+)
+
+// fakeOllama is an httptest stand-in of the Ollama API, recording the requests it receives.
+type fakeOllama struct {
+	*httptest.Server
+
+	embeds []embedRequest
+	chats  []chatRequest
+}
+
+func newFakeOllama(t *testing.T, reply []string) *fakeOllama {
+	t.Helper()
+
+	f := &fakeOllama{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/embed":
+			var in embedRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+			f.embeds = append(f.embeds, in)
+
+			if in.Model == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error":"model \"missing\" not found, try pulling it first"}`)
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(embedResponse{
+				Model:           in.Model,
+				Embeddings:      [][]float32{{0.1, 0.2, 0.3}},
+				PromptEvalCount: 4,
+			})
+		case "/api/chat":
+			var in chatRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+			f.chats = append(f.chats, in)
+
+			createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			if !in.Stream {
+				_ = json.NewEncoder(w).Encode(chatResponse{
+					Model:           in.Model,
+					CreatedAt:       createdAt,
+					Message:         message{Role: "assistant", Content: strings.Join(reply, "")},
+					Done:            true,
+					DoneReason:      "stop",
+					PromptEvalCount: 12,
+					EvalCount:       len(reply),
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-ndjson")
+
+			enc := json.NewEncoder(w)
+			for _, delta := range reply {
+				_ = enc.Encode(chatResponse{
+					Model:     in.Model,
+					CreatedAt: createdAt,
+					Message:   message{Role: "assistant", Content: delta},
+				})
+				w.(http.Flusher).Flush()
+			}
+
+			_ = enc.Encode(chatResponse{
+				Model:           in.Model,
+				CreatedAt:       createdAt,
+				Message:         message{Role: "assistant"},
+				Done:            true,
+				DoneReason:      "stop",
+				PromptEvalCount: 12,
+				EvalCount:       len(reply),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestCreateEmbedding(t *testing.T) {
+	srv := newFakeOllama(t, nil)
+	c := New(srv.Client(), WithBaseURL(srv.URL+"/"), WithEmbeddingModel("mxbai-embed-large"))
+
+	assert.Equal(t, "mxbai-embed-large", c.EmbeddingModel())
+
+	resp, err := c.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{
+		Input: "Employees get 25 vacation days per year.",
+	})
+	require.NoError(t, err)
+
+	require.Len(t, resp.Data, 1)
+	assert.Equal(t, []float32{0.1, 0.2, 0.3}, resp.Data[0].Embedding)
+	assert.Equal(t, "mxbai-embed-large", resp.Model)
+	assert.Equal(t, openaicli.Usage{PromptTokens: 4, TotalTokens: 4}, resp.Usage)
+
+	_, err = c.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Model: "all-minilm", Input: "x", Dimensions: 256})
+	require.NoError(t, err)
+
+	require.Len(t, srv.embeds, 2)
+	assert.Equal(t, embedRequest{Model: "mxbai-embed-large", Input: "Employees get 25 vacation days per year."}, srv.embeds[0])
+	assert.Equal(t, embedRequest{Model: "all-minilm", Input: "x", Dimensions: 256}, srv.embeds[1])
+
+	_, err = c.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Model: "missing", Input: "x"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+	assert.Contains(t, err.Error(), "try pulling it first")
+
+	// OpenAI models are rejected, not relabeled as the embedding model.
+	_, err = c.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Model: "text-embedding-ada-002", Input: "x"})
+	require.ErrorIs(t, err, ErrUnsupportedModel)
+	assert.Len(t, srv.embeds, 3)
+}
+
+func TestCreateChatCompletition(t *testing.T) {
+	srv := newFakeOllama(t, []string{"Vacation", " is", " 25", " days."})
+	c := New(srv.Client(), WithBaseURL(srv.URL), WithChatModel("mistral"))
+
+	in := openaicli.CompletitionRequest{
+		Model: "text-embedding-ada-002",
+		Messages: []openaicli.Message{
+			{Role: "system", Content: "Vacation is 25 days per year."},
+			{Role: "user", Content: "How many vacation days?"},
+		},
+	}
+
+	resp, err := c.CreateChatCompletition(context.TODO(), in)
+	require.NoError(t, err)
+
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, "Vacation is 25 days.", resp.Choices[0].Message.Content)
+	assert.Equal(t, "assistant", resp.Choices[0].Message.Role)
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason)
+	assert.Equal(t, "mistral", resp.Model)
+	assert.Equal(t, openaicli.Usage{PromptTokens: 12, CompletionTokens: 4, TotalTokens: 16}, resp.Usage)
+
+	require.Len(t, srv.chats, 1)
+	assert.Equal(t, chatRequest{
+		Model: "mistral",
+		Messages: []message{
+			{Role: "system", Content: "Vacation is 25 days per year."},
+			{Role: "user", Content: "How many vacation days?"},
+		},
+	}, srv.chats[0])
+}
+
+func TestCreateChatCompletitionStream(t *testing.T) {
+	srv := newFakeOllama(t, []string{"Vacation", " is", " 25", " days."})
+	c := New(srv.Client(), WithBaseURL(srv.URL))
+
+	in := openaicli.CompletitionRequest{
+		Messages: []openaicli.Message{{Role: "user", Content: "How many vacation days?"}},
+	}
+
+	var deltas []string
+	resp, err := c.CreateChatCompletitionStream(context.TODO(), in, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Vacation", " is", " 25", " days."}, deltas)
+	assert.Equal(t, "Vacation is 25 days.", resp.Choices[0].Message.Content)
+	assert.Equal(t, openaicli.Usage{PromptTokens: 12, CompletionTokens: 4, TotalTokens: 16}, resp.Usage)
+
+	require.Len(t, srv.chats, 1)
+	assert.True(t, srv.chats[0].Stream)
+	assert.Equal(t, DefaultChatModel, srv.chats[0].Model)
+
+	errStop := errors.New("stop")
+	_, err = c.CreateChatCompletitionStream(context.TODO(), in, func(string) error {
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+}
+
+func TestCreateChatCompletitionStreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model":"llama3.2","message":{"role":"assistant","content":"Vac"},"done":false}`)
+		fmt.Fprintln(w, `{"error":"model runner has unexpectedly stopped"}`)
+	}))
+	defer srv.Close()
+
+	c := New(srv.Client(), WithBaseURL(srv.URL))
+
+	_, err := c.CreateChatCompletitionStream(context.TODO(), openaicli.CompletitionRequest{
+		Messages: []openaicli.Message{{Role: "user", Content: "Hi"}},
+	}, func(string) error { return nil })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "model runner has unexpectedly stopped")
+}
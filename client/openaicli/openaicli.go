@@ -1,21 +1,115 @@
 package openaicli
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alesr/chatbot/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// DefaultBaseURL is the OpenAI API used unless WithBaseURL is given.
+	DefaultBaseURL string = "https://api.openai.com/v1"
+
+	// DefaultChatModel is the model of completitions requested without a model.
+	DefaultChatModel string = "gpt-3.5-turbo"
+
+	tracerName string = "github.com/alesr/chatbot/client/openaicli"
 )
 
 type Client struct {
 	apiKey     string
+	baseURL    string
 	httpClient *http.Client
+	tracer     trace.Tracer
+	metrics    *metrics.Collector
+	logger     Logger
+	redactText bool
+
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Logger is the structured logger used by the Client.
+// It is implemented by *slog.Logger.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) DebugContext(context.Context, string, ...any) {}
+func (nopLogger) InfoContext(context.Context, string, ...any)  {}
+func (nopLogger) WarnContext(context.Context, string, ...any)  {}
+func (nopLogger) ErrorContext(context.Context, string, ...any) {}
+
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// WithTracerProvider creates a span for every request to the OpenAI API.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Client) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithMetrics records every request to the OpenAI API in the collector.
+func WithMetrics(m *metrics.Collector) Option {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// WithLogger logs requests, retries and completions.
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithTextRedaction replaces prompts and answers in log events with their length.
+func WithTextRedaction() Option {
+	return func(c *Client) {
+		c.redactText = true
+	}
+}
+
+// WithBaseURL sends the requests to an OpenAI compatible API at the URL,
+// such as a proxy or a fake server in tests. Defaults to DefaultBaseURL.
+func WithBaseURL(url string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithRetries retries requests that fail to be sent, are rate limited
+// or fail with a server error up to max times, waiting backoff before
+// the first retry and doubling it before each next one.
+func WithRetries(max int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = max
+		c.retryBackoff = backoff
+	}
 }
 
 type EmbbedingRequest struct {
-	Model string `json:"model"`
-	Input string `json:"input"`
+	Model      string `json:"model"`
+	Input      string `json:"input"`
+	Dimensions int    `json:"dimensions,omitempty"`
 }
 
 type EmbeddingResponse struct {
@@ -38,12 +132,18 @@ type Usage struct {
 }
 
 type CompletitionRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Message struct {
-	Role    string `json:"role"`
+	Role    string `json:"role,omitempty"`
 	Content string `json:"content"`
 }
 
@@ -62,75 +162,293 @@ type Choice struct {
 	Message      Message `json:"message"`
 }
 
-func New(apiKey string, httpClient *http.Client) *Client {
-	return &Client{
+// CompletitionChunk is a server-sent event of a streamed completition.
+type CompletitionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Model   string        `json:"model"`
+	Created int           `json:"created"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+	Delta        Message `json:"delta"`
+}
+
+func New(apiKey string, httpClient *http.Client, opts ...Option) *Client {
+	c := &Client{
 		apiKey:     apiKey,
+		baseURL:    DefaultBaseURL,
 		httpClient: httpClient,
+		tracer:     noop.NewTracerProvider().Tracer(tracerName),
+		logger:     nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 func (c *Client) CreateEmbedding(ctx context.Context, in EmbbedingRequest) (*EmbeddingResponse, error) {
-	jsonData, err := json.Marshal(in)
-	if err != nil {
-		return nil, fmt.Errorf("could not marshal data: %w", err)
+	ctx, span := c.tracer.Start(ctx, "openai.CreateEmbedding", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	span.SetAttributes(attribute.String("openai.model", in.Model))
+
+	var embResp EmbeddingResponse
+	if err := c.post(ctx, "/embeddings", in, decodeJSON(&embResp)); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("could not create request: %w", err)
+	setUsageAttributes(span, embResp.Usage)
+	c.metrics.ObserveClientTokens("/embeddings", in.Model, embResp.Usage.PromptTokens, embResp.Usage.CompletionTokens)
+	return &embResp, nil
+}
+
+func (c *Client) CreateChatCompletition(ctx context.Context, in CompletitionRequest) (*CompletitionResponse, error) {
+	if in.Model == "" {
+		in.Model = DefaultChatModel
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	ctx, span := c.tracer.Start(ctx, "openai.CreateChatCompletition", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not send request: %w", err)
+	span.SetAttributes(attribute.String("openai.model", in.Model))
+
+	var compResp CompletitionResponse
+	if err := c.post(ctx, "/chat/completions", in, decodeJSON(&compResp)); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
 
-	defer resp.Body.Close()
+	c.completed(ctx, span, in, &compResp)
+	return &compResp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// CreateChatCompletitionStream creates a streamed chat completition, calling onDelta
+// with each piece of the answer as it arrives. It returns the whole completition,
+// including its usage, once the stream ends. Returning an error from onDelta aborts the stream.
+func (c *Client) CreateChatCompletitionStream(ctx context.Context, in CompletitionRequest, onDelta func(delta string) error) (*CompletitionResponse, error) {
+	if in.Model == "" {
+		in.Model = DefaultChatModel
 	}
+	in.Stream = true
+	in.StreamOptions = &StreamOptions{IncludeUsage: true}
 
-	var embResp EmbeddingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
-		return nil, fmt.Errorf("could not decode response: %w", err)
+	ctx, span := c.tracer.Start(ctx, "openai.CreateChatCompletitionStream", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	span.SetAttributes(attribute.String("openai.model", in.Model))
+
+	var compResp CompletitionResponse
+	if err := c.post(ctx, "/chat/completions", in, func(body io.Reader) error {
+		return decodeStream(body, &compResp, onDelta)
+	}); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
-	return &embResp, nil
+
+	c.completed(ctx, span, in, &compResp)
+	return &compResp, nil
 }
 
-func (c *Client) CreateChatCompletition(ctx context.Context, in CompletitionRequest) (*CompletitionResponse, error) {
-	in.Model = "gpt-3.5-turbo"
+// completed records the usage of the completition and logs it.
+func (c *Client) completed(ctx context.Context, span trace.Span, in CompletitionRequest, compResp *CompletitionResponse) {
+	setUsageAttributes(span, compResp.Usage)
+
+	var answer string
+	if len(compResp.Choices) > 0 {
+		answer = compResp.Choices[0].Message.Content
+	}
+
+	c.logger.DebugContext(ctx, "openai completion created",
+		"model", compResp.Model,
+		"stream", in.Stream,
+		"prompt_tokens", compResp.Usage.PromptTokens,
+		"completion_tokens", compResp.Usage.CompletionTokens,
+		"messages", c.redactMessages(in.Messages),
+		"answer", c.redact(answer),
+	)
+	c.metrics.ObserveClientTokens("/chat/completions", in.Model, compResp.Usage.PromptTokens, compResp.Usage.CompletionTokens)
+}
 
+// decodeStream reads the server-sent events of a streamed completition,
+// calling onDelta with each piece of the answer and accumulating
+// the whole completition into out.
+func decodeStream(body io.Reader, out *CompletitionResponse, onDelta func(delta string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var answer strings.Builder
+	choice := Choice{Message: Message{Role: "assistant"}}
+
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk CompletitionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("could not decode stream chunk: %w", err)
+		}
+
+		out.ID, out.Model, out.Created = chunk.ID, chunk.Model, chunk.Created
+		out.Object = "chat.completion"
+
+		if chunk.Usage != nil {
+			out.Usage = *chunk.Usage
+		}
+
+		for _, ch := range chunk.Choices {
+			if ch.Index != 0 {
+				continue
+			}
+
+			if ch.FinishReason != "" {
+				choice.FinishReason = ch.FinishReason
+			}
+
+			if ch.Delta.Content == "" {
+				continue
+			}
+
+			answer.WriteString(ch.Delta.Content)
+
+			if err := onDelta(ch.Delta.Content); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read stream: %w", err)
+	}
+
+	choice.Message.Content = answer.String()
+	out.Choices = []Choice{choice}
+	return nil
+}
+
+// post sends the request body as JSON to the API path
+// and decodes the response with decode, retrying rate limited
+// and failed requests as configured.
+func (c *Client) post(ctx context.Context, path string, in any, decode func(body io.Reader) error) error {
 	jsonData, err := json.Marshal(in)
 	if err != nil {
-		return nil, fmt.Errorf("could not marshal data: %w", err)
+		return fmt.Errorf("could not marshal data: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryable, err := c.send(ctx, path, jsonData, decode)
+		if err == nil {
+			return nil
+		}
+
+		if !retryable || attempt >= c.maxRetries {
+			return err
+		}
+
+		backoff := c.retryBackoff << attempt
+
+		c.logger.WarnContext(ctx, "retrying openai request",
+			"endpoint", path,
+			"attempt", attempt+1,
+			"backoff", backoff,
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
 	}
+}
 
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+// send makes a single request and reports whether a failure is worth retrying.
+func (c *Client) send(ctx context.Context, path string, body []byte, decode func(body io.Reader) error) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("could not create request: %w", err)
+		return false, fmt.Errorf("could not create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 
+	start := time.Now()
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("could not send request: %w", err)
+		c.metrics.ObserveClientCall(path, 0, time.Since(start))
+		return ctx.Err() == nil, fmt.Errorf("could not send request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	c.metrics.ObserveClientCall(path, resp.StatusCode, time.Since(start))
+
+	c.logger.DebugContext(ctx, "openai request completed",
+		"endpoint", path,
+		"status", resp.StatusCode,
+		"duration", time.Since(start),
+	)
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retryable, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	var compResp CompletitionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&compResp); err != nil {
-		return nil, fmt.Errorf("could not decode response: %w", err)
+	if err := decode(resp.Body); err != nil {
+		return false, err
 	}
-	return &compResp, nil
+	return false, nil
+}
+
+func decodeJSON(out any) func(body io.Reader) error {
+	return func(body io.Reader) error {
+		if err := json.NewDecoder(body).Decode(out); err != nil {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+		return nil
+	}
+}
+
+// redact returns the text, or a placeholder with its length when redaction is enabled.
+func (c *Client) redact(text string) string {
+	if c.redactText {
+		return fmt.Sprintf("[redacted %d bytes]", len(text))
+	}
+	return text
+}
+
+func (c *Client) redactMessages(messages []Message) []Message {
+	redacted := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		redacted = append(redacted, Message{Role: m.Role, Content: c.redact(m.Content)})
+	}
+	return redacted
+}
+
+func setUsageAttributes(span trace.Span, usage Usage) {
+	span.SetAttributes(
+		attribute.Int("openai.usage.prompt_tokens", usage.PromptTokens),
+		attribute.Int("openai.usage.completion_tokens", usage.CompletionTokens),
+		attribute.Int("openai.usage.total_tokens", usage.TotalTokens),
+	)
 }
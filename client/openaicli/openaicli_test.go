@@ -0,0 +1,167 @@
+package openaicli
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func response(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+	}
+}
+
+func TestCreateEmbeddingRetries(t *testing.T) {
+	const embeddingBody = `{"data":[{"embedding":[1,2,3]}],"usage":{"total_tokens":3}}`
+
+	testCases := []struct {
+		name           string
+		maxRetries     int
+		statuses       []int
+		expectErr      bool
+		expectAttempts int
+	}{
+		{
+			name:           "retries rate limited requests",
+			maxRetries:     2,
+			statuses:       []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusOK},
+			expectAttempts: 3,
+		},
+		{
+			name:           "gives up after max retries",
+			maxRetries:     1,
+			statuses:       []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK},
+			expectErr:      true,
+			expectAttempts: 2,
+		},
+		{
+			name:           "does not retry client errors",
+			maxRetries:     2,
+			statuses:       []int{http.StatusBadRequest, http.StatusOK},
+			expectErr:      true,
+			expectAttempts: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts int
+
+			httpClient := &http.Client{
+				Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+					body, err := io.ReadAll(req.Body)
+					require.NoError(t, err)
+					assert.Contains(t, string(body), `"input":"text"`)
+
+					status := tc.statuses[attempts]
+					attempts++
+
+					if status != http.StatusOK {
+						return response(status, `{}`), nil
+					}
+					return response(status, embeddingBody), nil
+				}),
+			}
+
+			client := New("test-api-key", httpClient, WithRetries(tc.maxRetries, time.Millisecond))
+
+			resp, err := client.CreateEmbedding(context.Background(), EmbbedingRequest{
+				Model: "text-embedding-ada-002",
+				Input: "text",
+			})
+
+			assert.Equal(t, tc.expectAttempts, attempts)
+
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, []float32{1, 2, 3}, resp.Data[0].Embedding)
+		})
+	}
+}
+
+func TestCreateChatCompletitionStream(t *testing.T) {
+	const stream = `data: {"id":"chatcmpl-1","model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{"role":"assistant"}}]}
+
+data: {"id":"chatcmpl-1","model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{"content":"Hello"}}]}
+
+data: {"id":"chatcmpl-1","model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{"content":" world"},"finish_reason":"stop"}]}
+
+data: {"id":"chatcmpl-1","model":"gpt-3.5-turbo","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}
+
+data: [DONE]
+
+`
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Contains(t, string(body), `"stream":true`)
+			assert.Contains(t, string(body), `"model":"gpt-3.5-turbo"`)
+
+			return response(http.StatusOK, stream), nil
+		}),
+	}
+
+	var deltas []string
+
+	resp, err := New("test-api-key", httpClient).CreateChatCompletitionStream(context.Background(),
+		CompletitionRequest{Messages: []Message{{Role: "user", Content: "hi"}}},
+		func(delta string) error {
+			deltas = append(deltas, delta)
+			return nil
+		},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Hello", " world"}, deltas)
+	assert.Equal(t, "Hello world", resp.Choices[0].Message.Content)
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason)
+	assert.Equal(t, 7, resp.Usage.TotalTokens)
+}
+
+func TestCreateChatCompletitionModel(t *testing.T) {
+	var models []string
+
+	httpClient := &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var body CompletitionRequest
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+			models = append(models, body.Model)
+
+			return response(http.StatusOK, `{"choices":[{"message":{"role":"assistant","content":"hi"}}]}`), nil
+		}),
+	}
+
+	client := New("test-api-key", httpClient)
+
+	for _, model := range []string{"gpt-4o-mini", ""} {
+		_, err := client.CreateChatCompletition(context.Background(), CompletitionRequest{
+			Model:    model,
+			Messages: []Message{{Role: "user", Content: "hi"}},
+		})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"gpt-4o-mini", DefaultChatModel}, models)
+}
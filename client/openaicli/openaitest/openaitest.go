@@ -0,0 +1,392 @@
+// Package openaitest provides a fake OpenAI API for tests.
+//
+// The Server emulates /v1/embeddings and /v1/chat/completions, including
+// streamed completions, so tests exercise the HTTP, JSON and error handling
+// of openaicli instead of mocking the client:
+//
+//	srv := openaitest.NewServer()
+//	defer srv.Close()
+//
+//	srv.Reply("42")
+//	client := srv.Client()
+//
+// Embeddings are deterministic vectors derived from the input, replies are
+// scripted, and errors, rate limiting and latency can be injected. Every
+// request is recorded for assertions.
+package openaitest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alesr/chatbot/client/openaicli"
+)
+
+const (
+	// DefaultDimensions is the length of the vectors, as text-embedding-ada-002's.
+	DefaultDimensions int = 1536
+
+	// DefaultReply answers completions once the scripted replies run out.
+	DefaultReply string = "This is a test reply."
+)
+
+// Request is a request received by the Server. Exactly one of Embedding
+// and Completion is set for requests to the emulated endpoints.
+type Request struct {
+	Method     string
+	Path       string
+	Header     http.Header
+	Body       []byte
+	Embedding  *openaicli.EmbbedingRequest
+	Completion *openaicli.CompletitionRequest
+}
+
+// Server is a fake OpenAI API. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	dimensions int
+	latency    time.Duration
+	replies    []string
+	replyFunc  func(in openaicli.CompletitionRequest) string
+	faults     []fault
+	requests   []Request
+}
+
+type fault struct {
+	path   string
+	status int
+}
+
+// Option configures optional behaviour of the Server.
+type Option func(*Server)
+
+// WithDimensions sets the length of the vectors. Defaults to DefaultDimensions.
+func WithDimensions(n int) Option {
+	return func(s *Server) {
+		s.dimensions = n
+	}
+}
+
+// WithLatency delays every response.
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) {
+		s.latency = d
+	}
+}
+
+// WithReplyFunc answers the completions that have no scripted reply.
+// Defaults to always answering DefaultReply.
+func WithReplyFunc(fn func(in openaicli.CompletitionRequest) string) Option {
+	return func(s *Server) {
+		s.replyFunc = fn
+	}
+}
+
+// NewServer starts and returns a new Server. Close it when done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		dimensions: DefaultDimensions,
+		replyFunc: func(openaicli.CompletitionRequest) string {
+			return DefaultReply
+		},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an openaicli.Client sending its requests to the server.
+func (s *Server) Client(opts ...openaicli.Option) *openaicli.Client {
+	opts = append([]openaicli.Option{openaicli.WithBaseURL(s.URL + "/v1")}, opts...)
+	return openaicli.New("test-api-key", s.Server.Client(), opts...)
+}
+
+// Reply scripts the answers of the next completions, in order.
+func (s *Server) Reply(answers ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replies = append(s.replies, answers...)
+}
+
+// Fail makes the next n requests to the path, such as "/v1/embeddings",
+// fail with the status and an OpenAI error body. An empty path matches any path.
+func (s *Server) Fail(path string, status, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.faults = append(s.faults, fault{path: path, status: status})
+	}
+}
+
+// RateLimit makes the next n requests fail with 429 Too Many Requests.
+func (s *Server) RateLimit(n int) {
+	s.Fail("", http.StatusTooManyRequests, n)
+}
+
+// SetLatency delays every following response.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset forgets the recorded requests, scripted replies and pending failures.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests, s.replies, s.faults = nil, nil, nil
+}
+
+// Vector returns the deterministic unit vector of the given length
+// that the Server embeds the text into.
+func Vector(text string, dimensions int) []float32 {
+	sum := sha256.Sum256([]byte(text))
+	rnd := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(sum[:8]))))
+
+	vec := make([]float32, dimensions)
+
+	var norm float64
+	for i := range vec {
+		v := rnd.NormFloat64()
+		vec[i] = float32(v)
+		norm += v * v
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+	return vec
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req := Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone()}
+
+	var err error
+	if req.Body, err = io.ReadAll(r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, "could not read body", "invalid_request_error")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/embeddings":
+		req.Embedding = &openaicli.EmbbedingRequest{}
+		err = json.Unmarshal(req.Body, req.Embedding)
+	case "/v1/chat/completions":
+		req.Completion = &openaicli.CompletitionRequest{}
+		err = json.Unmarshal(req.Body, req.Completion)
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	latency := s.latency
+	status := s.takeFault(r.URL.Path)
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+		writeError(w, http.StatusUnauthorized, "missing API key", "invalid_request_error")
+	case status == http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", "0")
+		writeError(w, status, "rate limit reached", "rate_limit_exceeded")
+	case status != 0:
+		writeError(w, status, http.StatusText(status), "server_error")
+	case r.Method != http.MethodPost:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error")
+	case req.Embedding == nil && req.Completion == nil:
+		writeError(w, http.StatusNotFound, "unknown endpoint "+r.URL.Path, "invalid_request_error")
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error(), "invalid_request_error")
+	case req.Embedding != nil:
+		s.embed(w, *req.Embedding)
+	default:
+		s.complete(w, *req.Completion)
+	}
+}
+
+// takeFault removes and returns the status of the first failure matching the path, if any.
+func (s *Server) takeFault(path string) int {
+	for i, f := range s.faults {
+		if f.path == "" || f.path == path {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			return f.status
+		}
+	}
+	return 0
+}
+
+func (s *Server) embed(w http.ResponseWriter, in openaicli.EmbbedingRequest) {
+	dimensions := s.dimensions
+	if in.Dimensions > 0 {
+		dimensions = in.Dimensions
+	}
+
+	tokens := countTokens(in.Input)
+
+	writeJSON(w, openaicli.EmbeddingResponse{
+		Object: "list",
+		Model:  in.Model,
+		Data: []openaicli.Embedding{{
+			Object:    "embedding",
+			Embedding: Vector(in.Input, dimensions),
+		}},
+		Usage: openaicli.Usage{PromptTokens: tokens, TotalTokens: tokens},
+	})
+}
+
+func (s *Server) complete(w http.ResponseWriter, in openaicli.CompletitionRequest) {
+	s.mu.Lock()
+	var reply string
+	if len(s.replies) > 0 {
+		reply, s.replies = s.replies[0], s.replies[1:]
+	} else {
+		reply = s.replyFunc(in)
+	}
+	s.mu.Unlock()
+
+	var promptTokens int
+	for _, m := range in.Messages {
+		promptTokens += countTokens(m.Content)
+	}
+
+	usage := openaicli.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: countTokens(reply),
+		TotalTokens:      promptTokens + countTokens(reply),
+	}
+
+	id := fmt.Sprintf("chatcmpl-test-%d", time.Now().UnixNano())
+	created := int(time.Now().Unix())
+
+	if !in.Stream {
+		writeJSON(w, openaicli.CompletitionResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Model:   in.Model,
+			Created: created,
+			Choices: []openaicli.Choice{{
+				FinishReason: "stop",
+				Message:      openaicli.Message{Role: "assistant", Content: reply},
+			}},
+			Usage: usage,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	send := func(choices []openaicli.ChunkChoice, u *openaicli.Usage) {
+		data, _ := json.Marshal(openaicli.CompletitionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Model:   in.Model,
+			Created: created,
+			Choices: choices,
+			Usage:   u,
+		})
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send([]openaicli.ChunkChoice{{Delta: openaicli.Message{Role: "assistant"}}}, nil)
+
+	for _, delta := range splitDeltas(reply) {
+		send([]openaicli.ChunkChoice{{Delta: openaicli.Message{Content: delta}}}, nil)
+	}
+
+	send([]openaicli.ChunkChoice{{FinishReason: "stop"}}, nil)
+
+	if in.StreamOptions != nil && in.StreamOptions.IncludeUsage {
+		send([]openaicli.ChunkChoice{}, &usage)
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// splitDeltas splits the reply into words, each keeping its leading space,
+// as streamed completions do.
+func splitDeltas(reply string) []string {
+	var (
+		deltas []string
+		start  int
+	)
+
+	for i := 1; i < len(reply); i++ {
+		if reply[i] == ' ' && reply[i-1] != ' ' {
+			deltas = append(deltas, reply[start:i])
+			start = i
+		}
+	}
+
+	if start < len(reply) {
+		deltas = append(deltas, reply[start:])
+	}
+	return deltas
+}
+
+// countTokens approximates the number of tokens of the text by its words.
+func countTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func writeError(w http.ResponseWriter, status int, msg, typ string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: errorBody{Message: msg, Type: typ}})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
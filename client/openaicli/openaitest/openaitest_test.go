@@ -0,0 +1,118 @@
+package openaitest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddings(t *testing.T) {
+	srv := NewServer(WithDimensions(8))
+	defer srv.Close()
+
+	client := srv.Client()
+
+	first, err := client.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Model: "text-embedding-3-small", Input: "hello world"})
+	require.NoError(t, err)
+
+	second, err := client.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Model: "text-embedding-3-small", Input: "hello world"})
+	require.NoError(t, err)
+
+	require.Len(t, first.Data, 1)
+	assert.Len(t, first.Data[0].Embedding, 8)
+	assert.Equal(t, first.Data[0].Embedding, second.Data[0].Embedding)
+	assert.Equal(t, Vector("hello world", 8), first.Data[0].Embedding)
+	assert.NotEqual(t, Vector("goodbye", 8), first.Data[0].Embedding)
+	assert.Equal(t, 2, first.Usage.PromptTokens)
+
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/v1/embeddings", requests[0].Path)
+	assert.Equal(t, "Bearer test-api-key", requests[0].Header.Get("Authorization"))
+	assert.Equal(t, "hello world", requests[0].Embedding.Input)
+}
+
+func TestCompletions(t *testing.T) {
+	srv := NewServer(WithReplyFunc(func(in openaicli.CompletitionRequest) string {
+		return "echo: " + in.Messages[len(in.Messages)-1].Content
+	}))
+	defer srv.Close()
+
+	srv.Reply("the answer is 42")
+
+	client := srv.Client()
+
+	var deltas []string
+	resp, err := client.CreateChatCompletitionStream(context.TODO(), openaicli.CompletitionRequest{
+		Messages: []openaicli.Message{{Role: "user", Content: "what is the answer?"}},
+	}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"the", " answer", " is", " 42"}, deltas)
+	assert.Equal(t, "the answer is 42", resp.Choices[0].Message.Content)
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason)
+	assert.Equal(t, 4, resp.Usage.CompletionTokens)
+
+	resp, err = client.CreateChatCompletition(context.TODO(), openaicli.CompletitionRequest{
+		Messages: []openaicli.Message{{Role: "user", Content: "again"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "echo: again", resp.Choices[0].Message.Content)
+
+	requests := srv.Requests()
+	require.Len(t, requests, 2)
+	assert.True(t, requests[0].Completion.Stream)
+	assert.False(t, requests[1].Completion.Stream)
+}
+
+func TestFailures(t *testing.T) {
+	srv := NewServer(WithDimensions(4))
+	defer srv.Close()
+
+	t.Run("rate limited requests are retried", func(t *testing.T) {
+		srv.Reset()
+		srv.RateLimit(2)
+
+		client := srv.Client(openaicli.WithRetries(2, time.Millisecond))
+
+		_, err := client.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Input: "text"})
+		require.NoError(t, err)
+		assert.Len(t, srv.Requests(), 3)
+	})
+
+	t.Run("errors are returned", func(t *testing.T) {
+		srv.Reset()
+		srv.Fail("/v1/chat/completions", http.StatusBadRequest, 1)
+
+		client := srv.Client(openaicli.WithRetries(2, time.Millisecond))
+
+		_, err := client.CreateEmbedding(context.TODO(), openaicli.EmbbedingRequest{Input: "text"})
+		require.NoError(t, err)
+
+		_, err = client.CreateChatCompletition(context.TODO(), openaicli.CompletitionRequest{})
+		require.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "400"))
+		assert.Len(t, srv.Requests(), 2)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		srv.Reset()
+		srv.SetLatency(200 * time.Millisecond)
+		defer srv.SetLatency(0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		_, err := srv.Client().CreateEmbedding(ctx, openaicli.EmbbedingRequest{Input: "text"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -20,3 +20,14 @@ func (m *mockClient) CreateEmbedding(ctx context.Context, in openaicli.Embbeding
 func (m *mockClient) CreateChatCompletition(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
 	return m.CreateChatCompletitionFunc(ctx, in)
 }
+
+var _ StreamingClient = &mockStreamingClient{}
+
+type mockStreamingClient struct {
+	mockClient
+	CreateChatCompletitionStreamFunc func(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error)
+}
+
+func (m *mockStreamingClient) CreateChatCompletitionStream(ctx context.Context, in openaicli.CompletitionRequest, onDelta func(delta string) error) (*openaicli.CompletitionResponse, error) {
+	return m.CreateChatCompletitionStreamFunc(ctx, in, onDelta)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/client/openaicli"
+)
+
+// askFlags are the flags shared by ask and chat.
+type askFlags struct {
+	user         *string
+	collectionID *string
+	topK         *int
+	threshold    *float64
+	model        *string
+}
+
+func (a *app) askFlags(fs *flag.FlagSet) askFlags {
+	return askFlags{
+		user:         fs.String("user", a.defaultUser(), "user owning the collection ($CHATBOT_USER)"),
+		collectionID: fs.String("collection", "", "collection to ask (required)"),
+		topK:         fs.Int("k", 1, "number of chunks used as context"),
+		threshold:    fs.Float64("threshold", 0, "maximum distance of the chunks used as context, 0 for no limit"),
+		model:        fs.String("model", "", "chat model answering the questions (default that of the client)"),
+	}
+}
+
+func (f askFlags) input(question string, history []openaicli.Message) chatbot.AskInput {
+	return chatbot.AskInput{
+		UserID:       *f.user,
+		CollectionID: *f.collectionID,
+		Question:     question,
+		MaxDistance:  *f.threshold,
+		History:      history,
+		ChatModel:    chatbot.OpenAIModel(*f.model),
+	}
+}
+
+func (a *app) ask(ctx context.Context, args []string) error {
+	fs := a.flagSet("ask")
+	flags := a.askFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *flags.collectionID == "" {
+		return errors.New("-collection is required")
+	}
+
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if question == "" {
+		return errors.New("question is required")
+	}
+
+	svc, closeSvc, err := a.newService(ctx, *flags.topK)
+	if err != nil {
+		return err
+	}
+	defer closeSvc()
+
+	if _, err := svc.AskStream(ctx, flags.input(question, nil), a.printDelta); err != nil {
+		return fmt.Errorf("could not ask: %w", err)
+	}
+
+	fmt.Fprintln(a.stdout)
+	return nil
+}
+
+func (a *app) chat(ctx context.Context, args []string) error {
+	fs := a.flagSet("chat")
+	flags := a.askFlags(fs)
+	maxTurns := fs.Int("history", 10, "number of previous questions and answers sent with each question")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *flags.collectionID == "" {
+		return errors.New("-collection is required")
+	}
+
+	svc, closeSvc, err := a.newService(ctx, *flags.topK)
+	if err != nil {
+		return err
+	}
+	defer closeSvc()
+
+	fmt.Fprintln(a.stdout, "Ask a question, /reset to forget the conversation or /exit to quit.")
+
+	var history []openaicli.Message
+
+	scanner := bufio.NewScanner(a.stdin)
+	for {
+		fmt.Fprint(a.stdout, "> ")
+
+		if !scanner.Scan() {
+			fmt.Fprintln(a.stdout)
+			return scanner.Err()
+		}
+
+		question := strings.TrimSpace(scanner.Text())
+
+		switch question {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return nil
+		case "/reset":
+			history = nil
+			continue
+		}
+
+		result, err := svc.AskStream(ctx, flags.input(question, history), a.printDelta)
+		fmt.Fprintln(a.stdout)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			fmt.Fprintln(a.stderr, "could not ask:", err)
+			continue
+		}
+
+		history = append(history,
+			openaicli.Message{Role: "user", Content: question},
+			openaicli.Message{Role: "assistant", Content: result.Answer},
+		)
+
+		if keep := 2 * *maxTurns; len(history) > keep {
+			history = history[len(history)-keep:]
+		}
+	}
+}
+
+func (a *app) printDelta(delta string) error {
+	_, err := fmt.Fprint(a.stdout, delta)
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alesr/chatbot"
+)
+
+// export writes the collection to the output file, or stdout.
+func (a *app) export(ctx context.Context, args []string) error {
+	fs := a.flagSet("export")
+
+	var (
+		user         = fs.String("user", a.defaultUser(), "user owning the collection ($CHATBOT_USER)")
+		collectionID = fs.String("collection", "", "collection to export")
+		format       = fs.String("format", string(chatbot.ExportJSONL), "export format: jsonl or binary")
+		output       = fs.String("o", "", "file to write the export to (default stdout)")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *collectionID == "" {
+		return errors.New("collection is required")
+	}
+
+	if f := chatbot.ExportFormat(*format); f != chatbot.ExportJSONL && f != chatbot.ExportBinary {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	svc, closeSvc, err := a.newCollections(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeSvc()
+
+	in := chatbot.ExportInput{
+		UserID:       *user,
+		CollectionID: *collectionID,
+		Format:       chatbot.ExportFormat(*format),
+	}
+
+	if *output == "" {
+		if err := svc.ExportCollection(ctx, a.stdout, in); err != nil {
+			return fmt.Errorf("could not export: %w", err)
+		}
+		return nil
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+
+	if err := svc.ExportCollection(ctx, f, in); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return fmt.Errorf("could not export: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(*output)
+		return fmt.Errorf("could not close file: %w", err)
+	}
+	return nil
+}
+
+// importCollection imports the export read from the file argument, or stdin,
+// and prints the ID of the imported collection.
+func (a *app) importCollection(ctx context.Context, args []string) error {
+	fs := a.flagSet("import")
+
+	var (
+		user         = fs.String("user", a.defaultUser(), "user owning the imported collection ($CHATBOT_USER)")
+		collectionID = fs.String("collection", "", "ID of the imported collection (default the exported ID)")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() > 1 {
+		return errors.New("import reads a single file")
+	}
+
+	var r io.Reader = a.stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("could not open file: %w", err)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	svc, closeSvc, err := a.newCollections(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeSvc()
+
+	id, err := svc.ImportCollection(ctx, r, chatbot.ImportInput{
+		UserID:       *user,
+		CollectionID: *collectionID,
+	})
+	if err != nil {
+		return fmt.Errorf("could not import: %w", err)
+	}
+
+	fmt.Fprintln(a.stdout, id)
+	return nil
+}
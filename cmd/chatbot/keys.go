@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alesr/chatbot/apikey"
+)
+
+const keysUsage = `usage:
+  chatbot keys issue [-user u] -name n [-scopes read,train,admin] [-collections id,...]
+  chatbot keys list [-user u]
+  chatbot keys revoke key-id`
+
+func (a *app) keys(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(a.stderr, keysUsage)
+		return errors.New("missing keys command")
+	}
+
+	switch args[0] {
+	case "issue":
+		return a.issueKey(ctx, args[1:])
+	case "list":
+		return a.listKeys(ctx, args[1:])
+	case "revoke":
+		return a.revokeKey(ctx, args[1:])
+	default:
+		fmt.Fprintln(a.stderr, keysUsage)
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+}
+
+// issueKey issues a key and prints its secret, which is shown only once.
+func (a *app) issueKey(ctx context.Context, args []string) error {
+	fs := a.flagSet("keys issue")
+
+	var (
+		user        = fs.String("user", a.defaultUser(), "user the key acts on behalf of ($CHATBOT_USER)")
+		name        = fs.String("name", "", "name describing what the key is used for")
+		rawScopes   = fs.String("scopes", string(apikey.ScopeRead), "comma separated scopes: read, train, admin")
+		collections = fs.String("collections", "", "comma separated collections the key is restricted to (default any)")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return errors.New("name is required")
+	}
+
+	scopes, err := apikey.ParseScopes(*rawScopes)
+	if err != nil {
+		return err
+	}
+
+	var collectionIDs []string
+	for _, id := range strings.Split(*collections, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			collectionIDs = append(collectionIDs, id)
+		}
+	}
+
+	keys, closeKeys, err := a.newKeys(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeKeys()
+
+	key, secret, err := keys.Issue(ctx, apikey.IssueInput{
+		UserID:        *user,
+		Name:          *name,
+		Scopes:        scopes,
+		CollectionIDs: collectionIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stderr, "issued %s, the key below will not be shown again\n", key.ID)
+	fmt.Fprintln(a.stdout, secret)
+	return nil
+}
+
+func (a *app) listKeys(ctx context.Context, args []string) error {
+	fs := a.flagSet("keys list")
+
+	user := fs.String("user", a.defaultUser(), "user owning the keys ($CHATBOT_USER)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keys, closeKeys, err := a.newKeys(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeKeys()
+
+	list, err := keys.List(ctx, *user)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCOLLECTIONS\tCREATED\tREVOKED")
+
+	for _, k := range list {
+		scopes := make([]string, 0, len(k.Scopes))
+		for _, s := range k.Scopes {
+			scopes = append(scopes, string(s))
+		}
+
+		collections := "*"
+		if len(k.CollectionIDs) > 0 {
+			collections = strings.Join(k.CollectionIDs, ",")
+		}
+
+		revoked := "-"
+		if k.RevokedAt != nil {
+			revoked = k.RevokedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			k.ID, k.Name, strings.Join(scopes, ","), collections, k.CreatedAt.Format(time.RFC3339), revoked,
+		)
+	}
+	return tw.Flush()
+}
+
+func (a *app) revokeKey(ctx context.Context, args []string) error {
+	fs := a.flagSet("keys revoke")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("exactly one key ID is required")
+	}
+
+	keys, closeKeys, err := a.newKeys(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeKeys()
+
+	return keys.Revoke(ctx, fs.Arg(0))
+}
@@ -0,0 +1,309 @@
+// Command chatbot trains collections and asks them questions from the command line.
+//
+//	chatbot train [-collection id] [-model m] [-metadata json] [path ...]
+//	chatbot ask -collection id [-k n] [-threshold d] [-model m] question
+//	chatbot chat -collection id [-k n] [-threshold d] [-model m] [-history n]
+//	chatbot keys issue|list|revoke ...
+//	chatbot export -collection id [-format jsonl|binary] [-o file]
+//	chatbot import [-collection id] [file]
+//	chatbot reindex -collection id -model m [-dimensions n] [-price usd]
+//	chatbot migrate up|down|version ...
+//
+// train reads files, and directories recursively, or stdin when no path
+// or "-" is given, and prints the collection ID. ask answers a single question
+// and chat starts an interactive conversation, both streaming the answers.
+// export writes a collection to a file, or stdout, and import reads it back,
+// from a file or stdin, into the same or another database, printing its ID.
+// reindex re-embeds a collection with another model, reporting its progress
+// and cost, and swaps the new embeddings in once they are all created.
+// keys manages the API keys of the HTTP server, and migrate the database schema.
+// The commands using the database refuse to run until its schema is migrated.
+//
+// The database and the OpenAI API key are read from the DATABASE_URL
+// and OPENAI_API_KEY environment variables, and the user from CHATBOT_USER,
+// falling back to USER. Collections are stored in files instead of the
+// database when CHATBOT_DATA_DIR is set, and CHATBOT_OFFLINE replaces the
+// OpenAI API with the offline client, which answers with the retrieved context.
+// CHATBOT_OLLAMA_URL replaces it with an Ollama server instead, using the models
+// set by CHATBOT_OLLAMA_EMBEDDING_MODEL and CHATBOT_OLLAMA_CHAT_MODEL.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/client/offline"
+	"github.com/alesr/chatbot/client/ollama"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+const usage = `usage:
+  chatbot train [-collection id] [-model m] [-metadata json] [path ...]
+  chatbot ask -collection id [-k n] [-threshold d] [-model m] question
+  chatbot chat -collection id [-k n] [-threshold d] [-model m] [-history n]
+  chatbot export -collection id [-format jsonl|binary] [-o file]
+  chatbot import [-collection id] [file]
+  chatbot reindex -collection id -model m [-dimensions n] [-price usd]
+  chatbot keys issue [-user u] -name n [-scopes read,train,admin] [-collections id,...]
+  chatbot keys list [-user u]
+  chatbot keys revoke key-id
+  chatbot migrate up|version
+  chatbot migrate down [-steps n]`
+
+// service is the part of chatbot.Service used by the commands.
+type service interface {
+	Train(ctx context.Context, in chatbot.TrainInput) (string, error)
+	AskStream(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error)
+}
+
+// collectionPorter is the part of chatbot.Service used by the export and import commands.
+type collectionPorter interface {
+	ExportCollection(ctx context.Context, w io.Writer, in chatbot.ExportInput) error
+	ImportCollection(ctx context.Context, r io.Reader, in chatbot.ImportInput) (string, error)
+}
+
+// reindexer is the part of chatbot.Service used by the reindex command.
+type reindexer interface {
+	ReindexCollection(ctx context.Context, in chatbot.ReindexInput) (*chatbot.ReindexResult, error)
+}
+
+// keyManager is the part of apikey.Manager used by the keys commands.
+type keyManager interface {
+	Issue(ctx context.Context, in apikey.IssueInput) (*apikey.Key, string, error)
+	List(ctx context.Context, userID string) ([]apikey.Key, error)
+	Revoke(ctx context.Context, keyID string) error
+}
+
+// app runs the commands against the given streams and environment.
+type app struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	// newService returns the service, retrieving topK chunks per question,
+	// and a function releasing its resources.
+	newService func(ctx context.Context, topK int) (service, func() error, error)
+
+	// newCollections returns the service moving collections
+	// and a function releasing its resources.
+	newCollections func(ctx context.Context) (collectionPorter, func() error, error)
+
+	// newReindexer returns the service reindexing collections, estimating
+	// their cost from the prices, and a function releasing its resources.
+	newReindexer func(ctx context.Context, prices chatbot.PriceTable) (reindexer, func() error, error)
+
+	// newKeys returns the API key manager and a function releasing its resources.
+	newKeys func(ctx context.Context) (keyManager, func() error, error)
+
+	// newMigrator returns the schema migrator and a function releasing its resources.
+	newMigrator func(ctx context.Context) (migrator, func() error, error)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := &app{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		getenv: os.Getenv,
+	}
+	a.newService = a.postgresService
+	a.newCollections = a.postgresCollections
+	a.newReindexer = a.postgresReindexer
+	a.newKeys = a.postgresKeys
+	a.newMigrator = a.postgresMigrator
+
+	if err := a.run(ctx, os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "chatbot:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func (a *app) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(a.stderr, usage)
+		return flag.ErrHelp
+	}
+
+	switch args[0] {
+	case "train":
+		return a.train(ctx, args[1:])
+	case "ask":
+		return a.ask(ctx, args[1:])
+	case "chat":
+		return a.chat(ctx, args[1:])
+	case "export":
+		return a.export(ctx, args[1:])
+	case "import":
+		return a.importCollection(ctx, args[1:])
+	case "reindex":
+		return a.reindex(ctx, args[1:])
+	case "keys":
+		return a.keys(ctx, args[1:])
+	case "migrate":
+		return a.migrate(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprintln(a.stdout, usage)
+		return nil
+	default:
+		fmt.Fprintln(a.stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// postgresService connects to the database, or opens the file store when
+// CHATBOT_DATA_DIR is set, and returns a service backed by it and by the client.
+func (a *app) postgresService(ctx context.Context, topK int) (service, func() error, error) {
+	client, err := a.newClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, closeRepo, err := a.openRepository(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chatbot.NewService(a.getenv("OPENAI_API_KEY"), client, client, repo, chatbot.WithTopK(topK)), closeRepo, nil
+}
+
+// postgresReindexer opens the repository and the client like postgresService,
+// and returns a service reindexing its collections.
+func (a *app) postgresReindexer(ctx context.Context, prices chatbot.PriceTable) (reindexer, func() error, error) {
+	client, err := a.newClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, closeRepo, err := a.openRepository(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chatbot.NewService(a.getenv("OPENAI_API_KEY"), client, client, repo, chatbot.WithPrices(prices)), closeRepo, nil
+}
+
+// newClient returns the OpenAI API client, the offline client when CHATBOT_OFFLINE
+// is set, or the Ollama client when CHATBOT_OLLAMA_URL is set.
+func (a *app) newClient() (chatbot.Client, error) {
+	apiKey := a.getenv("OPENAI_API_KEY")
+
+	switch {
+	case a.getenv("CHATBOT_OFFLINE") != "":
+		return offline.New(), nil
+	case a.getenv("CHATBOT_OLLAMA_URL") != "":
+		opts := []ollama.Option{ollama.WithBaseURL(a.getenv("CHATBOT_OLLAMA_URL"))}
+		if model := a.getenv("CHATBOT_OLLAMA_EMBEDDING_MODEL"); model != "" {
+			opts = append(opts, ollama.WithEmbeddingModel(model))
+		}
+		if model := a.getenv("CHATBOT_OLLAMA_CHAT_MODEL"); model != "" {
+			opts = append(opts, ollama.WithChatModel(model))
+		}
+		return ollama.New(&http.Client{}, opts...), nil
+	case apiKey == "":
+		return nil, errors.New("OPENAI_API_KEY is required")
+	default:
+		return openaicli.New(apiKey, &http.Client{}, openaicli.WithRetries(3, 500*time.Millisecond)), nil
+	}
+}
+
+// postgresCollections opens the repository like postgresService, and returns
+// a service moving its collections, which needs no embedding or chat model.
+func (a *app) postgresCollections(ctx context.Context) (collectionPorter, func() error, error) {
+	repo, closeRepo, err := a.openRepository(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chatbot.NewService("", nil, nil, repo), closeRepo, nil
+}
+
+// openRepository connects to the database, or opens the file store when
+// CHATBOT_DATA_DIR is set, and returns it with a function closing it.
+func (a *app) openRepository(ctx context.Context) (chatbot.Repository, func() error, error) {
+	if dataDir := a.getenv("CHATBOT_DATA_DIR"); dataDir != "" {
+		files, err := storage.OpenFileStore(dataDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return files, files.Close, nil
+	}
+
+	if a.getenv("DATABASE_URL") == "" {
+		return nil, nil, errors.New("DATABASE_URL or CHATBOT_DATA_DIR is required")
+	}
+
+	db, err := a.connect(ctx, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return storage.NewPostgres(db), db.Close, nil
+}
+
+// postgresKeys connects to the database and returns a key manager backed by it.
+func (a *app) postgresKeys(ctx context.Context) (keyManager, func() error, error) {
+	db, err := a.connect(ctx, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return apikey.NewManager(storage.NewPostgres(db)), db.Close, nil
+}
+
+// postgresMigrator connects to the database and returns a migrator of its schema.
+func (a *app) postgresMigrator(ctx context.Context) (migrator, func() error, error) {
+	db, err := a.connect(ctx, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return postgresMigrator{db: db}, db.Close, nil
+}
+
+// connect connects to the database, checking that its schema
+// is migrated if checkSchema is set.
+func (a *app) connect(ctx context.Context, checkSchema bool) (*sqlx.DB, error) {
+	databaseURL := a.getenv("DATABASE_URL")
+	if databaseURL == "" {
+		return nil, errors.New("DATABASE_URL is required")
+	}
+
+	db, err := sqlx.ConnectContext(ctx, "postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to database: %w", err)
+	}
+
+	if checkSchema {
+		if err := storage.CheckSchema(ctx, db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%w, run chatbot migrate up", err)
+		}
+	}
+	return db, nil
+}
+
+// defaultUser returns the user the commands act on behalf of.
+func (a *app) defaultUser() string {
+	if user := a.getenv("CHATBOT_USER"); user != "" {
+		return user
+	}
+	return a.getenv("USER")
+}
+
+func (a *app) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
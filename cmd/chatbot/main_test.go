@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/client/offline"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockService struct {
+	TrainFunc     func(ctx context.Context, in chatbot.TrainInput) (string, error)
+	AskStreamFunc func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error)
+}
+
+func (m *mockService) Train(ctx context.Context, in chatbot.TrainInput) (string, error) {
+	return m.TrainFunc(ctx, in)
+}
+
+func (m *mockService) AskStream(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+	return m.AskStreamFunc(ctx, in, onDelta)
+}
+
+func newTestApp(svc service, stdin string) (*app, *bytes.Buffer) {
+	var stdout bytes.Buffer
+
+	return &app{
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: io.Discard,
+		getenv: func(key string) string {
+			if key == "USER" {
+				return "user-1"
+			}
+			return ""
+		},
+		newService: func(ctx context.Context, topK int) (service, func() error, error) {
+			return svc, func() error { return nil }, nil
+		},
+	}, &stdout
+}
+
+func TestTrain(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs", ".git"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "a.txt"), []byte("file a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", ".hidden"), []byte("hidden"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", ".git", "config"), []byte("git"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("file b"), 0o644))
+
+	var texts []string
+
+	svc := mockService{
+		TrainFunc: func(ctx context.Context, in chatbot.TrainInput) (string, error) {
+			assert.Equal(t, "user-1", in.UserID)
+			assert.Equal(t, map[string]any{"source": "docs"}, in.Metadata)
+
+			for _, d := range in.Data {
+				b, err := io.ReadAll(d)
+				require.NoError(t, err)
+				texts = append(texts, string(b))
+			}
+			return "coll-1", nil
+		},
+	}
+
+	a, stdout := newTestApp(&svc, "from stdin")
+
+	err := a.run(context.Background(), []string{
+		"train", "-metadata", `{"source": "docs"}`,
+		filepath.Join(dir, "docs"), filepath.Join(dir, "b.txt"), "-",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"file a", "file b", "from stdin"}, texts)
+	assert.Equal(t, "coll-1\n", stdout.String())
+}
+
+func TestAsk(t *testing.T) {
+	svc := mockService{
+		AskStreamFunc: func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+			assert.Equal(t, "coll-1", in.CollectionID)
+			assert.Equal(t, "what is it?", in.Question)
+			assert.Equal(t, 0.4, in.MaxDistance)
+			assert.Equal(t, chatbot.OpenAIModel("gpt-4o-mini"), in.ChatModel)
+
+			require.NoError(t, onDelta("it is "))
+			require.NoError(t, onDelta("this"))
+			return &chatbot.AskResult{Answer: "it is this"}, nil
+		},
+	}
+
+	a, stdout := newTestApp(&svc, "")
+
+	err := a.run(context.Background(), []string{"ask", "-collection", "coll-1", "-threshold", "0.4", "-model", "gpt-4o-mini", "what", "is", "it?"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "it is this\n", stdout.String())
+}
+
+func TestChat(t *testing.T) {
+	var histories [][]openaicli.Message
+
+	svc := mockService{
+		AskStreamFunc: func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+			histories = append(histories, in.History)
+
+			answer := "answer to " + in.Question
+			require.NoError(t, onDelta(answer))
+			return &chatbot.AskResult{Answer: answer}, nil
+		},
+	}
+
+	a, _ := newTestApp(&svc, "first\nsecond\nthird\n/reset\nfourth\n/exit\nignored\n")
+
+	err := a.run(context.Background(), []string{"chat", "-collection", "coll-1", "-history", "1"})
+	require.NoError(t, err)
+
+	require.Len(t, histories, 4)
+
+	assert.Empty(t, histories[0])
+	assert.Equal(t, []openaicli.Message{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "answer to first"},
+	}, histories[1])
+	assert.Equal(t, []openaicli.Message{
+		{Role: "user", Content: "second"},
+		{Role: "assistant", Content: "answer to second"},
+	}, histories[2])
+	assert.Empty(t, histories[3])
+}
+
+type mockKeyManager struct {
+	IssueFunc  func(ctx context.Context, in apikey.IssueInput) (*apikey.Key, string, error)
+	ListFunc   func(ctx context.Context, userID string) ([]apikey.Key, error)
+	RevokeFunc func(ctx context.Context, keyID string) error
+}
+
+func (m *mockKeyManager) Issue(ctx context.Context, in apikey.IssueInput) (*apikey.Key, string, error) {
+	return m.IssueFunc(ctx, in)
+}
+
+func (m *mockKeyManager) List(ctx context.Context, userID string) ([]apikey.Key, error) {
+	return m.ListFunc(ctx, userID)
+}
+
+func (m *mockKeyManager) Revoke(ctx context.Context, keyID string) error {
+	return m.RevokeFunc(ctx, keyID)
+}
+
+func TestKeys(t *testing.T) {
+	var revoked string
+
+	keys := mockKeyManager{
+		IssueFunc: func(ctx context.Context, in apikey.IssueInput) (*apikey.Key, string, error) {
+			assert.Equal(t, apikey.IssueInput{
+				UserID:        "user-1",
+				Name:          "ci",
+				Scopes:        []apikey.Scope{apikey.ScopeRead, apikey.ScopeTrain},
+				CollectionIDs: []string{"coll-1", "coll-2"},
+			}, in)
+			return &apikey.Key{ID: "key-1"}, "cbk_secret", nil
+		},
+		RevokeFunc: func(ctx context.Context, keyID string) error {
+			revoked = keyID
+			return nil
+		},
+	}
+
+	a, stdout := newTestApp(&mockService{}, "")
+	a.newKeys = func(ctx context.Context) (keyManager, func() error, error) {
+		return &keys, func() error { return nil }, nil
+	}
+
+	err := a.run(context.Background(), []string{
+		"keys", "issue", "-name", "ci", "-scopes", "read,train", "-collections", "coll-1, coll-2",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "cbk_secret\n", stdout.String())
+
+	require.NoError(t, a.run(context.Background(), []string{"keys", "revoke", "key-1"}))
+	assert.Equal(t, "key-1", revoked)
+
+	err = a.run(context.Background(), []string{"keys", "issue", "-name", "ci", "-scopes", "write"})
+	assert.Error(t, err)
+}
+
+type mockMigrator struct {
+	UpFunc      func(ctx context.Context) error
+	DownFunc    func(ctx context.Context, n int) error
+	VersionFunc func(ctx context.Context) (int, bool, error)
+}
+
+func (m *mockMigrator) Up(ctx context.Context) error {
+	return m.UpFunc(ctx)
+}
+
+func (m *mockMigrator) Down(ctx context.Context, n int) error {
+	return m.DownFunc(ctx, n)
+}
+
+func (m *mockMigrator) Version(ctx context.Context) (int, bool, error) {
+	return m.VersionFunc(ctx)
+}
+
+func TestMigrate(t *testing.T) {
+	var (
+		version = storage.NoSchemaVersion
+		dirty   bool
+		downs   []int
+	)
+
+	m := mockMigrator{
+		UpFunc: func(ctx context.Context) error {
+			version = 8
+			return nil
+		},
+		DownFunc: func(ctx context.Context, n int) error {
+			downs = append(downs, n)
+			version -= n
+			return nil
+		},
+		VersionFunc: func(ctx context.Context) (int, bool, error) {
+			return version, dirty, nil
+		},
+	}
+
+	a, stdout := newTestApp(&mockService{}, "")
+	a.newMigrator = func(ctx context.Context) (migrator, func() error, error) {
+		return &m, func() error { return nil }, nil
+	}
+
+	require.NoError(t, a.run(context.Background(), []string{"migrate", "version"}))
+	require.NoError(t, a.run(context.Background(), []string{"migrate", "up"}))
+	require.NoError(t, a.run(context.Background(), []string{"migrate", "down"}))
+	require.NoError(t, a.run(context.Background(), []string{"migrate", "down", "-steps", "2"}))
+
+	dirty = true
+	require.NoError(t, a.run(context.Background(), []string{"migrate", "version"}))
+
+	assert.Equal(t, "none\n8\n7\n5\n5 (dirty)\n", stdout.String())
+	assert.Equal(t, []int{1, 2}, downs)
+
+	assert.Error(t, a.run(context.Background(), []string{"migrate", "down", "-steps", "0"}))
+	assert.Error(t, a.run(context.Background(), []string{"migrate", "sideways"}))
+	assert.Error(t, a.run(context.Background(), []string{"migrate"}))
+}
+
+func TestExportImport(t *testing.T) {
+	client := offline.New(offline.WithDimensions(8))
+	svc := chatbot.NewService("", client, client, storage.NewMemory())
+
+	collectionID, err := svc.Train(context.Background(), chatbot.TrainInput{
+		UserID: "user-1",
+		Data:   []io.Reader{strings.NewReader("Employees get 25 vacation days per year.")},
+	})
+	require.NoError(t, err)
+
+	a, stdout := newTestApp(&mockService{}, "")
+	a.newCollections = func(ctx context.Context) (collectionPorter, func() error, error) {
+		return svc, func() error { return nil }, nil
+	}
+
+	path := filepath.Join(t.TempDir(), "collection.bin")
+
+	require.NoError(t, a.run(context.Background(), []string{"export", "-collection", collectionID, "-format", "binary", "-o", path}))
+	assert.Empty(t, stdout.String())
+
+	require.NoError(t, a.run(context.Background(), []string{"import", "-collection", "coll-copy", path}))
+	assert.Equal(t, "coll-copy\n", stdout.String())
+
+	stdout.Reset()
+	require.NoError(t, a.run(context.Background(), []string{"export", "-collection", "coll-copy"}))
+	assert.Contains(t, stdout.String(), `"collection_id":"coll-copy"`)
+
+	a.stdin = strings.NewReader(stdout.String())
+	stdout.Reset()
+	err = a.run(context.Background(), []string{"import"})
+	assert.ErrorIs(t, err, chatbot.ErrCollectionExists)
+
+	assert.Error(t, a.run(context.Background(), []string{"export", "-collection", collectionID, "-format", "xml"}))
+	assert.Error(t, a.run(context.Background(), []string{"export", "-collection", "missing", "-o", path}))
+	assert.NoFileExists(t, path)
+}
+
+func TestReindex(t *testing.T) {
+	client := offline.New(offline.WithDimensions(8))
+	repo := storage.NewMemory()
+	svc := chatbot.NewService("", client, client, repo)
+
+	collectionID, err := svc.Train(context.Background(), chatbot.TrainInput{
+		UserID: "user-1",
+		Data:   []io.Reader{strings.NewReader("Employees get 25 vacation days per year.")},
+	})
+	require.NoError(t, err)
+
+	a, stdout := newTestApp(&mockService{}, "")
+
+	var stderr bytes.Buffer
+	a.stderr = &stderr
+
+	a.newReindexer = func(ctx context.Context, prices chatbot.PriceTable) (reindexer, func() error, error) {
+		return chatbot.NewService("", client, client, repo, chatbot.WithPrices(prices)), func() error { return nil }, nil
+	}
+
+	require.NoError(t, a.run(context.Background(), []string{
+		"reindex", "-collection", collectionID, "-model", "text-embedding-3-small", "-dimensions", "4", "-price", "1000",
+	}))
+	assert.Contains(t, stderr.String(), "reindexed 1/1 chunks (100%)")
+	assert.Contains(t, stdout.String(), collectionID+" reindexed with text-embedding-3-small: 1 chunks")
+
+	collection, err := svc.Collection(context.Background(), "user-1", collectionID)
+	require.NoError(t, err)
+	assert.Equal(t, "text-embedding-3-small", collection.Model)
+	assert.Equal(t, 4, collection.Dimensions)
+
+	assert.ErrorContains(t, a.run(context.Background(), []string{"reindex", "-collection", collectionID}), "model is required")
+	assert.ErrorIs(t, a.run(context.Background(), []string{"reindex", "-collection", "missing", "-model", "m"}), storage.ErrNotFound)
+}
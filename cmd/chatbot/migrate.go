@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+const migrateUsage = `usage:
+  chatbot migrate up
+  chatbot migrate down [-steps n]
+  chatbot migrate version`
+
+// migrator applies the schema migrations of the database.
+type migrator interface {
+	Up(ctx context.Context) error
+	Down(ctx context.Context, n int) error
+	Version(ctx context.Context) (version int, dirty bool, err error)
+}
+
+func (a *app) migrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(a.stderr, migrateUsage)
+		return errors.New("missing migrate command")
+	}
+
+	switch args[0] {
+	case "up", "down", "version":
+	default:
+		fmt.Fprintln(a.stderr, migrateUsage)
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	fs := a.flagSet("migrate " + args[0])
+
+	var steps *int
+	if args[0] == "down" {
+		steps = fs.Int("steps", 1, "number of migrations to revert")
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if steps != nil && *steps < 1 {
+		return errors.New("steps must be positive")
+	}
+
+	m, closeMigrator, err := a.newMigrator(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeMigrator()
+
+	switch args[0] {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx, *steps)
+	}
+	if err != nil {
+		return err
+	}
+	return a.printSchemaVersion(ctx, m)
+}
+
+// printSchemaVersion prints the version of the last applied migration,
+// or none, followed by dirty if it failed halfway.
+func (a *app) printSchemaVersion(ctx context.Context, m migrator) error {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := "none"
+	if version != storage.NoSchemaVersion {
+		out = strconv.Itoa(version)
+	}
+
+	if dirty {
+		out += " (dirty)"
+	}
+
+	fmt.Fprintln(a.stdout, out)
+	return nil
+}
+
+// postgresMigrator migrates the Postgres database with the embedded migrations.
+type postgresMigrator struct {
+	db *sqlx.DB
+}
+
+func (m postgresMigrator) Up(ctx context.Context) error {
+	return storage.Migrate(ctx, m.db)
+}
+
+func (m postgresMigrator) Down(ctx context.Context, n int) error {
+	return storage.MigrateDown(ctx, m.db, n)
+}
+
+func (m postgresMigrator) Version(ctx context.Context) (int, bool, error) {
+	return storage.SchemaVersion(ctx, m.db)
+}
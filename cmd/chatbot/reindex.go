@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alesr/chatbot"
+)
+
+// reindex re-embeds the collection with another model, printing
+// the progress to stderr and a summary once the collection is swapped.
+func (a *app) reindex(ctx context.Context, args []string) error {
+	fs := a.flagSet("reindex")
+
+	var (
+		user         = fs.String("user", a.defaultUser(), "user owning the collection ($CHATBOT_USER)")
+		collectionID = fs.String("collection", "", "collection to reindex")
+		model        = fs.String("model", "", "new embedding model")
+		dimensions   = fs.Int("dimensions", 0, "dimensions requested from the model, 0 for its default")
+		price        = fs.Float64("price", 0, "price in USD per thousand tokens of the model, to estimate the cost")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *collectionID == "" {
+		return errors.New("collection is required")
+	}
+
+	if *model == "" {
+		return errors.New("model is required")
+	}
+
+	if *dimensions < 0 {
+		return errors.New("dimensions must not be negative")
+	}
+
+	svc, closeSvc, err := a.newReindexer(ctx, chatbot.PriceTable{*model: {Prompt: *price}})
+	if err != nil {
+		return err
+	}
+	defer closeSvc()
+
+	// Progress is printed once per percent, so large collections do not flood stderr.
+	lastPercent := int64(-1)
+
+	result, err := svc.ReindexCollection(ctx, chatbot.ReindexInput{
+		UserID:       *user,
+		CollectionID: *collectionID,
+		Model:        chatbot.OpenAIModel(*model),
+		Dimensions:   *dimensions,
+		OnProgress: func(p chatbot.ReindexProgress) {
+			percent := p.Done * 100 / p.Total
+			if percent == lastPercent {
+				return
+			}
+			lastPercent = percent
+
+			fmt.Fprintf(a.stderr, "reindexed %d/%d chunks (%d%%), %d tokens, $%.4f\n", p.Done, p.Total, percent, p.Tokens, p.Cost)
+		},
+	})
+	if errors.Is(err, chatbot.ErrCollectionChanged) {
+		return fmt.Errorf("could not reindex: %w, try again", err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not reindex: %w", err)
+	}
+
+	fmt.Fprintf(a.stdout, "%s reindexed with %s: %d chunks, %d tokens, estimated cost $%.4f\n",
+		result.CollectionID, result.Model, result.Chunks, result.Tokens, result.Cost)
+	return nil
+}
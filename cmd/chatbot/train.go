@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alesr/chatbot"
+)
+
+func (a *app) train(ctx context.Context, args []string) error {
+	fs := a.flagSet("train")
+
+	var (
+		user         = fs.String("user", a.defaultUser(), "user owning the collection ($CHATBOT_USER)")
+		collectionID = fs.String("collection", "", "existing collection to add the documents to")
+		model        = fs.String("model", "", "embedding model (default text-embedding-ada-002, or the Ollama embedding model)")
+		rawMetadata  = fs.String("metadata", "", "JSON object stored with every chunk")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var metadata map[string]any
+	if *rawMetadata != "" {
+		if err := json.Unmarshal([]byte(*rawMetadata), &metadata); err != nil {
+			return fmt.Errorf("metadata must be a JSON object: %w", err)
+		}
+	}
+
+	data, closeFiles, err := a.openData(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer closeFiles()
+
+	if len(data) == 0 {
+		return errors.New("no files to train")
+	}
+
+	svc, closeSvc, err := a.newService(ctx, 1)
+	if err != nil {
+		return err
+	}
+	defer closeSvc()
+
+	id, err := svc.Train(ctx, chatbot.TrainInput{
+		UserID:       *user,
+		CollectionID: *collectionID,
+		Model:        chatbot.OpenAIModel(*model),
+		Data:         data,
+		Metadata:     metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("could not train: %w", err)
+	}
+
+	fmt.Fprintln(a.stdout, id)
+	return nil
+}
+
+// openData opens the files at the paths, walking directories recursively
+// and skipping hidden entries. Stdin is read when there are no paths or for "-".
+// The returned function closes the opened files.
+func (a *app) openData(paths []string) ([]io.Reader, func(), error) {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	var (
+		data  []io.Reader
+		files []*os.File
+	)
+
+	closeFiles := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+
+	open := func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("could not open file: %w", err)
+		}
+
+		files = append(files, f)
+		data = append(data, f)
+		return nil
+	}
+
+	for _, path := range paths {
+		if path == "-" {
+			data = append(data, a.stdin)
+			continue
+		}
+
+		if err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if p != path && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			return open(p)
+		}); err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+	}
+	return data, closeFiles, nil
+}
@@ -0,0 +1,375 @@
+// Command chatbotd serves the chatbot HTTP API, and optionally the gRPC API.
+//
+// Every flag can also be set with the environment variable shown in its usage.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/client/offline"
+	"github.com/alesr/chatbot/client/ollama"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/grpcapi"
+	"github.com/alesr/chatbot/grpcapi/chatbotv1"
+	"github.com/alesr/chatbot/httpapi"
+	"github.com/alesr/chatbot/storage"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/grpc"
+)
+
+type config struct {
+	addr            string
+	grpcAddr        string
+	databaseURL     string
+	dataDir         string
+	openAIAPIKey    string
+	topK            int
+	maxUploadSize   int64
+	requestTimeout  time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	shutdownTimeout time.Duration
+	openAIRetries   int
+	models          map[string]string
+	auth            string
+	offline         bool
+	ollamaURL       string
+	ollamaEmbedding string
+	ollamaChat      string
+	migrate         bool
+	logLevel        slog.Level
+	logFormat       string
+}
+
+const (
+	authAPIKey string = "apikey"
+	authHeader string = "header"
+
+	logText string = "text"
+	logJSON string = "json"
+)
+
+// loadConfig parses the flags, defaulting each one to its environment variable.
+func loadConfig(args []string, getenv func(string) string) (*config, error) {
+	fs := flag.NewFlagSet("chatbotd", flag.ContinueOnError)
+
+	var cfg config
+
+	envString := func(p *string, name, env, value, usage string) {
+		if v := getenv(env); v != "" {
+			value = v
+		}
+		fs.StringVar(p, name, value, usage+" ($"+env+")")
+	}
+
+	var errs []error
+
+	envInt := func(p *int, name, env string, value int, usage string) {
+		if v := getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", env, err))
+			}
+			value = n
+		}
+		fs.IntVar(p, name, value, usage+" ($"+env+")")
+	}
+
+	envDuration := func(p *time.Duration, name, env string, value time.Duration, usage string) {
+		if v := getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", env, err))
+			}
+			value = d
+		}
+		fs.DurationVar(p, name, value, usage+" ($"+env+")")
+	}
+
+	envBool := func(p *bool, name, env string, usage string) {
+		var value bool
+		if v := getenv(env); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", env, err))
+			}
+			value = b
+		}
+		fs.BoolVar(p, name, value, usage+" ($"+env+")")
+	}
+
+	var (
+		maxUploadMB int
+		models      string
+		logLevel    string
+	)
+
+	envString(&cfg.addr, "addr", "CHATBOT_ADDR", ":8080", "address to listen on")
+	envString(&cfg.grpcAddr, "grpc-addr", "CHATBOT_GRPC_ADDR", "", "address to serve the gRPC API on, disabled when empty")
+	envString(&cfg.databaseURL, "database-url", "DATABASE_URL", "", "Postgres connection URL")
+	envString(&cfg.dataDir, "data-dir", "CHATBOT_DATA_DIR", "", "directory storing the collections in files instead of Postgres")
+	envString(&cfg.openAIAPIKey, "openai-api-key", "OPENAI_API_KEY", "", "OpenAI API key")
+	envString(&cfg.auth, "auth", "CHATBOT_AUTH", authAPIKey, "how HTTP and gRPC requests are authenticated: apikey, or header to trust the X-User-ID header or x-user-id metadata set by a gateway")
+	envString(&models, "models", "CHATBOT_MODELS", "", "model names of the OpenAI compatible API, as name=collection-id pairs separated by commas")
+	envBool(&cfg.offline, "offline", "CHATBOT_OFFLINE", "use the offline client, which answers with the retrieved context, instead of the OpenAI API")
+	envString(&cfg.ollamaURL, "ollama-url", "CHATBOT_OLLAMA_URL", "", "address of an Ollama server to use instead of the OpenAI API")
+	envString(&cfg.ollamaEmbedding, "ollama-embedding-model", "CHATBOT_OLLAMA_EMBEDDING_MODEL", ollama.DefaultEmbeddingModel, "Ollama model embedding texts")
+	envString(&cfg.ollamaChat, "ollama-chat-model", "CHATBOT_OLLAMA_CHAT_MODEL", ollama.DefaultChatModel, "Ollama model answering questions")
+	envBool(&cfg.migrate, "migrate", "CHATBOT_MIGRATE", "apply the pending database migrations on startup, instead of refusing to start")
+	envString(&logLevel, "log-level", "CHATBOT_LOG_LEVEL", "info", "minimum level of the logs: debug, info, warn or error")
+	envString(&cfg.logFormat, "log-format", "CHATBOT_LOG_FORMAT", logText, "format of the logs: text or json")
+	envInt(&cfg.topK, "top-k", "CHATBOT_TOP_K", 1, "number of chunks used as context")
+	envInt(&maxUploadMB, "max-upload-mb", "CHATBOT_MAX_UPLOAD_MB", 32, "maximum size of a training upload in MB")
+	envInt(&cfg.openAIRetries, "openai-retries", "CHATBOT_OPENAI_RETRIES", 3, "retries of rate limited and failed OpenAI requests")
+	envDuration(&cfg.requestTimeout, "request-timeout", "CHATBOT_REQUEST_TIMEOUT", 60*time.Second, "maximum duration of a request")
+	envDuration(&cfg.readTimeout, "read-timeout", "CHATBOT_READ_TIMEOUT", 30*time.Second, "maximum duration for reading a request")
+	envDuration(&cfg.writeTimeout, "write-timeout", "CHATBOT_WRITE_TIMEOUT", 90*time.Second, "maximum duration for writing a response")
+	envDuration(&cfg.shutdownTimeout, "shutdown-timeout", "CHATBOT_SHUTDOWN_TIMEOUT", 30*time.Second, "maximum duration of a graceful shutdown")
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg.maxUploadSize = int64(maxUploadMB) << 20
+
+	if models != "" {
+		cfg.models = make(map[string]string)
+		for _, pair := range strings.Split(models, ",") {
+			name, collectionID, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || name == "" || collectionID == "" {
+				return nil, fmt.Errorf("invalid model mapping %q", pair)
+			}
+			cfg.models[name] = collectionID
+		}
+	}
+
+	if err := cfg.logLevel.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", logLevel)
+	}
+
+	if cfg.logFormat != logText && cfg.logFormat != logJSON {
+		return nil, fmt.Errorf("invalid log format %q", cfg.logFormat)
+	}
+
+	if cfg.auth != authAPIKey && cfg.auth != authHeader {
+		return nil, fmt.Errorf("invalid authentication %q", cfg.auth)
+	}
+
+	if cfg.databaseURL == "" && cfg.dataDir == "" {
+		return nil, errors.New("database URL or data directory is required")
+	}
+
+	if cfg.databaseURL == "" && cfg.auth == authAPIKey {
+		return nil, errors.New("API key authentication requires a database URL")
+	}
+
+	if cfg.offline && cfg.ollamaURL != "" {
+		return nil, errors.New("offline mode and Ollama are exclusive")
+	}
+
+	if cfg.openAIAPIKey == "" && !cfg.offline && cfg.ollamaURL == "" {
+		return nil, errors.New("OpenAI API key is required")
+	}
+	return &cfg, nil
+}
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "could not load config: %s\n", err)
+		os.Exit(2)
+	}
+
+	logger := newLogger(cfg, os.Stderr)
+
+	if err := run(cfg, logger); err != nil {
+		logger.Error("could not run", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newLogger returns the logger writing to w at the configured level and format.
+func newLogger(cfg *config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.logLevel}
+
+	if cfg.logFormat == logJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+func run(cfg *config, logger *slog.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		repo chatbot.Repository
+		pg   *storage.Postgres
+	)
+
+	if cfg.databaseURL != "" {
+		db, err := sqlx.ConnectContext(ctx, "postgres", cfg.databaseURL)
+		if err != nil {
+			return fmt.Errorf("could not connect to database: %w", err)
+		}
+		defer db.Close()
+
+		if cfg.migrate {
+			if err := storage.Migrate(ctx, db); err != nil {
+				return fmt.Errorf("could not migrate database: %w", err)
+			}
+		}
+
+		if err := storage.CheckSchema(ctx, db); err != nil {
+			return fmt.Errorf("%w, run chatbot migrate up or start with -migrate", err)
+		}
+
+		pg = storage.NewPostgres(db)
+		repo = pg
+	}
+
+	if cfg.dataDir != "" {
+		files, err := storage.OpenFileStore(cfg.dataDir)
+		if err != nil {
+			return err
+		}
+		defer files.Close()
+
+		repo = files
+	}
+
+	var client chatbot.Client = openaicli.New(cfg.openAIAPIKey, &http.Client{},
+		openaicli.WithLogger(logger),
+		openaicli.WithRetries(cfg.openAIRetries, 500*time.Millisecond),
+	)
+
+	switch {
+	case cfg.offline:
+		client = offline.New()
+	case cfg.ollamaURL != "":
+		client = ollama.New(&http.Client{},
+			ollama.WithBaseURL(cfg.ollamaURL),
+			ollama.WithEmbeddingModel(cfg.ollamaEmbedding),
+			ollama.WithChatModel(cfg.ollamaChat),
+		)
+	}
+
+	svc := chatbot.NewService(cfg.openAIAPIKey, client, client, repo,
+		chatbot.WithTopK(cfg.topK),
+		chatbot.WithLogger(logger),
+		chatbot.WithTextRedaction(),
+	)
+
+	var keys *apikey.Manager
+	authenticator := httpapi.HeaderAuthenticator
+	if cfg.auth == authAPIKey {
+		keys = apikey.NewManager(pg)
+		authenticator = httpapi.APIKeyAuthenticator(keys)
+	}
+
+	srv := &http.Server{
+		Addr: cfg.addr,
+		Handler: httpapi.New(svc,
+			httpapi.WithAuthenticator(authenticator),
+			httpapi.WithMaxUploadSize(cfg.maxUploadSize),
+			httpapi.WithRequestTimeout(cfg.requestTimeout),
+			httpapi.WithLogger(logger),
+			httpapi.WithModels(cfg.models),
+		),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		logger.Info("listening", "addr", cfg.addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var grpcSrv *grpc.Server
+	if cfg.grpcAddr != "" {
+		lis, err := net.Listen("tcp", cfg.grpcAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen: %w", err)
+		}
+
+		grpcSrv = newGRPCServer(cfg, svc, keys, logger)
+
+		go func() {
+			logger.Info("serving gRPC", "addr", cfg.grpcAddr)
+			serveErr <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("could not serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+
+	if grpcSrv != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcSrv.Stop()
+		}
+	}
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not shut down gracefully: %w", err)
+	}
+	return nil
+}
+
+// newGRPCServer returns the gRPC server of the service, authenticating
+// calls like the HTTP server: with the API keys, or with the x-user-id
+// metadata when header authentication is configured.
+func newGRPCServer(cfg *config, svc grpcapi.Service, keys grpcapi.KeyAuthenticator, logger chatbot.Logger) *grpc.Server {
+	authenticator := grpcapi.MetadataAuthenticator
+	if cfg.auth == authAPIKey {
+		authenticator = grpcapi.APIKeyAuthenticator(keys)
+	}
+
+	srv := grpc.NewServer()
+	chatbotv1.RegisterChatbotServiceServer(srv, grpcapi.NewServer(svc,
+		grpcapi.WithAuthenticator(authenticator),
+		grpcapi.WithMaxUploadSize(cfg.maxUploadSize),
+		grpcapi.WithLogger(logger),
+	))
+	return srv
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/client/offline"
+	"github.com/alesr/chatbot/grpcapi"
+	"github.com/alesr/chatbot/grpcapi/chatbotv1"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestLoadConfig(t *testing.T) {
+	env := map[string]string{
+		"DATABASE_URL":            "postgres://localhost/testdb",
+		"OPENAI_API_KEY":          "test-api-key",
+		"CHATBOT_ADDR":            ":9090",
+		"CHATBOT_REQUEST_TIMEOUT": "5s",
+	}
+
+	getenv := func(key string) string { return env[key] }
+
+	t.Run("environment", func(t *testing.T) {
+		cfg, err := loadConfig(nil, getenv)
+		require.NoError(t, err)
+
+		assert.Equal(t, ":9090", cfg.addr)
+		assert.Equal(t, 5*time.Second, cfg.requestTimeout)
+		assert.Equal(t, int64(32<<20), cfg.maxUploadSize)
+		assert.Equal(t, authAPIKey, cfg.auth)
+		assert.False(t, cfg.migrate)
+	})
+
+	t.Run("flags override environment", func(t *testing.T) {
+		cfg, err := loadConfig([]string{"-addr", ":7070", "-top-k", "3", "-models", "support=coll-1, faq=coll-2"}, getenv)
+		require.NoError(t, err)
+
+		assert.Equal(t, ":7070", cfg.addr)
+		assert.Equal(t, 3, cfg.topK)
+		assert.Equal(t, map[string]string{"support": "coll-1", "faq": "coll-2"}, cfg.models)
+
+		cfg, err = loadConfig([]string{"-migrate"}, getenv)
+		require.NoError(t, err)
+		assert.True(t, cfg.migrate)
+	})
+
+	t.Run("invalid environment", func(t *testing.T) {
+		_, err := loadConfig(nil, func(key string) string {
+			if key == "CHATBOT_TOP_K" {
+				return "many"
+			}
+			return env[key]
+		})
+		assert.ErrorContains(t, err, "CHATBOT_TOP_K")
+	})
+
+	t.Run("invalid authentication", func(t *testing.T) {
+		_, err := loadConfig([]string{"-auth", "none"}, getenv)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing database URL", func(t *testing.T) {
+		_, err := loadConfig(nil, func(key string) string {
+			if key == "DATABASE_URL" {
+				return ""
+			}
+			return env[key]
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("data directory", func(t *testing.T) {
+		noDatabase := func(key string) string {
+			if key == "DATABASE_URL" {
+				return ""
+			}
+			return env[key]
+		}
+
+		cfg, err := loadConfig([]string{"-data-dir", "/var/lib/chatbot", "-auth", "header"}, noDatabase)
+		require.NoError(t, err)
+		assert.Equal(t, "/var/lib/chatbot", cfg.dataDir)
+
+		_, err = loadConfig([]string{"-data-dir", "/var/lib/chatbot"}, noDatabase)
+		assert.ErrorContains(t, err, "API key")
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		noOpenAI := func(key string) string {
+			if key == "OPENAI_API_KEY" {
+				return ""
+			}
+			return env[key]
+		}
+
+		_, err := loadConfig(nil, noOpenAI)
+		assert.Error(t, err)
+
+		cfg, err := loadConfig([]string{"-offline"}, noOpenAI)
+		require.NoError(t, err)
+		assert.True(t, cfg.offline)
+	})
+
+	t.Run("ollama", func(t *testing.T) {
+		noOpenAI := func(key string) string {
+			if key == "OPENAI_API_KEY" {
+				return ""
+			}
+			return env[key]
+		}
+
+		cfg, err := loadConfig([]string{"-ollama-url", "http://localhost:11434", "-ollama-chat-model", "mistral"}, noOpenAI)
+		require.NoError(t, err)
+		assert.Equal(t, "http://localhost:11434", cfg.ollamaURL)
+		assert.Equal(t, "nomic-embed-text", cfg.ollamaEmbedding)
+		assert.Equal(t, "mistral", cfg.ollamaChat)
+
+		_, err = loadConfig([]string{"-ollama-url", "http://localhost:11434", "-offline"}, noOpenAI)
+		assert.ErrorContains(t, err, "exclusive")
+	})
+
+	t.Run("logging", func(t *testing.T) {
+		cfg, err := loadConfig(nil, getenv)
+		require.NoError(t, err)
+		assert.Equal(t, slog.LevelInfo, cfg.logLevel)
+		assert.Equal(t, logText, cfg.logFormat)
+
+		cfg, err = loadConfig([]string{"-log-level", "warn", "-log-format", "json"}, getenv)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		logger := newLogger(cfg, &buf)
+		logger.Info("listening", "addr", ":8080")
+		logger.Warn("token quotas are not enforced without a usage ledger")
+
+		assert.NotContains(t, buf.String(), "listening")
+		assert.Contains(t, buf.String(), `"level":"WARN"`)
+
+		_, err = loadConfig([]string{"-log-level", "verbose"}, getenv)
+		assert.ErrorContains(t, err, "log level")
+
+		_, err = loadConfig([]string{"-log-format", "xml"}, getenv)
+		assert.ErrorContains(t, err, "log format")
+	})
+}
+
+type keyAuthenticatorFunc func(ctx context.Context, secret string) (*apikey.Key, error)
+
+func (f keyAuthenticatorFunc) Authenticate(ctx context.Context, secret string) (*apikey.Key, error) {
+	return f(ctx, secret)
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	client := offline.New()
+	svc := chatbot.NewService("", client, client, storage.NewMemory())
+
+	keys := keyAuthenticatorFunc(func(ctx context.Context, secret string) (*apikey.Key, error) {
+		if secret != "valid-key" {
+			return nil, apikey.ErrInvalidKey
+		}
+		return &apikey.Key{UserID: "user-1", Scopes: []apikey.Scope{apikey.ScopeRead}}, nil
+	})
+
+	dial := func(t *testing.T, cfg *config) chatbotv1.ChatbotServiceClient {
+		lis := bufconn.Listen(1 << 20)
+
+		srv := newGRPCServer(cfg, svc, keys, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		go func() { _ = srv.Serve(lis) }()
+		t.Cleanup(srv.Stop)
+
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return chatbotv1.NewChatbotServiceClient(conn)
+	}
+
+	userOnly := metadata.AppendToOutgoingContext(context.Background(), grpcapi.UserIDMetadata, "user-1")
+
+	t.Run("api key", func(t *testing.T) {
+		c := dial(t, &config{auth: authAPIKey, maxUploadSize: 1 << 20})
+
+		_, err := c.ListCollections(userOnly, &chatbotv1.ListCollectionsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), grpcapi.AuthorizationMetadata, "Bearer valid-key")
+		_, err = c.ListCollections(ctx, &chatbotv1.ListCollectionsRequest{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		c := dial(t, &config{auth: authHeader, maxUploadSize: 1 << 20})
+
+		_, err := c.ListCollections(userOnly, &chatbotv1.ListCollectionsRequest{})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,34 @@
+package chatbot
+
+import (
+	"context"
+
+	"github.com/alesr/chatbot/storage"
+)
+
+// ListCollections returns the collections of the user, oldest first.
+func (s *Service) ListCollections(ctx context.Context, userID string) ([]storage.Collection, error) {
+	return s.repo.ListCollections(ctx, storage.ListCollectionsInput{UserID: userID})
+}
+
+// Collection returns the collection of the user, or storage.ErrNotFound if it does not exist.
+func (s *Service) Collection(ctx context.Context, userID, collectionID string) (*storage.Collection, error) {
+	return s.repo.FetchCollection(ctx, storage.FetchCollectionInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+	})
+}
+
+// DeleteCollection deletes the collection of the user,
+// or returns storage.ErrNotFound if it does not exist.
+func (s *Service) DeleteCollection(ctx context.Context, userID, collectionID string) error {
+	if err := s.repo.DeleteCollection(ctx, storage.DeleteCollectionInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+	}); err != nil {
+		return err
+	}
+
+	s.logger.InfoContext(ctx, "collection deleted", "user_id", userID, "collection_id", collectionID)
+	return nil
+}
@@ -0,0 +1,129 @@
+package chatbot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+)
+
+const defaultEmbeddingCacheSize int = 10000
+
+type (
+	// EmbeddingCacheStore represents the persistent storage behind the embedding cache.
+	EmbeddingCacheStore interface {
+		FetchCachedEmbedding(ctx context.Context, in storage.FetchCachedEmbeddingInput) (*storage.CachedEmbedding, error)
+		StoreCachedEmbedding(ctx context.Context, in storage.StoreCachedEmbeddingInput) error
+	}
+
+	// EmbeddingCacheStats represents the hit and miss counters of the embedding cache.
+	EmbeddingCacheStats struct {
+		MemoryHits int64
+		StoreHits  int64
+		Misses     int64
+	}
+
+	// EmbeddingCache caches embeddings by model, dimensions and the SHA-256 of the text,
+	// so that identical text is not embedded twice. An in-memory LRU sits in front
+	// of an optional persistent store.
+	EmbeddingCache struct {
+		store  EmbeddingCacheStore
+		memory *lru[embeddingCacheKey, storage.CachedEmbedding]
+
+		memoryHits atomic.Int64
+		storeHits  atomic.Int64
+		misses     atomic.Int64
+	}
+
+	embeddingCacheKey struct {
+		model      string
+		dimensions int
+		textHash   string
+	}
+)
+
+// NewEmbeddingCache returns a new embedding cache keeping up to size
+// embeddings in memory. The store may be nil for a memory-only cache.
+// A non-positive size defaults to 10000.
+func NewEmbeddingCache(store EmbeddingCacheStore, size int) *EmbeddingCache {
+	if size <= 0 {
+		size = defaultEmbeddingCacheSize
+	}
+
+	return &EmbeddingCache{
+		store:  store,
+		memory: newLRU[embeddingCacheKey, storage.CachedEmbedding](size),
+	}
+}
+
+// Stats returns the hit and miss counters since the cache was created.
+func (c *EmbeddingCache) Stats() EmbeddingCacheStats {
+	return EmbeddingCacheStats{
+		MemoryHits: c.memoryHits.Load(),
+		StoreHits:  c.storeHits.Load(),
+		Misses:     c.misses.Load(),
+	}
+}
+
+// get returns the cached embedding for the key, promoting store hits to memory.
+func (c *EmbeddingCache) get(ctx context.Context, key embeddingCacheKey) (*storage.CachedEmbedding, bool, error) {
+	if emb, ok := c.memory.get(key); ok {
+		c.memoryHits.Add(1)
+		return &emb, true, nil
+	}
+
+	if c.store != nil {
+		emb, err := c.store.FetchCachedEmbedding(ctx, storage.FetchCachedEmbeddingInput{
+			Model:      key.model,
+			Dimensions: key.dimensions,
+			TextHash:   key.textHash,
+		})
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, false, fmt.Errorf("could not fetch cached embedding: %w", err)
+		}
+
+		if err == nil {
+			c.storeHits.Add(1)
+			c.memory.add(key, *emb)
+			return emb, true, nil
+		}
+	}
+
+	c.misses.Add(1)
+	return nil, false, nil
+}
+
+// add stores the embedding in memory and in the persistent store.
+func (c *EmbeddingCache) add(ctx context.Context, key embeddingCacheKey, emb storage.CachedEmbedding) error {
+	c.memory.add(key, emb)
+
+	if c.store == nil {
+		return nil
+	}
+
+	if err := c.store.StoreCachedEmbedding(ctx, storage.StoreCachedEmbeddingInput{
+		Model:      key.model,
+		Dimensions: key.dimensions,
+		TextHash:   key.textHash,
+		Vector:     emb.Vector,
+		Tokens:     emb.Tokens,
+		CreatedAt:  time.Now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("could not store cached embedding: %w", err)
+	}
+	return nil
+}
+
+func newEmbeddingCacheKey(model string, dimensions int, text string) embeddingCacheKey {
+	sum := sha256.Sum256([]byte(text))
+	return embeddingCacheKey{
+		model:      model,
+		dimensions: dimensions,
+		textHash:   hex.EncodeToString(sum[:]),
+	}
+}
@@ -0,0 +1,114 @@
+package chatbot
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryEmbeddingCacheStore struct {
+	mu    sync.Mutex
+	items map[string]storage.CachedEmbedding
+}
+
+func (m *memoryEmbeddingCacheStore) FetchCachedEmbedding(ctx context.Context, in storage.FetchCachedEmbeddingInput) (*storage.CachedEmbedding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	emb, ok := m.items[in.Model+in.TextHash]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &emb, nil
+}
+
+func (m *memoryEmbeddingCacheStore) StoreCachedEmbedding(ctx context.Context, in storage.StoreCachedEmbeddingInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[in.Model+in.TextHash] = storage.CachedEmbedding{Vector: in.Vector, Tokens: in.Tokens}
+	return nil
+}
+
+func TestEmbeddingCache(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryEmbeddingCacheStore{items: make(map[string]storage.CachedEmbedding)}
+
+	cache := NewEmbeddingCache(store, 1)
+
+	key := newEmbeddingCacheKey("model", 0, "text")
+
+	_, ok, err := cache.get(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, cache.add(ctx, key, storage.CachedEmbedding{Vector: []float32{1}, Tokens: 1}))
+
+	emb, ok, err := cache.get(ctx, key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []float32{1}, emb.Vector)
+
+	// Evicts the first key from memory, which is then served by the store.
+	other := newEmbeddingCacheKey("model", 0, "other text")
+	require.NoError(t, cache.add(ctx, other, storage.CachedEmbedding{Vector: []float32{2}, Tokens: 1}))
+
+	_, ok, err = cache.get(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, EmbeddingCacheStats{MemoryHits: 1, StoreHits: 1, Misses: 1}, cache.Stats())
+}
+
+func TestEmbeddingCacheKey(t *testing.T) {
+	key := newEmbeddingCacheKey("model", 0, "text")
+
+	assert.Equal(t, key, newEmbeddingCacheKey("model", 0, "text"))
+	assert.NotEqual(t, key, newEmbeddingCacheKey("other-model", 0, "text"))
+	assert.NotEqual(t, key, newEmbeddingCacheKey("model", 256, "text"))
+	assert.NotEqual(t, key, newEmbeddingCacheKey("model", 0, "other text"))
+}
+
+func TestTrainWithEmbeddingCache(t *testing.T) {
+	var calls atomic.Int64
+
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			calls.Add(1)
+			return &openaicli.EmbeddingResponse{
+				Usage: openaicli.Usage{TotalTokens: 1},
+				Data:  []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		StoreEmbeddingsFunc: func(ctx context.Context, in storage.StoreEmbeddingInput) error {
+			assert.Equal(t, int64(1), in.Tokens)
+			return nil
+		},
+	}
+
+	cache := NewEmbeddingCache(nil, 0)
+	svc := NewService("test-api-key", &client, &client, &repo, WithEmbeddingCache(cache))
+
+	for i := 0; i < 2; i++ {
+		_, err := svc.Train(context.Background(), TrainInput{
+			UserID: "test-user",
+			Model:  defaultModel,
+			Data:   []io.Reader{strings.NewReader("word1 word2 word3")},
+		})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int64(1), calls.Load())
+	assert.Equal(t, EmbeddingCacheStats{MemoryHits: 1, Misses: 1}, cache.Stats())
+}
@@ -0,0 +1,482 @@
+package chatbot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/google/uuid"
+)
+
+// ExportFormat is the encoding of an exported collection.
+type ExportFormat string
+
+const (
+	// ExportJSONL writes a JSON header line followed by one JSON line per chunk.
+	ExportJSONL ExportFormat = "jsonl"
+
+	// ExportBinary writes a magic number followed by length prefixed JSON frames,
+	// each chunk frame followed by its vector as little endian float32s.
+	// It is about a third of the size of JSONL.
+	ExportBinary ExportFormat = "binary"
+)
+
+const (
+	exportFormatName string = "chatbot-collection"
+	exportVersion    int    = 1
+
+	// exportPageSize is the number of chunks read from the repository at once.
+	exportPageSize int = 500
+
+	// maxExportFrame bounds the frames of binary exports, so that
+	// a corrupt length does not allocate an arbitrary amount of memory.
+	maxExportFrame uint32 = 16 << 20
+
+	// maxExportDimensions bounds the dimensions of imported vectors to the
+	// largest pgvector stores, so that a corrupt header does not allocate
+	// an arbitrary amount of memory for each chunk.
+	maxExportDimensions int = 16000
+)
+
+// exportMagic starts binary exports.
+var exportMagic = []byte("CHATBOT\x00")
+
+var (
+	// ErrCollectionExists is returned when importing into an existing collection.
+	ErrCollectionExists = errors.New("collection already exists")
+
+	// ErrInvalidExport is returned when importing data that is not a valid export.
+	ErrInvalidExport = errors.New("invalid export")
+)
+
+type (
+	// ExportInput represents the input for exporting a collection.
+	ExportInput struct {
+		UserID       string
+		CollectionID string
+
+		// Format defaults to ExportJSONL.
+		Format ExportFormat
+	}
+
+	// ImportInput represents the input for importing a collection.
+	ImportInput struct {
+		UserID string
+
+		// CollectionID is the ID of the imported collection.
+		// Defaults to the ID of the exported collection.
+		CollectionID string
+	}
+)
+
+// exportHeader describes the exported collection. Chunks is used to detect truncated exports.
+type exportHeader struct {
+	Format       string    `json:"format"`
+	Version      int       `json:"version"`
+	CollectionID string    `json:"collection_id"`
+	Model        string    `json:"model"`
+	Dimensions   int       `json:"dimensions"`
+	Chunks       int64     `json:"chunks"`
+	Tokens       int64     `json:"tokens"`
+	ExportedAt   time.Time `json:"exported_at"`
+
+	// ModelDimensions are the dimensions requested from the model, 0 for its default.
+	ModelDimensions int `json:"model_dimensions,omitempty"`
+}
+
+// exportChunk is an exported chunk. The vector is left out of the JSON of binary exports.
+type exportChunk struct {
+	ID        string         `json:"id"`
+	Text      string         `json:"text"`
+	Tokens    int64          `json:"tokens"`
+	Language  string         `json:"language,omitempty"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Vector    []float32      `json:"vector,omitempty"`
+}
+
+// ExportCollection writes the chunks of the collection, with their vectors,
+// metadata and model, to w in the given format. Exports are streamed, so the
+// collection is never held in memory. It returns storage.ErrNotFound if the
+// collection does not exist, and an error if it changes during the export.
+func (s *Service) ExportCollection(ctx context.Context, w io.Writer, in ExportInput) error {
+	collection, err := s.Collection(ctx, in.UserID, in.CollectionID)
+	if err != nil {
+		return err
+	}
+
+	format := in.Format
+	if format == "" {
+		format = ExportJSONL
+	}
+
+	bw := bufio.NewWriter(w)
+
+	var enc exportEncoder
+	switch format {
+	case ExportJSONL:
+		enc = &jsonlExportEncoder{enc: json.NewEncoder(bw)}
+	case ExportBinary:
+		enc = &binaryExportEncoder{w: bw}
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	fetch := func(afterID string) ([]storage.Embedding, error) {
+		page, err := s.repo.FetchEmbeddings(ctx, storage.FetchEmbeddingsInput{
+			UserID:       in.UserID,
+			CollectionID: in.CollectionID,
+			AfterID:      afterID,
+			Limit:        exportPageSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch embeddings: %w", err)
+		}
+		return page, nil
+	}
+
+	page, err := fetch("")
+	if err != nil {
+		return err
+	}
+
+	header := exportHeader{
+		Format:          exportFormatName,
+		Version:         exportVersion,
+		CollectionID:    collection.ID,
+		Model:           collection.Model,
+		ModelDimensions: collection.Dimensions,
+		Chunks:          collection.Chunks,
+		Tokens:          collection.Tokens,
+		ExportedAt:      time.Now().UTC(),
+	}
+
+	if len(page) > 0 {
+		header.Dimensions = len(page[0].Vector)
+	}
+
+	if err := enc.writeHeader(header); err != nil {
+		return fmt.Errorf("could not write export: %w", err)
+	}
+
+	var written int64
+	for len(page) > 0 {
+		for _, e := range page {
+			if len(e.Vector) != header.Dimensions {
+				return fmt.Errorf("could not export chunk %s: %d dimensions, want %d", e.ID, len(e.Vector), header.Dimensions)
+			}
+
+			if err := enc.writeChunk(exportChunk{
+				ID:        e.ID,
+				Text:      e.Text,
+				Tokens:    e.Tokens,
+				Language:  e.Language,
+				Metadata:  e.Metadata,
+				CreatedAt: e.CreatedAt,
+				Vector:    e.Vector,
+			}); err != nil {
+				return fmt.Errorf("could not write export: %w", err)
+			}
+			written++
+		}
+
+		if len(page) < exportPageSize {
+			break
+		}
+
+		if page, err = fetch(page[len(page)-1].ID); err != nil {
+			return err
+		}
+	}
+
+	if written != header.Chunks {
+		return errors.New("could not export collection: it changed during the export")
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("could not write export: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "collection exported",
+		"user_id", in.UserID,
+		"collection_id", in.CollectionID,
+		"chunks", written,
+		"format", string(format),
+	)
+	return nil
+}
+
+// ImportCollection reads an export of either format from r and stores its chunks
+// under the collection ID of the input, or the exported one, returning the ID.
+// It returns ErrCollectionExists if the user already has the collection, and
+// ErrInvalidExport if the data is not a complete export. Chunks get new IDs,
+// so a collection can be imported into the database it was exported from.
+// On failure, the chunks already imported are deleted.
+func (s *Service) ImportCollection(ctx context.Context, r io.Reader, in ImportInput) (_ string, err error) {
+	br := bufio.NewReader(r)
+
+	var dec exportDecoder
+	if magic, _ := br.Peek(len(exportMagic)); bytes.Equal(magic, exportMagic) {
+		dec = &binaryExportDecoder{r: br}
+	} else {
+		dec = &jsonlExportDecoder{dec: json.NewDecoder(br)}
+	}
+
+	header, err := dec.readHeader()
+	if err != nil {
+		return "", fmt.Errorf("%w: could not read header: %s", ErrInvalidExport, err)
+	}
+
+	if header.Format != exportFormatName {
+		return "", fmt.Errorf("%w: unknown format %q", ErrInvalidExport, header.Format)
+	}
+
+	if header.Version != exportVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrInvalidExport, header.Version)
+	}
+
+	if header.Dimensions <= 0 || header.Dimensions > maxExportDimensions {
+		return "", fmt.Errorf("%w: %d dimensions, want 1 to %d", ErrInvalidExport, header.Dimensions, maxExportDimensions)
+	}
+
+	collectionID := in.CollectionID
+	if collectionID == "" {
+		collectionID = header.CollectionID
+	}
+
+	if collectionID == "" {
+		return "", fmt.Errorf("%w: missing collection ID", ErrInvalidExport)
+	}
+
+	existing, err := s.Collection(ctx, in.UserID, collectionID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return "", err
+	}
+
+	if existing != nil {
+		return "", ErrCollectionExists
+	}
+
+	if err := s.checkCollectionQuotas(ctx, in.UserID, int(header.Chunks), nil); err != nil {
+		return "", err
+	}
+
+	var imported int64
+
+	defer func() {
+		if err == nil || imported == 0 {
+			return
+		}
+
+		// The collection did not exist, so everything in it was imported here.
+		if delErr := s.repo.DeleteCollection(context.Background(), storage.DeleteCollectionInput{
+			UserID:       in.UserID,
+			CollectionID: collectionID,
+		}); delErr != nil {
+			s.logger.ErrorContext(ctx, "could not delete partially imported collection",
+				"user_id", in.UserID,
+				"collection_id", collectionID,
+				"error", delErr,
+			)
+		}
+	}()
+
+	for {
+		chunk, err := dec.readChunk(header.Dimensions)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: could not read chunk: %s", ErrInvalidExport, err)
+		}
+
+		if len(chunk.Vector) != header.Dimensions {
+			return "", fmt.Errorf("%w: chunk %s has %d dimensions, want %d", ErrInvalidExport, chunk.ID, len(chunk.Vector), header.Dimensions)
+		}
+
+		language := chunk.Language
+		if language == "" {
+			language = s.textLanguage
+		}
+
+		if err := s.repo.StoreEmbeddings(ctx, storage.StoreEmbeddingInput{
+			ID:           "emb-" + uuid.NewString(),
+			UserID:       in.UserID,
+			CollectionID: collectionID,
+			Model:        header.Model,
+			Dimensions:   header.ModelDimensions,
+			Text:         chunk.Text,
+			Tokens:       chunk.Tokens,
+			Vector:       chunk.Vector,
+			Language:     language,
+			Metadata:     chunk.Metadata,
+			CreatedAt:    chunk.CreatedAt,
+		}); err != nil {
+			return "", fmt.Errorf("could not store vector: %w", err)
+		}
+		imported++
+	}
+
+	if imported != header.Chunks {
+		return "", fmt.Errorf("%w: %d chunks, want %d", ErrInvalidExport, imported, header.Chunks)
+	}
+
+	s.logger.InfoContext(ctx, "collection imported",
+		"user_id", in.UserID,
+		"collection_id", collectionID,
+		"chunks", imported,
+	)
+	return collectionID, nil
+}
+
+type exportEncoder interface {
+	writeHeader(h exportHeader) error
+	writeChunk(c exportChunk) error
+}
+
+type exportDecoder interface {
+	readHeader() (exportHeader, error)
+
+	// readChunk returns io.EOF after the last chunk.
+	readChunk(dimensions int) (exportChunk, error)
+}
+
+type jsonlExportEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlExportEncoder) writeHeader(h exportHeader) error {
+	return e.enc.Encode(h)
+}
+
+func (e *jsonlExportEncoder) writeChunk(c exportChunk) error {
+	return e.enc.Encode(c)
+}
+
+type jsonlExportDecoder struct {
+	dec *json.Decoder
+}
+
+func (d *jsonlExportDecoder) readHeader() (exportHeader, error) {
+	var h exportHeader
+	err := d.dec.Decode(&h)
+	return h, err
+}
+
+func (d *jsonlExportDecoder) readChunk(int) (exportChunk, error) {
+	var c exportChunk
+	err := d.dec.Decode(&c)
+	return c, err
+}
+
+type binaryExportEncoder struct {
+	w io.Writer
+}
+
+func (e *binaryExportEncoder) writeHeader(h exportHeader) error {
+	if _, err := e.w.Write(exportMagic); err != nil {
+		return err
+	}
+	return e.writeFrame(h)
+}
+
+func (e *binaryExportEncoder) writeChunk(c exportChunk) error {
+	vector := c.Vector
+	c.Vector = nil
+
+	if err := e.writeFrame(c); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+
+	_, err := e.w.Write(buf)
+	return err
+}
+
+func (e *binaryExportEncoder) writeFrame(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := binary.Write(e.w, binary.LittleEndian, uint32(len(data))); err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	return err
+}
+
+type binaryExportDecoder struct {
+	r io.Reader
+}
+
+func (d *binaryExportDecoder) readHeader() (exportHeader, error) {
+	var h exportHeader
+
+	if _, err := io.ReadFull(d.r, make([]byte, len(exportMagic))); err != nil {
+		return h, err
+	}
+
+	err := d.readFrame(&h)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return h, err
+}
+
+func (d *binaryExportDecoder) readChunk(dimensions int) (exportChunk, error) {
+	var c exportChunk
+	if err := d.readFrame(&c); err != nil {
+		return c, err
+	}
+
+	buf := make([]byte, 4*dimensions)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return c, fmt.Errorf("could not read vector: %w", noEOF(err))
+	}
+
+	c.Vector = make([]float32, dimensions)
+	for i := range c.Vector {
+		c.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return c, nil
+}
+
+// readFrame decodes the next frame into v. It returns io.EOF only at a frame boundary.
+func (d *binaryExportDecoder) readFrame(v any) error {
+	var size uint32
+	if err := binary.Read(d.r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+
+	if size > maxExportFrame {
+		return fmt.Errorf("frame of %d bytes exceeds %d", size, maxExportFrame)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return noEOF(err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads in the middle of a record.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package chatbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alesr/chatbot/client/offline"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fetchAllEmbeddings(t *testing.T, repo Repository, userID, collectionID string) []storage.Embedding {
+	t.Helper()
+
+	embeddings, err := repo.FetchEmbeddings(context.Background(), storage.FetchEmbeddingsInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+		Limit:        1000,
+	})
+	require.NoError(t, err)
+	return embeddings
+}
+
+func TestExportImportCollection(t *testing.T) {
+	client := offline.New(offline.WithDimensions(8))
+
+	src := storage.NewMemory()
+	srcSvc := NewService("", client, client, src, WithTextSearchLanguage("portuguese"))
+
+	var doc strings.Builder
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&doc, "Rule %d: employees get %d vacation days per year. ", i, i+20)
+	}
+
+	collectionID, err := srcSvc.Train(context.Background(), TrainInput{
+		UserID:   "user-1",
+		Data:     []io.Reader{strings.NewReader(doc.String())},
+		Metadata: map[string]any{"source": "handbook", "version": 2},
+	})
+	require.NoError(t, err)
+
+	exported := fetchAllEmbeddings(t, src, "user-1", collectionID)
+	require.Greater(t, len(exported), 1)
+
+	for _, format := range []ExportFormat{ExportJSONL, ExportBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, srcSvc.ExportCollection(context.Background(), &buf, ExportInput{
+				UserID:       "user-1",
+				CollectionID: collectionID,
+				Format:       format,
+			}))
+
+			dst := storage.NewMemory()
+			dstSvc := NewService("", client, client, dst)
+
+			data := buf.Bytes()
+
+			importedID, err := dstSvc.ImportCollection(context.Background(), bytes.NewReader(data), ImportInput{UserID: "user-2"})
+			require.NoError(t, err)
+			assert.Equal(t, collectionID, importedID)
+
+			imported := fetchAllEmbeddings(t, dst, "user-2", importedID)
+			require.Len(t, imported, len(exported))
+
+			byText := make(map[string]storage.Embedding)
+			for _, e := range imported {
+				byText[e.Text] = e
+			}
+
+			for _, e := range exported {
+				got, ok := byText[e.Text]
+				require.True(t, ok, "missing chunk %q", e.Text)
+
+				assert.NotEqual(t, e.ID, got.ID)
+				assert.Equal(t, e.Vector, got.Vector)
+				assert.Equal(t, e.Model, got.Model)
+				assert.Equal(t, e.Tokens, got.Tokens)
+				assert.Equal(t, "portuguese", got.Language)
+				assert.Equal(t, e.Metadata, got.Metadata)
+				assert.True(t, e.CreatedAt.Equal(got.CreatedAt))
+			}
+
+			_, err = dstSvc.ImportCollection(context.Background(), bytes.NewReader(data), ImportInput{UserID: "user-2"})
+			assert.ErrorIs(t, err, ErrCollectionExists)
+
+			// Importing under a new ID into the source works, since chunks get new IDs.
+			copyID, err := srcSvc.ImportCollection(context.Background(), bytes.NewReader(data), ImportInput{
+				UserID:       "user-1",
+				CollectionID: "coll-copy-" + string(format),
+			})
+			require.NoError(t, err)
+			assert.Len(t, fetchAllEmbeddings(t, src, "user-1", copyID), len(exported))
+
+			// A truncated export is rejected, leaving nothing behind.
+			_, err = dstSvc.ImportCollection(context.Background(), bytes.NewReader(data[:len(data)-10]), ImportInput{
+				UserID:       "user-2",
+				CollectionID: "coll-truncated",
+			})
+			assert.ErrorIs(t, err, ErrInvalidExport)
+
+			_, err = dst.FetchCollection(context.Background(), storage.FetchCollectionInput{UserID: "user-2", CollectionID: "coll-truncated"})
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+		})
+	}
+
+	err = srcSvc.ExportCollection(context.Background(), io.Discard, ExportInput{UserID: "user-2", CollectionID: collectionID})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestImportCollectionInvalid(t *testing.T) {
+	svc := NewService("", offline.New(), offline.New(), storage.NewMemory())
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "not json", data: "hello"},
+		{name: "unknown format", data: `{"format":"other","version":1,"collection_id":"coll-1"}`},
+		{name: "unsupported version", data: `{"format":"chatbot-collection","version":2,"collection_id":"coll-1"}`},
+		{name: "wrong dimensions", data: `{"format":"chatbot-collection","version":1,"collection_id":"coll-1","dimensions":2,"chunks":1}
+{"id":"emb-1","text":"text","vector":[1,2,3]}`},
+		{name: "binary truncated header", data: "CHATBOT\x00\x10"},
+		{name: "missing dimensions", data: `{"format":"chatbot-collection","version":1,"collection_id":"coll-1","chunks":1}`},
+		{name: "negative dimensions", data: `{"format":"chatbot-collection","version":1,"collection_id":"coll-1","dimensions":-1,"chunks":1}`},
+		{name: "binary too many dimensions", data: binaryExportHeader(`{"format":"chatbot-collection","version":1,"collection_id":"coll-1","dimensions":2000000000,"chunks":1}`)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.ImportCollection(context.Background(), strings.NewReader(tc.data), ImportInput{UserID: "user-1"})
+			assert.ErrorIs(t, err, ErrInvalidExport)
+		})
+	}
+}
+
+// binaryExportHeader frames the JSON header as the start of a binary export.
+func binaryExportHeader(header string) string {
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(header)))
+	return string(exportMagic) + string(size) + header
+}
@@ -0,0 +1,333 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: chatbot/v1/chatbot.proto
+
+package chatbotv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ChatbotService_Train_FullMethodName            = "/chatbot.v1.ChatbotService/Train"
+	ChatbotService_Ask_FullMethodName              = "/chatbot.v1.ChatbotService/Ask"
+	ChatbotService_ListCollections_FullMethodName  = "/chatbot.v1.ChatbotService/ListCollections"
+	ChatbotService_GetCollection_FullMethodName    = "/chatbot.v1.ChatbotService/GetCollection"
+	ChatbotService_DeleteCollection_FullMethodName = "/chatbot.v1.ChatbotService/DeleteCollection"
+)
+
+// ChatbotServiceClient is the client API for ChatbotService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ChatbotServiceClient interface {
+	// Train uploads documents and trains them into a collection.
+	// The first message must carry the options, followed by the document chunks.
+	Train(ctx context.Context, opts ...grpc.CallOption) (ChatbotService_TrainClient, error)
+	// Ask streams the answer to a question as it is generated,
+	// ending with the whole result.
+	Ask(ctx context.Context, in *AskRequest, opts ...grpc.CallOption) (ChatbotService_AskClient, error)
+	// ListCollections returns the collections of the user, oldest first.
+	ListCollections(ctx context.Context, in *ListCollectionsRequest, opts ...grpc.CallOption) (*ListCollectionsResponse, error)
+	// GetCollection returns a collection of the user.
+	GetCollection(ctx context.Context, in *GetCollectionRequest, opts ...grpc.CallOption) (*GetCollectionResponse, error)
+	// DeleteCollection deletes a collection of the user.
+	DeleteCollection(ctx context.Context, in *DeleteCollectionRequest, opts ...grpc.CallOption) (*DeleteCollectionResponse, error)
+}
+
+type chatbotServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChatbotServiceClient(cc grpc.ClientConnInterface) ChatbotServiceClient {
+	return &chatbotServiceClient{cc}
+}
+
+func (c *chatbotServiceClient) Train(ctx context.Context, opts ...grpc.CallOption) (ChatbotService_TrainClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ChatbotService_ServiceDesc.Streams[0], ChatbotService_Train_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatbotServiceTrainClient{stream}
+	return x, nil
+}
+
+type ChatbotService_TrainClient interface {
+	Send(*TrainRequest) error
+	CloseAndRecv() (*TrainResponse, error)
+	grpc.ClientStream
+}
+
+type chatbotServiceTrainClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatbotServiceTrainClient) Send(m *TrainRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *chatbotServiceTrainClient) CloseAndRecv() (*TrainResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(TrainResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatbotServiceClient) Ask(ctx context.Context, in *AskRequest, opts ...grpc.CallOption) (ChatbotService_AskClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ChatbotService_ServiceDesc.Streams[1], ChatbotService_Ask_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &chatbotServiceAskClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ChatbotService_AskClient interface {
+	Recv() (*AskResponse, error)
+	grpc.ClientStream
+}
+
+type chatbotServiceAskClient struct {
+	grpc.ClientStream
+}
+
+func (x *chatbotServiceAskClient) Recv() (*AskResponse, error) {
+	m := new(AskResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatbotServiceClient) ListCollections(ctx context.Context, in *ListCollectionsRequest, opts ...grpc.CallOption) (*ListCollectionsResponse, error) {
+	out := new(ListCollectionsResponse)
+	err := c.cc.Invoke(ctx, ChatbotService_ListCollections_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatbotServiceClient) GetCollection(ctx context.Context, in *GetCollectionRequest, opts ...grpc.CallOption) (*GetCollectionResponse, error) {
+	out := new(GetCollectionResponse)
+	err := c.cc.Invoke(ctx, ChatbotService_GetCollection_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatbotServiceClient) DeleteCollection(ctx context.Context, in *DeleteCollectionRequest, opts ...grpc.CallOption) (*DeleteCollectionResponse, error) {
+	out := new(DeleteCollectionResponse)
+	err := c.cc.Invoke(ctx, ChatbotService_DeleteCollection_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChatbotServiceServer is the server API for ChatbotService service.
+// All implementations must embed UnimplementedChatbotServiceServer
+// for forward compatibility
+type ChatbotServiceServer interface {
+	// Train uploads documents and trains them into a collection.
+	// The first message must carry the options, followed by the document chunks.
+	Train(ChatbotService_TrainServer) error
+	// Ask streams the answer to a question as it is generated,
+	// ending with the whole result.
+	Ask(*AskRequest, ChatbotService_AskServer) error
+	// ListCollections returns the collections of the user, oldest first.
+	ListCollections(context.Context, *ListCollectionsRequest) (*ListCollectionsResponse, error)
+	// GetCollection returns a collection of the user.
+	GetCollection(context.Context, *GetCollectionRequest) (*GetCollectionResponse, error)
+	// DeleteCollection deletes a collection of the user.
+	DeleteCollection(context.Context, *DeleteCollectionRequest) (*DeleteCollectionResponse, error)
+	mustEmbedUnimplementedChatbotServiceServer()
+}
+
+// UnimplementedChatbotServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedChatbotServiceServer struct {
+}
+
+func (UnimplementedChatbotServiceServer) Train(ChatbotService_TrainServer) error {
+	return status.Errorf(codes.Unimplemented, "method Train not implemented")
+}
+func (UnimplementedChatbotServiceServer) Ask(*AskRequest, ChatbotService_AskServer) error {
+	return status.Errorf(codes.Unimplemented, "method Ask not implemented")
+}
+func (UnimplementedChatbotServiceServer) ListCollections(context.Context, *ListCollectionsRequest) (*ListCollectionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCollections not implemented")
+}
+func (UnimplementedChatbotServiceServer) GetCollection(context.Context, *GetCollectionRequest) (*GetCollectionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCollection not implemented")
+}
+func (UnimplementedChatbotServiceServer) DeleteCollection(context.Context, *DeleteCollectionRequest) (*DeleteCollectionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteCollection not implemented")
+}
+func (UnimplementedChatbotServiceServer) mustEmbedUnimplementedChatbotServiceServer() {}
+
+// UnsafeChatbotServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChatbotServiceServer will
+// result in compilation errors.
+type UnsafeChatbotServiceServer interface {
+	mustEmbedUnimplementedChatbotServiceServer()
+}
+
+func RegisterChatbotServiceServer(s grpc.ServiceRegistrar, srv ChatbotServiceServer) {
+	s.RegisterService(&ChatbotService_ServiceDesc, srv)
+}
+
+func _ChatbotService_Train_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ChatbotServiceServer).Train(&chatbotServiceTrainServer{stream})
+}
+
+type ChatbotService_TrainServer interface {
+	SendAndClose(*TrainResponse) error
+	Recv() (*TrainRequest, error)
+	grpc.ServerStream
+}
+
+type chatbotServiceTrainServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatbotServiceTrainServer) SendAndClose(m *TrainResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *chatbotServiceTrainServer) Recv() (*TrainRequest, error) {
+	m := new(TrainRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ChatbotService_Ask_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AskRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ChatbotServiceServer).Ask(m, &chatbotServiceAskServer{stream})
+}
+
+type ChatbotService_AskServer interface {
+	Send(*AskResponse) error
+	grpc.ServerStream
+}
+
+type chatbotServiceAskServer struct {
+	grpc.ServerStream
+}
+
+func (x *chatbotServiceAskServer) Send(m *AskResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ChatbotService_ListCollections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCollectionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatbotServiceServer).ListCollections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChatbotService_ListCollections_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatbotServiceServer).ListCollections(ctx, req.(*ListCollectionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatbotService_GetCollection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCollectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatbotServiceServer).GetCollection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChatbotService_GetCollection_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatbotServiceServer).GetCollection(ctx, req.(*GetCollectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChatbotService_DeleteCollection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteCollectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatbotServiceServer).DeleteCollection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChatbotService_DeleteCollection_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatbotServiceServer).DeleteCollection(ctx, req.(*DeleteCollectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChatbotService_ServiceDesc is the grpc.ServiceDesc for ChatbotService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChatbotService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chatbot.v1.ChatbotService",
+	HandlerType: (*ChatbotServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListCollections",
+			Handler:    _ChatbotService_ListCollections_Handler,
+		},
+		{
+			MethodName: "GetCollection",
+			Handler:    _ChatbotService_GetCollection_Handler,
+		},
+		{
+			MethodName: "DeleteCollection",
+			Handler:    _ChatbotService_DeleteCollection_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Train",
+			Handler:       _ChatbotService_Train_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Ask",
+			Handler:       _ChatbotService_Ask_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "chatbot/v1/chatbot.proto",
+}
@@ -0,0 +1,434 @@
+// Package grpcapi exposes the chatbot service as a gRPC API.
+//
+// The protobuf definition is in proto/chatbot/v1 and the generated
+// stubs in the chatbotv1 package are regenerated with `task proto`.
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/grpcapi/chatbotv1"
+	"github.com/alesr/chatbot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultMaxUploadSize int64 = 32 << 20
+
+	// UserIDMetadata is the metadata key read by MetadataAuthenticator.
+	UserIDMetadata string = "x-user-id"
+
+	// AuthorizationMetadata is the metadata key read by APIKeyAuthenticator.
+	AuthorizationMetadata string = "authorization"
+)
+
+var errUnauthenticated = errors.New("missing credentials")
+
+// Service is the chatbot service exposed by the Server.
+// It is implemented by *chatbot.Service.
+type Service interface {
+	Train(ctx context.Context, in chatbot.TrainInput) (string, error)
+	AskStream(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error)
+	ListCollections(ctx context.Context, userID string) ([]storage.Collection, error)
+	Collection(ctx context.Context, userID, collectionID string) (*storage.Collection, error)
+	DeleteCollection(ctx context.Context, userID, collectionID string) error
+}
+
+// Principal is who a call is made by.
+type Principal struct {
+	UserID string
+
+	// Key is the API key the call is made with, restricting what it may do.
+	// Nil means the call may do anything on behalf of the user.
+	Key *apikey.Key
+}
+
+func (p Principal) allows(scope apikey.Scope, collectionID string) bool {
+	return p.Key == nil || p.Key.Allows(scope, collectionID)
+}
+
+func (p Principal) hasScope(scope apikey.Scope) bool {
+	return p.Key == nil || p.Key.HasScope(scope)
+}
+
+func (p Principal) allowsCollection(collectionID string) bool {
+	return p.Key == nil || p.Key.AllowsCollection(collectionID)
+}
+
+// Authenticator returns who is making the call.
+type Authenticator func(ctx context.Context) (Principal, error)
+
+// MetadataAuthenticator trusts the user ID sent in the x-user-id metadata.
+// It is meant for deployments behind a gateway that authenticates users.
+func MetadataAuthenticator(ctx context.Context) (Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get(UserIDMetadata); len(values) > 0 && values[0] != "" {
+		return Principal{UserID: values[0]}, nil
+	}
+	return Principal{}, errUnauthenticated
+}
+
+// KeyAuthenticator authenticates API keys. It is implemented by *apikey.Manager.
+type KeyAuthenticator interface {
+	Authenticate(ctx context.Context, secret string) (*apikey.Key, error)
+}
+
+// APIKeyAuthenticator authenticates the API key sent as a bearer token
+// in the authorization metadata. Calls are then limited to the scopes
+// and collections of the key, as in the HTTP API.
+func APIKeyAuthenticator(keys KeyAuthenticator) Authenticator {
+	return func(ctx context.Context) (Principal, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		values := md.Get(AuthorizationMetadata)
+		if len(values) == 0 {
+			return Principal{}, errUnauthenticated
+		}
+
+		secret, ok := strings.CutPrefix(values[0], "Bearer ")
+		if !ok || secret == "" {
+			return Principal{}, errUnauthenticated
+		}
+
+		key, err := keys.Authenticate(ctx, secret)
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{UserID: key.UserID, Key: key}, nil
+	}
+}
+
+// Server implements chatbotv1.ChatbotServiceServer over a Service.
+type Server struct {
+	chatbotv1.UnimplementedChatbotServiceServer
+
+	svc           Service
+	authenticate  Authenticator
+	maxUploadSize int64
+	logger        chatbot.Logger
+}
+
+// Option configures optional behaviour of the Server.
+type Option func(*Server)
+
+// WithAuthenticator sets how the user of each call is identified.
+// Defaults to MetadataAuthenticator.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.authenticate = a
+	}
+}
+
+// WithMaxUploadSize limits the size of the documents of a Train call. Defaults to 32MB.
+func WithMaxUploadSize(n int64) Option {
+	return func(s *Server) {
+		s.maxUploadSize = n
+	}
+}
+
+// WithLogger logs calls that fail with an internal error.
+func WithLogger(l chatbot.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// NewServer returns a new Server. Register it with chatbotv1.RegisterChatbotServiceServer.
+func NewServer(svc Service, opts ...Option) *Server {
+	s := &Server{
+		svc:           svc,
+		authenticate:  MetadataAuthenticator,
+		maxUploadSize: defaultMaxUploadSize,
+		logger:        nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Server) Train(stream chatbotv1.ChatbotService_TrainServer) error {
+	ctx := stream.Context()
+
+	p, err := s.principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	opts := first.GetOptions()
+	if opts == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry the options")
+	}
+
+	// Keys restricted to collections cannot train new ones.
+	if err := authorize(p, apikey.ScopeTrain, opts.CollectionId); err != nil {
+		return err
+	}
+
+	var (
+		docs []*bytes.Buffer
+		last string
+		size int64
+	)
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk := req.GetChunk()
+		if chunk == nil {
+			return status.Error(codes.InvalidArgument, "options must be sent only once")
+		}
+
+		if size += int64(len(chunk.Data)); size > s.maxUploadSize {
+			return status.Error(codes.ResourceExhausted, "upload too large")
+		}
+
+		if len(docs) == 0 || chunk.Document != last {
+			docs = append(docs, &bytes.Buffer{})
+			last = chunk.Document
+		}
+		docs[len(docs)-1].Write(chunk.Data)
+	}
+
+	if len(docs) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one document is required")
+	}
+
+	data := make([]io.Reader, 0, len(docs))
+	for _, d := range docs {
+		data = append(data, d)
+	}
+
+	collectionID, err := s.svc.Train(ctx, chatbot.TrainInput{
+		UserID:       p.UserID,
+		CollectionID: opts.CollectionId,
+		Model:        chatbot.OpenAIModel(opts.Model),
+		Data:         data,
+		Metadata:     opts.Metadata.AsMap(),
+	})
+	if err != nil {
+		return s.toStatus(ctx, "Train", err)
+	}
+
+	return stream.SendAndClose(&chatbotv1.TrainResponse{CollectionId: collectionID})
+}
+
+func (s *Server) Ask(req *chatbotv1.AskRequest, stream chatbotv1.ChatbotService_AskServer) error {
+	ctx := stream.Context()
+
+	p, err := s.principal(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := authorize(p, apikey.ScopeRead, req.CollectionId); err != nil {
+		return err
+	}
+
+	if req.Question == "" {
+		return status.Error(codes.InvalidArgument, "question is required")
+	}
+
+	history := make([]openaicli.Message, 0, len(req.History))
+	for _, m := range req.History {
+		history = append(history, openaicli.Message{Role: m.Role, Content: m.Content})
+	}
+
+	result, err := s.svc.AskStream(ctx, chatbot.AskInput{
+		UserID:       p.UserID,
+		CollectionID: req.CollectionId,
+		Question:     req.Question,
+		Filters:      toFilters(req.Filters),
+		MaxDistance:  req.MaxDistance,
+		History:      history,
+	}, func(delta string) error {
+		return stream.Send(&chatbotv1.AskResponse{
+			Event: &chatbotv1.AskResponse_Delta{Delta: delta},
+		})
+	})
+	if err != nil {
+		return s.toStatus(ctx, "Ask", err)
+	}
+
+	chunks := make([]*chatbotv1.Chunk, 0, len(result.Chunks))
+	for _, c := range result.Chunks {
+		md, err := structpb.NewStruct(c.Metadata)
+		if err != nil {
+			return s.toStatus(ctx, "Ask", err)
+		}
+
+		chunks = append(chunks, &chatbotv1.Chunk{
+			Id:       c.ID,
+			Text:     c.Text,
+			Metadata: md,
+			Distance: c.Distance,
+			Rank:     c.Rank,
+		})
+	}
+
+	return stream.Send(&chatbotv1.AskResponse{
+		Event: &chatbotv1.AskResponse_Result{Result: &chatbotv1.AskResult{
+			Answer: result.Answer,
+			Cached: result.Cached,
+			Chunks: chunks,
+		}},
+	})
+}
+
+func (s *Server) ListCollections(ctx context.Context, _ *chatbotv1.ListCollectionsRequest) (*chatbotv1.ListCollectionsResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.hasScope(apikey.ScopeRead) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	collections, err := s.svc.ListCollections(ctx, p.UserID)
+	if err != nil {
+		return nil, s.toStatus(ctx, "ListCollections", err)
+	}
+
+	resp := &chatbotv1.ListCollectionsResponse{
+		Collections: make([]*chatbotv1.Collection, 0, len(collections)),
+	}
+	for _, c := range collections {
+		// Listed collections are filtered down to the ones the principal may access.
+		if !p.allowsCollection(c.ID) {
+			continue
+		}
+		resp.Collections = append(resp.Collections, toCollection(c))
+	}
+	return resp, nil
+}
+
+func (s *Server) GetCollection(ctx context.Context, req *chatbotv1.GetCollectionRequest) (*chatbotv1.GetCollectionResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorize(p, apikey.ScopeRead, req.CollectionId); err != nil {
+		return nil, err
+	}
+
+	collection, err := s.svc.Collection(ctx, p.UserID, req.CollectionId)
+	if err != nil {
+		return nil, s.toStatus(ctx, "GetCollection", err)
+	}
+	return &chatbotv1.GetCollectionResponse{Collection: toCollection(*collection)}, nil
+}
+
+func (s *Server) DeleteCollection(ctx context.Context, req *chatbotv1.DeleteCollectionRequest) (*chatbotv1.DeleteCollectionResponse, error) {
+	p, err := s.principal(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorize(p, apikey.ScopeAdmin, req.CollectionId); err != nil {
+		return nil, err
+	}
+
+	if err := s.svc.DeleteCollection(ctx, p.UserID, req.CollectionId); err != nil {
+		return nil, s.toStatus(ctx, "DeleteCollection", err)
+	}
+	return &chatbotv1.DeleteCollectionResponse{}, nil
+}
+
+func (s *Server) principal(ctx context.Context) (Principal, error) {
+	p, err := s.authenticate(ctx)
+	if err != nil {
+		return Principal{}, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	return p, nil
+}
+
+// authorize returns a permission denied status unless
+// the principal has the scope on the collection.
+func authorize(p Principal, scope apikey.Scope, collectionID string) error {
+	if !p.allows(scope, collectionID) {
+		return status.Error(codes.PermissionDenied, "permission denied")
+	}
+	return nil
+}
+
+// toStatus converts err into a gRPC status error, logging unexpected errors.
+func (s *Server) toStatus(ctx context.Context, method string, err error) error {
+	var quotaErr *chatbot.ErrQuotaExceeded
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, "collection not found")
+	case errors.Is(err, storage.ErrInvalidFilter), errors.Is(err, chatbot.ErrModelMismatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &quotaErr):
+		return status.Error(codes.ResourceExhausted, quotaErr.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	default:
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+
+		s.logger.ErrorContext(ctx, "call failed", "method", method, "error", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func toCollection(c storage.Collection) *chatbotv1.Collection {
+	return &chatbotv1.Collection{
+		Id:        c.ID,
+		Model:     c.Model,
+		Chunks:    c.Chunks,
+		Tokens:    c.Tokens,
+		CreatedAt: timestamppb.New(c.CreatedAt),
+		UpdatedAt: timestamppb.New(c.UpdatedAt),
+	}
+}
+
+func toFilters(in []*chatbotv1.Filter) []storage.Filter {
+	filters := make([]storage.Filter, 0, len(in))
+	for _, f := range in {
+		var values []any
+		for _, v := range f.Values {
+			values = append(values, v.AsInterface())
+		}
+
+		filters = append(filters, storage.NewFilter(f.Field, f.Op, f.Value.AsInterface(), values))
+	}
+	return filters
+}
+
+type nopLogger struct{}
+
+func (nopLogger) DebugContext(context.Context, string, ...any) {}
+func (nopLogger) InfoContext(context.Context, string, ...any)  {}
+func (nopLogger) WarnContext(context.Context, string, ...any)  {}
+func (nopLogger) ErrorContext(context.Context, string, ...any) {}
@@ -0,0 +1,254 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/grpcapi/chatbotv1"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newTestClient(t *testing.T, svc Service, opts ...Option) chatbotv1.ChatbotServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+
+	srv := grpc.NewServer()
+	chatbotv1.RegisterChatbotServiceServer(srv, NewServer(svc, opts...))
+
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return chatbotv1.NewChatbotServiceClient(conn)
+}
+
+func userContext(userID string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), UserIDMetadata, userID)
+}
+
+func TestTrain(t *testing.T) {
+	svc := mockService{
+		TrainFunc: func(ctx context.Context, in chatbot.TrainInput) (string, error) {
+			assert.Equal(t, "user-1", in.UserID)
+			assert.Equal(t, chatbot.OpenAIModel("text-embedding-3-small"), in.Model)
+			assert.Equal(t, map[string]any{"year": float64(2024)}, in.Metadata)
+
+			var docs []string
+			for _, d := range in.Data {
+				b, err := io.ReadAll(d)
+				require.NoError(t, err)
+				docs = append(docs, string(b))
+			}
+			assert.Equal(t, []string{"first document", "second"}, docs)
+
+			return "coll-1", nil
+		},
+	}
+
+	client := newTestClient(t, &svc)
+
+	stream, err := client.Train(userContext("user-1"))
+	require.NoError(t, err)
+
+	md, err := structpb.NewStruct(map[string]any{"year": 2024})
+	require.NoError(t, err)
+
+	reqs := []*chatbotv1.TrainRequest{
+		{Payload: &chatbotv1.TrainRequest_Options{Options: &chatbotv1.TrainOptions{
+			Model:    "text-embedding-3-small",
+			Metadata: md,
+		}}},
+		{Payload: &chatbotv1.TrainRequest_Chunk{Chunk: &chatbotv1.DocumentChunk{Document: "a.txt", Data: []byte("first ")}}},
+		{Payload: &chatbotv1.TrainRequest_Chunk{Chunk: &chatbotv1.DocumentChunk{Document: "a.txt", Data: []byte("document")}}},
+		{Payload: &chatbotv1.TrainRequest_Chunk{Chunk: &chatbotv1.DocumentChunk{Document: "b.txt", Data: []byte("second")}}},
+	}
+
+	for _, req := range reqs {
+		require.NoError(t, stream.Send(req))
+	}
+
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	assert.Equal(t, "coll-1", resp.CollectionId)
+}
+
+func TestAsk(t *testing.T) {
+	svc := mockService{
+		AskStreamFunc: func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+			assert.Equal(t, "user-1", in.UserID)
+			assert.Equal(t, "coll-1", in.CollectionID)
+			assert.Equal(t, []storage.Filter{storage.Eq("year", float64(2024))}, in.Filters)
+
+			require.NoError(t, onDelta("Hello"))
+			require.NoError(t, onDelta(" world"))
+
+			return &chatbot.AskResult{
+				Answer: "Hello world",
+				Chunks: []storage.Chunk{{ID: "emb-1", Text: "context", Metadata: map[string]any{"year": float64(2024)}}},
+			}, nil
+		},
+	}
+
+	client := newTestClient(t, &svc)
+
+	stream, err := client.Ask(userContext("user-1"), &chatbotv1.AskRequest{
+		CollectionId: "coll-1",
+		Question:     "hi?",
+		Filters:      []*chatbotv1.Filter{{Field: "year", Op: "eq", Value: structpb.NewNumberValue(2024)}},
+	})
+	require.NoError(t, err)
+
+	var (
+		deltas []string
+		result *chatbotv1.AskResult
+	)
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if d, ok := resp.Event.(*chatbotv1.AskResponse_Delta); ok {
+			deltas = append(deltas, d.Delta)
+		} else {
+			result = resp.GetResult()
+		}
+	}
+
+	assert.Equal(t, []string{"Hello", " world"}, deltas)
+
+	require.NotNil(t, result)
+	assert.Equal(t, "Hello world", result.Answer)
+	require.Len(t, result.Chunks, 1)
+	assert.Equal(t, "emb-1", result.Chunks[0].Id)
+}
+
+func TestErrors(t *testing.T) {
+	svc := mockService{
+		CollectionFunc: func(ctx context.Context, userID, collectionID string) (*storage.Collection, error) {
+			return nil, storage.ErrNotFound
+		},
+		DeleteCollectionFunc: func(ctx context.Context, userID, collectionID string) error {
+			return &chatbot.ErrQuotaExceeded{Quota: chatbot.QuotaMaxCollections, Limit: 1, Used: 1}
+		},
+		AskStreamFunc: func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+			if in.CollectionID == "coll-2" {
+				return nil, chatbot.ErrModelMismatch
+			}
+			return nil, fmt.Errorf("could not fetch nearest neighbors: %w", storage.ErrInvalidFilter)
+		},
+	}
+
+	client := newTestClient(t, &svc)
+
+	_, err := client.ListCollections(context.Background(), &chatbotv1.ListCollectionsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetCollection(userContext("user-1"), &chatbotv1.GetCollectionRequest{CollectionId: "coll-1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.DeleteCollection(userContext("user-1"), &chatbotv1.DeleteCollectionRequest{CollectionId: "coll-1"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	for _, collectionID := range []string{"coll-1", "coll-2"} {
+		ask, err := client.Ask(userContext("user-1"), &chatbotv1.AskRequest{CollectionId: collectionID, Question: "hi?"})
+		require.NoError(t, err)
+
+		_, err = ask.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	stream, err := client.Train(userContext("user-1"))
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&chatbotv1.TrainRequest{
+		Payload: &chatbotv1.TrainRequest_Chunk{Chunk: &chatbotv1.DocumentChunk{Data: []byte("data")}},
+	}))
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+type keyAuthenticatorFunc func(ctx context.Context, secret string) (*apikey.Key, error)
+
+func (f keyAuthenticatorFunc) Authenticate(ctx context.Context, secret string) (*apikey.Key, error) {
+	return f(ctx, secret)
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	keys := keyAuthenticatorFunc(func(ctx context.Context, secret string) (*apikey.Key, error) {
+		switch secret {
+		case "read-key":
+			return &apikey.Key{UserID: "user-1", Scopes: []apikey.Scope{apikey.ScopeRead}, CollectionIDs: []string{"coll-1"}}, nil
+		default:
+			return nil, apikey.ErrInvalidKey
+		}
+	})
+
+	svc := mockService{
+		ListCollectionsFunc: func(ctx context.Context, userID string) ([]storage.Collection, error) {
+			assert.Equal(t, "user-1", userID)
+			return []storage.Collection{{ID: "coll-1"}, {ID: "coll-2"}}, nil
+		},
+	}
+
+	client := newTestClient(t, &svc, WithAuthenticator(APIKeyAuthenticator(keys)))
+
+	keyContext := func(secret string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), AuthorizationMetadata, "Bearer "+secret)
+	}
+
+	// The user ID metadata is not trusted without a key.
+	_, err := client.ListCollections(userContext("user-1"), &chatbotv1.ListCollectionsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.ListCollections(keyContext("wrong-key"), &chatbotv1.ListCollectionsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	resp, err := client.ListCollections(keyContext("read-key"), &chatbotv1.ListCollectionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Collections, 1)
+	assert.Equal(t, "coll-1", resp.Collections[0].Id)
+
+	_, err = client.GetCollection(keyContext("read-key"), &chatbotv1.GetCollectionRequest{CollectionId: "coll-2"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.DeleteCollection(keyContext("read-key"), &chatbotv1.DeleteCollectionRequest{CollectionId: "coll-1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	stream, err := client.Train(keyContext("read-key"))
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&chatbotv1.TrainRequest{
+		Payload: &chatbotv1.TrainRequest_Options{Options: &chatbotv1.TrainOptions{CollectionId: "coll-1"}},
+	}))
+
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/storage"
+)
+
+type askRequest struct {
+	Question string          `json:"question"`
+	Filters  []filterRequest `json:"filters"`
+}
+
+type filterRequest struct {
+	Field  string `json:"field"`
+	Op     string `json:"op"`
+	Value  any    `json:"value"`
+	Values []any  `json:"values"`
+}
+
+type askResponse struct {
+	Answer string          `json:"answer"`
+	Cached bool            `json:"cached"`
+	Chunks []chunkResponse `json:"chunks"`
+}
+
+type chunkResponse struct {
+	ID       string         `json:"id"`
+	Text     string         `json:"text"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Distance float64        `json:"distance,omitempty"`
+	Rank     float64        `json:"rank,omitempty"`
+}
+
+func (h *Handler) ask(w http.ResponseWriter, r *http.Request, userID, collectionID string) {
+	var req askRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if req.Question == "" {
+		writeError(w, http.StatusBadRequest, "question is required")
+		return
+	}
+
+	result, err := h.svc.Ask(r.Context(), chatbot.AskInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+		Question:     req.Question,
+		Filters:      toFilters(req.Filters),
+	})
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	resp := askResponse{
+		Answer: result.Answer,
+		Cached: result.Cached,
+		Chunks: make([]chunkResponse, 0, len(result.Chunks)),
+	}
+
+	for _, c := range result.Chunks {
+		resp.Chunks = append(resp.Chunks, chunkResponse{
+			ID:       c.ID,
+			Text:     c.Text,
+			Metadata: c.Metadata,
+			Distance: c.Distance,
+			Rank:     c.Rank,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func toFilters(in []filterRequest) []storage.Filter {
+	filters := make([]storage.Filter, 0, len(in))
+	for _, f := range in {
+		filters = append(filters, storage.NewFilter(f.Field, f.Op, f.Value, f.Values))
+	}
+	return filters
+}
@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/storage"
+)
+
+// multipartMemory is the part of an upload kept in memory, the rest is spooled to disk.
+const multipartMemory int64 = 8 << 20
+
+type collectionResponse struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	Chunks    int64     `json:"chunks"`
+	Tokens    int64     `json:"tokens"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func toCollectionResponse(c storage.Collection) collectionResponse {
+	return collectionResponse{
+		ID:        c.ID,
+		Model:     c.Model,
+		Chunks:    c.Chunks,
+		Tokens:    c.Tokens,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+type trainResponse struct {
+	CollectionID string `json:"collection_id"`
+}
+
+// train reads the files of a multipart request, along with the optional
+// model and metadata fields, and trains them into the collection,
+// or into a new one if collectionID is empty.
+func (h *Handler) train(w http.ResponseWriter, r *http.Request, userID, collectionID string) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, "upload too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		writeError(w, http.StatusBadRequest, "at least one file is required")
+		return
+	}
+
+	var metadata map[string]any
+	if raw := r.FormValue("metadata"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+			writeError(w, http.StatusBadRequest, "metadata must be a JSON object")
+			return
+		}
+	}
+
+	files := make([]multipart.File, 0, len(headers))
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	data := make([]io.Reader, 0, len(headers))
+	for _, fh := range headers {
+		f, err := fh.Open()
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		files = append(files, f)
+		data = append(data, f)
+	}
+
+	id, err := h.svc.Train(r.Context(), chatbot.TrainInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+		Model:        chatbot.OpenAIModel(r.FormValue("model")),
+		Data:         data,
+		Metadata:     metadata,
+	})
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if collectionID == "" {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, trainResponse{CollectionID: id})
+}
+
+func (h *Handler) listCollections(w http.ResponseWriter, r *http.Request, p Principal) {
+	collections, err := h.svc.ListCollections(r.Context(), p.UserID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	resp := make([]collectionResponse, 0, len(collections))
+	for _, c := range collections {
+		if !p.allowsCollection(c.ID) {
+			continue
+		}
+		resp = append(resp, toCollectionResponse(c))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) getCollection(w http.ResponseWriter, r *http.Request, userID, collectionID string) {
+	collection, err := h.svc.Collection(r.Context(), userID, collectionID)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toCollectionResponse(*collection))
+}
+
+func (h *Handler) deleteCollection(w http.ResponseWriter, r *http.Request, userID, collectionID string) {
+	if err := h.svc.DeleteCollection(r.Context(), userID, collectionID); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,353 @@
+// Package httpapi exposes the chatbot service as a JSON HTTP API.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/storage"
+)
+
+const (
+	defaultMaxUploadSize  int64         = 32 << 20
+	defaultRequestTimeout time.Duration = 60 * time.Second
+
+	// UserIDHeader is the header read by the default authenticator.
+	UserIDHeader string = "X-User-ID"
+)
+
+var errUnauthenticated = errors.New("missing credentials")
+
+// Service is the chatbot service exposed by the Handler.
+// It is implemented by *chatbot.Service.
+type Service interface {
+	Train(ctx context.Context, in chatbot.TrainInput) (string, error)
+	Ask(ctx context.Context, in chatbot.AskInput) (*chatbot.AskResult, error)
+	AskStream(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error)
+	ListCollections(ctx context.Context, userID string) ([]storage.Collection, error)
+	Collection(ctx context.Context, userID, collectionID string) (*storage.Collection, error)
+	DeleteCollection(ctx context.Context, userID, collectionID string) error
+}
+
+// Principal is who a request is made by.
+type Principal struct {
+	UserID string
+
+	// Key is the API key the request is made with, restricting what it may do.
+	// Nil means the request may do anything on behalf of the user.
+	Key *apikey.Key
+}
+
+func (p Principal) allows(scope apikey.Scope, collectionID string) bool {
+	return p.Key == nil || p.Key.Allows(scope, collectionID)
+}
+
+func (p Principal) hasScope(scope apikey.Scope) bool {
+	return p.Key == nil || p.Key.HasScope(scope)
+}
+
+func (p Principal) allowsCollection(collectionID string) bool {
+	return p.Key == nil || p.Key.AllowsCollection(collectionID)
+}
+
+// Authenticator returns who is making the request.
+type Authenticator func(r *http.Request) (Principal, error)
+
+// HeaderAuthenticator trusts the user ID sent in the X-User-ID header.
+// It is meant for deployments behind a gateway that authenticates users.
+func HeaderAuthenticator(r *http.Request) (Principal, error) {
+	userID := r.Header.Get(UserIDHeader)
+	if userID == "" {
+		return Principal{}, errUnauthenticated
+	}
+	return Principal{UserID: userID}, nil
+}
+
+// KeyAuthenticator authenticates API keys. It is implemented by *apikey.Manager.
+type KeyAuthenticator interface {
+	Authenticate(ctx context.Context, secret string) (*apikey.Key, error)
+}
+
+// APIKeyAuthenticator authenticates the API key sent as a bearer token
+// in the Authorization header. Requests are then limited to the scopes
+// and collections of the key.
+func APIKeyAuthenticator(keys KeyAuthenticator) Authenticator {
+	return func(r *http.Request) (Principal, error) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || secret == "" {
+			return Principal{}, errUnauthenticated
+		}
+
+		key, err := keys.Authenticate(r.Context(), secret)
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{UserID: key.UserID, Key: key}, nil
+	}
+}
+
+// Handler serves the chatbot HTTP API. When requests are authenticated
+// with API keys, each route requires the scope shown:
+//
+//	POST   /v1/collections                 train  train a new collection (multipart)
+//	GET    /v1/collections                 read   list collections
+//	GET    /v1/collections/{id}            read   get a collection
+//	DELETE /v1/collections/{id}            admin  delete a collection
+//	POST   /v1/collections/{id}/documents  train  add documents to a collection (multipart)
+//	POST   /v1/collections/{id}/ask        read   ask a question (JSON)
+//
+// It also serves an OpenAI compatible API, where each collection is a model:
+//
+//	GET    /v1/models                      read   list collections as models
+//	POST   /v1/chat/completions            read   answer the last message from the model's collection
+//
+// Keys restricted to collections only see and use those collections,
+// and cannot train new ones.
+type Handler struct {
+	svc            Service
+	authenticate   Authenticator
+	maxUploadSize  int64
+	requestTimeout time.Duration
+	logger         chatbot.Logger
+	models         map[string]string
+}
+
+// Option configures optional behaviour of the Handler.
+type Option func(*Handler)
+
+// WithAuthenticator sets how the user of each request is identified.
+// Defaults to HeaderAuthenticator.
+func WithAuthenticator(a Authenticator) Option {
+	return func(h *Handler) {
+		h.authenticate = a
+	}
+}
+
+// WithMaxUploadSize limits the size of training requests. Defaults to 32MB.
+func WithMaxUploadSize(n int64) Option {
+	return func(h *Handler) {
+		h.maxUploadSize = n
+	}
+}
+
+// WithRequestTimeout limits how long each request may take. Defaults to 60s.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.requestTimeout = d
+	}
+}
+
+// WithLogger logs requests that fail with an internal error.
+func WithLogger(l chatbot.Logger) Option {
+	return func(h *Handler) {
+		h.logger = l
+	}
+}
+
+// WithModels serves the collections under the given model names in the
+// OpenAI compatible API, mapping each name to a collection ID.
+// Collections are otherwise served under their ID.
+func WithModels(models map[string]string) Option {
+	return func(h *Handler) {
+		h.models = models
+	}
+}
+
+// New returns a new Handler serving the service.
+func New(svc Service, opts ...Option) *Handler {
+	h := &Handler{
+		svc:            svc,
+		authenticate:   HeaderAuthenticator,
+		maxUploadSize:  defaultMaxUploadSize,
+		requestTimeout: defaultRequestTimeout,
+		logger:         nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+		defer cancel()
+
+		r = r.WithContext(ctx)
+	}
+
+	p, err := h.authenticate(r)
+	if err != nil {
+		if !errors.Is(err, errUnauthenticated) && !errors.Is(err, apikey.ErrInvalidKey) {
+			h.logger.WarnContext(r.Context(), "authentication failed", "path", r.URL.Path, "error", err)
+		}
+		writeError(w, http.StatusUnauthorized, "unauthenticated")
+		return
+	}
+
+	userID := p.UserID
+
+	switch r.URL.Path {
+	case "/v1/chat/completions":
+		if r.Method != http.MethodPost {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "")
+			return
+		}
+		h.chatCompletions(w, r, p)
+		return
+	case "/v1/models":
+		if r.Method != http.MethodGet {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error", "")
+			return
+		}
+		if !p.hasScope(apikey.ScopeRead) {
+			writeOpenAIError(w, http.StatusForbidden, "the API key is not allowed to list models", "permission_error", "")
+			return
+		}
+		h.listModels(w, r, p)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/v1/collections")
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	path := strings.Trim(rest, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "":
+		switch r.Method {
+		case http.MethodPost:
+			if h.authorize(w, p, apikey.ScopeTrain, "") {
+				h.train(w, r, userID, "")
+			}
+		case http.MethodGet:
+			// Listed collections are filtered down to the ones the principal may access.
+			if !p.hasScope(apikey.ScopeRead) {
+				writeError(w, http.StatusForbidden, "forbidden")
+				return
+			}
+			h.listCollections(w, r, p)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			if h.authorize(w, p, apikey.ScopeRead, parts[0]) {
+				h.getCollection(w, r, userID, parts[0])
+			}
+		case http.MethodDelete:
+			if h.authorize(w, p, apikey.ScopeAdmin, parts[0]) {
+				h.deleteCollection(w, r, userID, parts[0])
+			}
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) == 2 && (parts[1] == "documents" || parts[1] == "ask"):
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if parts[1] == "documents" {
+			if h.authorize(w, p, apikey.ScopeTrain, parts[0]) {
+				h.train(w, r, userID, parts[0])
+			}
+			return
+		}
+
+		if h.authorize(w, p, apikey.ScopeRead, parts[0]) {
+			h.ask(w, r, userID, parts[0])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorize reports whether the principal has the scope on the collection,
+// writing a forbidden response if not.
+func (h *Handler) authorize(w http.ResponseWriter, p Principal, scope apikey.Scope, collectionID string) bool {
+	if !p.allows(scope, collectionID) {
+		writeError(w, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+// fail writes the error response matching err, logging unexpected errors.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	var quotaErr *chatbot.ErrQuotaExceeded
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "collection not found")
+	case errors.Is(err, storage.ErrInvalidFilter), errors.Is(err, chatbot.ErrModelMismatch):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.As(err, &quotaErr):
+		writeJSON(w, http.StatusTooManyRequests, quotaErrorResponse{
+			Error:     quotaErr.Error(),
+			Quota:     string(quotaErr.Quota),
+			Limit:     quotaErr.Limit,
+			Used:      quotaErr.Used,
+			Remaining: quotaErr.Remaining,
+			ResetAt:   resetAt(quotaErr.ResetAt),
+		})
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "request timed out")
+	default:
+		h.logger.ErrorContext(r.Context(), "request failed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"error", err,
+		)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type quotaErrorResponse struct {
+	Error     string     `json:"error"`
+	Quota     string     `json:"quota"`
+	Limit     int64      `json:"limit"`
+	Used      int64      `json:"used"`
+	Remaining int64      `json:"remaining"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+}
+
+func resetAt(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) DebugContext(context.Context, string, ...any) {}
+func (nopLogger) InfoContext(context.Context, string, ...any)  {}
+func (nopLogger) WarnContext(context.Context, string, ...any)  {}
+func (nopLogger) ErrorContext(context.Context, string, ...any) {}
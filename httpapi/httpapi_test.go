@@ -0,0 +1,411 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartBody(t *testing.T, files map[string]string, fields map[string]string) (io.Reader, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+
+		_, err = fw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+
+	for k, v := range fields {
+		require.NoError(t, mw.WriteField(k, v))
+	}
+
+	require.NoError(t, mw.Close())
+	return &buf, mw.FormDataContentType()
+}
+
+func TestTrain(t *testing.T) {
+	svc := mockService{
+		TrainFunc: func(ctx context.Context, in chatbot.TrainInput) (string, error) {
+			assert.Equal(t, "user-1", in.UserID)
+			assert.Equal(t, chatbot.OpenAIModel("text-embedding-3-small"), in.Model)
+			assert.Equal(t, map[string]any{"year": float64(2024)}, in.Metadata)
+			require.Len(t, in.Data, 2)
+
+			if in.CollectionID == "" {
+				return "coll-new", nil
+			}
+			return in.CollectionID, nil
+		},
+	}
+
+	h := New(&svc)
+
+	testCases := []struct {
+		name         string
+		path         string
+		expectStatus int
+		expectID     string
+	}{
+		{
+			name:         "new collection",
+			path:         "/v1/collections",
+			expectStatus: http.StatusCreated,
+			expectID:     "coll-new",
+		},
+		{
+			name:         "existing collection",
+			path:         "/v1/collections/coll-1/documents",
+			expectStatus: http.StatusOK,
+			expectID:     "coll-1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, contentType := multipartBody(t,
+				map[string]string{"a.txt": "first document", "b.txt": "second document"},
+				map[string]string{"model": "text-embedding-3-small", "metadata": `{"year": 2024}`},
+			)
+
+			req := httptest.NewRequest(http.MethodPost, tc.path, body)
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set(UserIDHeader, "user-1")
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectStatus, rec.Code)
+
+			var resp trainResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tc.expectID, resp.CollectionID)
+		})
+	}
+}
+
+func TestTrainWithoutFiles(t *testing.T) {
+	h := New(&mockService{})
+
+	body, contentType := multipartBody(t, nil, map[string]string{"model": "text-embedding-3-small"})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/collections", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(UserIDHeader, "user-1")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAsk(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	svc := mockService{
+		AskFunc: func(ctx context.Context, in chatbot.AskInput) (*chatbot.AskResult, error) {
+			assert.Equal(t, "user-1", in.UserID)
+			assert.Equal(t, "coll-1", in.CollectionID)
+			assert.Equal(t, "what?", in.Question)
+			assert.Equal(t, []storage.Filter{
+				{Field: "created", Op: storage.FilterGte, Value: from},
+				{Field: "tag", Op: storage.FilterHasTag, Value: "policy"},
+			}, in.Filters)
+
+			return &chatbot.AskResult{
+				Answer: "that",
+				Chunks: []storage.Chunk{{ID: "emb-1", Text: "context", Distance: 0.1}},
+			}, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/collections/coll-1/ask", strings.NewReader(`{
+		"question": "what?",
+		"filters": [
+			{"field": "created", "op": "gte", "value": "2024-01-01T00:00:00Z"},
+			{"field": "tag", "op": "has_tag", "value": "policy"}
+		]
+	}`))
+	req.Header.Set(UserIDHeader, "user-1")
+
+	rec := httptest.NewRecorder()
+	New(&svc).ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp askResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+	assert.Equal(t, "that", resp.Answer)
+	require.Len(t, resp.Chunks, 1)
+	assert.Equal(t, "emb-1", resp.Chunks[0].ID)
+}
+
+func TestErrors(t *testing.T) {
+	svc := mockService{
+		CollectionFunc: func(ctx context.Context, userID, collectionID string) (*storage.Collection, error) {
+			return nil, fmt.Errorf("could not fetch collection: %w", storage.ErrNotFound)
+		},
+		AskFunc: func(ctx context.Context, in chatbot.AskInput) (*chatbot.AskResult, error) {
+			switch in.CollectionID {
+			case "coll-2":
+				return nil, fmt.Errorf("could not fetch nearest neighbors: %w", storage.ErrInvalidFilter)
+			case "coll-3":
+				return nil, chatbot.ErrModelMismatch
+			}
+			return nil, &chatbot.ErrQuotaExceeded{Quota: chatbot.QuotaTokensPerDay, Limit: 10, Used: 10}
+		},
+		DeleteCollectionFunc: func(ctx context.Context, userID, collectionID string) error {
+			return fmt.Errorf("connection refused")
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		userID       string
+		expectStatus int
+	}{
+		{
+			name:         "unauthenticated",
+			method:       http.MethodGet,
+			path:         "/v1/collections",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "collection not found",
+			method:       http.MethodGet,
+			path:         "/v1/collections/coll-1",
+			userID:       "user-1",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "quota exceeded",
+			method:       http.MethodPost,
+			path:         "/v1/collections/coll-1/ask",
+			body:         `{"question": "what?"}`,
+			userID:       "user-1",
+			expectStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:         "invalid filter",
+			method:       http.MethodPost,
+			path:         "/v1/collections/coll-2/ask",
+			body:         `{"question": "what?"}`,
+			userID:       "user-1",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "model mismatch",
+			method:       http.MethodPost,
+			path:         "/v1/collections/coll-3/ask",
+			body:         `{"question": "what?"}`,
+			userID:       "user-1",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "missing question",
+			method:       http.MethodPost,
+			path:         "/v1/collections/coll-1/ask",
+			body:         `{}`,
+			userID:       "user-1",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "internal error",
+			method:       http.MethodDelete,
+			path:         "/v1/collections/coll-1",
+			userID:       "user-1",
+			expectStatus: http.StatusInternalServerError,
+		},
+		{
+			name:         "method not allowed",
+			method:       http.MethodPut,
+			path:         "/v1/collections/coll-1",
+			userID:       "user-1",
+			expectStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:         "unknown route",
+			method:       http.MethodGet,
+			path:         "/v1/collectionsx",
+			userID:       "user-1",
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.userID != "" {
+				req.Header.Set(UserIDHeader, tc.userID)
+			}
+
+			rec := httptest.NewRecorder()
+			New(&svc).ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.expectStatus, rec.Code)
+
+			var resp errorResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.NotEmpty(t, resp.Error)
+		})
+	}
+}
+
+type keyAuthenticatorFunc func(ctx context.Context, secret string) (*apikey.Key, error)
+
+func (f keyAuthenticatorFunc) Authenticate(ctx context.Context, secret string) (*apikey.Key, error) {
+	return f(ctx, secret)
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	keys := map[string]*apikey.Key{
+		"cbk_reader": {UserID: "user-1", Scopes: []apikey.Scope{apikey.ScopeRead}, CollectionIDs: []string{"coll-1"}},
+		"cbk_admin":  {UserID: "user-1", Scopes: []apikey.Scope{apikey.ScopeAdmin}},
+	}
+
+	auth := keyAuthenticatorFunc(func(ctx context.Context, secret string) (*apikey.Key, error) {
+		key, ok := keys[secret]
+		if !ok {
+			return nil, apikey.ErrInvalidKey
+		}
+		return key, nil
+	})
+
+	svc := mockService{
+		ListCollectionsFunc: func(ctx context.Context, userID string) ([]storage.Collection, error) {
+			assert.Equal(t, "user-1", userID)
+			return []storage.Collection{{ID: "coll-1"}, {ID: "coll-2"}}, nil
+		},
+		CollectionFunc: func(ctx context.Context, userID, collectionID string) (*storage.Collection, error) {
+			assert.Equal(t, "user-1", userID)
+			return &storage.Collection{ID: collectionID}, nil
+		},
+		DeleteCollectionFunc: func(ctx context.Context, userID, collectionID string) error {
+			return nil
+		},
+	}
+
+	h := New(&svc, WithAuthenticator(APIKeyAuthenticator(auth)))
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		key          string
+		expectStatus int
+		expectIDs    []string
+	}{
+		{
+			name:         "missing key",
+			method:       http.MethodGet,
+			path:         "/v1/collections",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "unknown key",
+			method:       http.MethodGet,
+			path:         "/v1/collections",
+			key:          "cbk_unknown",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "list filtered to the key collections",
+			method:       http.MethodGet,
+			path:         "/v1/collections",
+			key:          "cbk_reader",
+			expectStatus: http.StatusOK,
+			expectIDs:    []string{"coll-1"},
+		},
+		{
+			name:         "list all collections",
+			method:       http.MethodGet,
+			path:         "/v1/collections",
+			key:          "cbk_admin",
+			expectStatus: http.StatusOK,
+			expectIDs:    []string{"coll-1", "coll-2"},
+		},
+		{
+			name:         "get allowed collection",
+			method:       http.MethodGet,
+			path:         "/v1/collections/coll-1",
+			key:          "cbk_reader",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "get other collection",
+			method:       http.MethodGet,
+			path:         "/v1/collections/coll-2",
+			key:          "cbk_reader",
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "delete without admin scope",
+			method:       http.MethodDelete,
+			path:         "/v1/collections/coll-1",
+			key:          "cbk_reader",
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "train without train scope",
+			method:       http.MethodPost,
+			path:         "/v1/collections",
+			key:          "cbk_reader",
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "delete with admin scope",
+			method:       http.MethodDelete,
+			path:         "/v1/collections/coll-2",
+			key:          "cbk_admin",
+			expectStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set(UserIDHeader, "user-2")
+			if tc.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.key)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectStatus, rec.Code)
+
+			if tc.expectIDs != nil {
+				var resp []collectionResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+				var ids []string
+				for _, c := range resp {
+					ids = append(ids, c.ID)
+				}
+				assert.Equal(t, tc.expectIDs, ids)
+			}
+		})
+	}
+}
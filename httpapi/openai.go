@@ -0,0 +1,243 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/apikey"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/google/uuid"
+)
+
+type openAIError struct {
+	Error openAIErrorBody `json:"error"`
+}
+
+type openAIErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+type modelList struct {
+	Object string  `json:"object"`
+	Data   []model `json:"data"`
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// collectionID returns the collection served under the model name.
+func (h *Handler) collectionID(model string) string {
+	if id, ok := h.models[model]; ok {
+		return id
+	}
+	return model
+}
+
+// modelName returns the model name the collection is served under.
+func (h *Handler) modelName(collectionID string) string {
+	for name, id := range h.models {
+		if id == collectionID {
+			return name
+		}
+	}
+	return collectionID
+}
+
+// listModels lists the collections the principal may access as OpenAI models.
+func (h *Handler) listModels(w http.ResponseWriter, r *http.Request, p Principal) {
+	collections, err := h.svc.ListCollections(r.Context(), p.UserID)
+	if err != nil {
+		h.failOpenAI(w, r, err)
+		return
+	}
+
+	resp := modelList{Object: "list", Data: make([]model, 0, len(collections))}
+	for _, c := range collections {
+		if !p.allowsCollection(c.ID) {
+			continue
+		}
+
+		resp.Data = append(resp.Data, model{
+			ID:      h.modelName(c.ID),
+			Object:  "model",
+			Created: c.CreatedAt.Unix(),
+			OwnedBy: p.UserID,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// chatCompletions answers the last user message of an OpenAI chat completion
+// request from the collection the model maps to, passing the previous
+// messages as history. Sampling parameters are ignored.
+func (h *Handler) chatCompletions(w http.ResponseWriter, r *http.Request, p Principal) {
+	var req openaicli.CompletitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid JSON body", "invalid_request_error", "")
+		return
+	}
+
+	if req.Model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "model is required", "invalid_request_error", "")
+		return
+	}
+
+	last := len(req.Messages) - 1
+	if last < 0 || req.Messages[last].Role != "user" || req.Messages[last].Content == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "the last message must be a user message", "invalid_request_error", "")
+		return
+	}
+
+	in := chatbot.AskInput{
+		UserID:       p.UserID,
+		CollectionID: h.collectionID(req.Model),
+		Question:     req.Messages[last].Content,
+		History:      req.Messages[:last],
+	}
+
+	if !p.allows(apikey.ScopeRead, in.CollectionID) {
+		writeOpenAIError(w, http.StatusForbidden, "the API key is not allowed to use the model", "permission_error", "")
+		return
+	}
+
+	var (
+		id      = "chatcmpl-" + uuid.NewString()
+		created = int(time.Now().Unix())
+	)
+
+	if !req.Stream {
+		result, err := h.svc.Ask(r.Context(), in)
+		if err != nil {
+			h.failOpenAI(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, openaicli.CompletitionResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Model:   req.Model,
+			Created: created,
+			Choices: []openaicli.Choice{{
+				FinishReason: "stop",
+				Message:      openaicli.Message{Role: "assistant", Content: result.Answer},
+			}},
+			Usage: usage(result),
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "streaming is not supported", "server_error", "")
+		return
+	}
+
+	var started bool
+
+	send := func(choices []openaicli.ChunkChoice, u *openaicli.Usage) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		data, err := json.Marshal(openaicli.CompletitionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Model:   req.Model,
+			Created: created,
+			Choices: choices,
+			Usage:   u,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+
+	result, err := h.svc.AskStream(r.Context(), in, func(delta string) error {
+		role := ""
+		if !started {
+			role = "assistant"
+		}
+		return send([]openaicli.ChunkChoice{{Delta: openaicli.Message{Role: role, Content: delta}}}, nil)
+	})
+	if err != nil {
+		if !started {
+			h.failOpenAI(w, r, err)
+			return
+		}
+
+		// The status is already sent, so the error can only end the stream.
+		h.logger.ErrorContext(r.Context(), "stream failed", "path", r.URL.Path, "error", err)
+		return
+	}
+
+	if err := send([]openaicli.ChunkChoice{{FinishReason: "stop"}}, nil); err != nil {
+		return
+	}
+
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		u := usage(result)
+		if err := send([]openaicli.ChunkChoice{}, &u); err != nil {
+			return
+		}
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+func usage(result *chatbot.AskResult) openaicli.Usage {
+	return openaicli.Usage{
+		PromptTokens:     int(result.PromptTokens),
+		CompletionTokens: int(result.CompletionTokens),
+		TotalTokens:      int(result.PromptTokens + result.CompletionTokens),
+	}
+}
+
+// failOpenAI writes the error response matching err in the OpenAI format, logging unexpected errors.
+func (h *Handler) failOpenAI(w http.ResponseWriter, r *http.Request, err error) {
+	var quotaErr *chatbot.ErrQuotaExceeded
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeOpenAIError(w, http.StatusNotFound, "model not found", "invalid_request_error", "model_not_found")
+	case errors.Is(err, storage.ErrInvalidFilter), errors.Is(err, chatbot.ErrModelMismatch):
+		writeOpenAIError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", "")
+	case errors.As(err, &quotaErr):
+		writeOpenAIError(w, http.StatusTooManyRequests, quotaErr.Error(), "insufficient_quota", "insufficient_quota")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeOpenAIError(w, http.StatusGatewayTimeout, "request timed out", "timeout", "")
+	default:
+		h.logger.ErrorContext(r.Context(), "request failed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"error", err,
+		)
+		writeOpenAIError(w, http.StatusInternalServerError, "internal error", "server_error", "")
+	}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, msg, typ, code string) {
+	writeJSON(w, status, openAIError{Error: openAIErrorBody{Message: msg, Type: typ, Code: code}})
+}
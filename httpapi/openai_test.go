@@ -0,0 +1,200 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOpenAITestService(t *testing.T) *mockService {
+	expectInput := func(in chatbot.AskInput) error {
+		switch in.CollectionID {
+		case "coll-1":
+		case "coll-reindexing":
+			return chatbot.ErrModelMismatch
+		case "coll-slow":
+			return fmt.Errorf("could not create completition: %w", context.DeadlineExceeded)
+		default:
+			return storage.ErrNotFound
+		}
+
+		assert.Equal(t, "user-1", in.UserID)
+		assert.Equal(t, "and now?", in.Question)
+		assert.Equal(t, []openaicli.Message{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+		}, in.History)
+		return nil
+	}
+
+	return &mockService{
+		AskFunc: func(ctx context.Context, in chatbot.AskInput) (*chatbot.AskResult, error) {
+			if err := expectInput(in); err != nil {
+				return nil, err
+			}
+			return &chatbot.AskResult{Answer: "now this", PromptTokens: 10, CompletionTokens: 2}, nil
+		},
+		AskStreamFunc: func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+			if err := expectInput(in); err != nil {
+				return nil, err
+			}
+
+			for _, d := range []string{"now", " this"} {
+				if err := onDelta(d); err != nil {
+					return nil, err
+				}
+			}
+			return &chatbot.AskResult{Answer: "now this", PromptTokens: 10, CompletionTokens: 2}, nil
+		},
+	}
+}
+
+func chatRequest(t *testing.T, body string) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set(UserIDHeader, "user-1")
+	return req
+}
+
+const chatBody = `{
+	"model": %q,
+	"stream": %t,
+	"stream_options": {"include_usage": true},
+	"temperature": 0.2,
+	"messages": [
+		{"role": "user", "content": "hi"},
+		{"role": "assistant", "content": "hello"},
+		{"role": "user", "content": "and now?"}
+	]
+}`
+
+func TestChatCompletions(t *testing.T) {
+	h := New(newOpenAITestService(t), WithModels(map[string]string{"support": "coll-1"}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, chatRequest(t, fmt.Sprintf(chatBody, "support", false)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp openaicli.CompletitionResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+	assert.Equal(t, "chat.completion", resp.Object)
+	assert.Equal(t, "support", resp.Model)
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, openaicli.Message{Role: "assistant", Content: "now this"}, resp.Choices[0].Message)
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason)
+	assert.Equal(t, 12, resp.Usage.TotalTokens)
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	h := New(newOpenAITestService(t))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, chatRequest(t, fmt.Sprintf(chatBody, "coll-1", true)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	var (
+		chunks []openaicli.CompletitionChunk
+		done   bool
+	)
+
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+
+		var chunk openaicli.CompletitionChunk
+		require.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		chunks = append(chunks, chunk)
+	}
+
+	assert.True(t, done)
+	require.Len(t, chunks, 4)
+
+	assert.Equal(t, openaicli.Message{Role: "assistant", Content: "now"}, chunks[0].Choices[0].Delta)
+	assert.Equal(t, openaicli.Message{Content: " this"}, chunks[1].Choices[0].Delta)
+	assert.Equal(t, "stop", chunks[2].Choices[0].FinishReason)
+
+	assert.Empty(t, chunks[3].Choices)
+	require.NotNil(t, chunks[3].Usage)
+	assert.Equal(t, 12, chunks[3].Usage.TotalTokens)
+
+	for _, c := range chunks {
+		assert.Equal(t, "chat.completion.chunk", c.Object)
+		assert.Equal(t, chunks[0].ID, c.ID)
+	}
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	h := New(newOpenAITestService(t))
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectStatus int
+		expectCode   string
+	}{
+		{
+			name:         "unknown model",
+			body:         fmt.Sprintf(chatBody, "coll-2", false),
+			expectStatus: http.StatusNotFound,
+			expectCode:   "model_not_found",
+		},
+		{
+			name:         "timeout",
+			body:         fmt.Sprintf(chatBody, "coll-slow", false),
+			expectStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:         "model mismatch",
+			body:         fmt.Sprintf(chatBody, "coll-reindexing", false),
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "last message is not from the user",
+			body:         `{"model": "coll-1", "messages": [{"role": "assistant", "content": "hello"}]}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "missing model",
+			body:         `{"messages": [{"role": "user", "content": "hello"}]}`,
+			expectStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, chatRequest(t, tc.body))
+
+			assert.Equal(t, tc.expectStatus, rec.Code)
+
+			var resp openAIError
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.NotEmpty(t, resp.Error.Message)
+			assert.Equal(t, tc.expectCode, resp.Error.Code)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package httpapi
+
+import (
+	"context"
+
+	"github.com/alesr/chatbot"
+	"github.com/alesr/chatbot/storage"
+)
+
+var _ Service = &mockService{}
+
+type mockService struct {
+	TrainFunc            func(ctx context.Context, in chatbot.TrainInput) (string, error)
+	AskFunc              func(ctx context.Context, in chatbot.AskInput) (*chatbot.AskResult, error)
+	AskStreamFunc        func(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error)
+	ListCollectionsFunc  func(ctx context.Context, userID string) ([]storage.Collection, error)
+	CollectionFunc       func(ctx context.Context, userID, collectionID string) (*storage.Collection, error)
+	DeleteCollectionFunc func(ctx context.Context, userID, collectionID string) error
+}
+
+func (m *mockService) Train(ctx context.Context, in chatbot.TrainInput) (string, error) {
+	return m.TrainFunc(ctx, in)
+}
+
+func (m *mockService) Ask(ctx context.Context, in chatbot.AskInput) (*chatbot.AskResult, error) {
+	return m.AskFunc(ctx, in)
+}
+
+func (m *mockService) AskStream(ctx context.Context, in chatbot.AskInput, onDelta func(delta string) error) (*chatbot.AskResult, error) {
+	return m.AskStreamFunc(ctx, in, onDelta)
+}
+
+func (m *mockService) ListCollections(ctx context.Context, userID string) ([]storage.Collection, error) {
+	return m.ListCollectionsFunc(ctx, userID)
+}
+
+func (m *mockService) Collection(ctx context.Context, userID, collectionID string) (*storage.Collection, error) {
+	return m.CollectionFunc(ctx, userID, collectionID)
+}
+
+func (m *mockService) DeleteCollection(ctx context.Context, userID, collectionID string) error {
+	return m.DeleteCollectionFunc(ctx, userID, collectionID)
+}
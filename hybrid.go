@@ -0,0 +1,137 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/alesr/chatbot/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultHybridCandidates int = 20
+	defaultRRFConstant      int = 60
+)
+
+// HybridSearch configures hybrid retrieval, which combines
+// the vector ranking with a Postgres full-text search ranking
+// using weighted reciprocal rank fusion.
+type HybridSearch struct {
+	// VectorWeight and KeywordWeight scale the contribution of each ranking.
+	// When both are zero, the rankings are weighted equally.
+	VectorWeight  float64
+	KeywordWeight float64
+
+	// Candidates is the number of chunks fetched from each ranking.
+	// Defaults to 20.
+	Candidates int
+
+	// RRFConstant dampens the influence of top ranks. Defaults to 60.
+	RRFConstant int
+}
+
+// hybridSearch fetches vector and keyword candidates concurrently
+// and returns them fused into a single ranking.
+func (s *Service) hybridSearch(ctx context.Context, in AskInput, vector []float32) ([]storage.Chunk, error) {
+	candidates := s.hybrid.Candidates
+	if candidates <= 0 {
+		candidates = defaultHybridCandidates
+	}
+
+	if candidates < s.topK {
+		candidates = s.topK
+	}
+
+	var vectorRanked, keywordRanked []storage.Chunk
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		chunks, err := s.repo.FetchNearestNeighbors(gctx, storage.FetchNearestNeighborsInput{
+			UserID:       in.UserID,
+			CollectionID: in.CollectionID,
+			Vector:       vector,
+			Filters:      in.Filters,
+			Limit:        candidates,
+		})
+		if err != nil {
+			return fmt.Errorf("could not fetch nearest neighbors: %w", err)
+		}
+		vectorRanked = chunks
+		return nil
+	})
+
+	g.Go(func() error {
+		chunks, err := s.repo.FetchKeywordMatches(gctx, storage.FetchKeywordMatchesInput{
+			UserID:       in.UserID,
+			CollectionID: in.CollectionID,
+			Query:        in.Question,
+			Language:     s.textLanguage,
+			Filters:      in.Filters,
+			Limit:        candidates,
+		})
+		if err != nil {
+			return fmt.Errorf("could not fetch keyword matches: %w", err)
+		}
+		keywordRanked = chunks
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if len(vectorRanked) > 0 {
+		s.metrics.ObserveNearestDistance(vectorRanked[0].Distance)
+	}
+
+	return fuseRankings(withinDistance(vectorRanked, in.MaxDistance), keywordRanked, *s.hybrid), nil
+}
+
+// fuseRankings merges the rankings using weighted reciprocal rank fusion,
+// where each chunk scores the sum of weight / (k + rank) over the rankings it appears in.
+func fuseRankings(vectorRanked, keywordRanked []storage.Chunk, cfg HybridSearch) []storage.Chunk {
+	vectorWeight, keywordWeight := cfg.VectorWeight, cfg.KeywordWeight
+	if vectorWeight == 0 && keywordWeight == 0 {
+		vectorWeight, keywordWeight = 1, 1
+	}
+
+	k := cfg.RRFConstant
+	if k <= 0 {
+		k = defaultRRFConstant
+	}
+
+	var (
+		scores = make(map[string]float64)
+		chunks = make(map[string]storage.Chunk)
+	)
+
+	for i, c := range vectorRanked {
+		scores[c.ID] += vectorWeight / float64(k+i+1)
+		chunks[c.ID] = c
+	}
+
+	for i, c := range keywordRanked {
+		scores[c.ID] += keywordWeight / float64(k+i+1)
+
+		if existing, ok := chunks[c.ID]; ok {
+			existing.Rank = c.Rank
+			c = existing
+		}
+		chunks[c.ID] = c
+	}
+
+	fused := make([]storage.Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		fused = append(fused, c)
+	}
+
+	sort.Slice(fused, func(i, j int) bool {
+		if scores[fused[i].ID] != scores[fused[j].ID] {
+			return scores[fused[i].ID] > scores[fused[j].ID]
+		}
+		return fused[i].ID < fused[j].ID
+	})
+	return fused
+}
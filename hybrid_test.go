@@ -0,0 +1,111 @@
+package chatbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuseRankings(t *testing.T) {
+	vectorRanked := []storage.Chunk{
+		{ID: "a", Text: "a", Distance: 0.1},
+		{ID: "b", Text: "b", Distance: 0.2},
+		{ID: "c", Text: "c", Distance: 0.3},
+	}
+
+	keywordRanked := []storage.Chunk{
+		{ID: "c", Text: "c", Rank: 0.9},
+		{ID: "d", Text: "d", Rank: 0.5},
+	}
+
+	tests := []struct {
+		name     string
+		cfg      HybridSearch
+		expected []string
+	}{
+		{
+			name:     "Equal weights",
+			cfg:      HybridSearch{},
+			expected: []string{"c", "a", "b", "d"},
+		},
+		{
+			name:     "Vector only",
+			cfg:      HybridSearch{VectorWeight: 1},
+			expected: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "Keyword favoured",
+			cfg:      HybridSearch{VectorWeight: 1, KeywordWeight: 3},
+			expected: []string{"c", "d", "a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fused := fuseRankings(vectorRanked, keywordRanked, tt.cfg)
+
+			ids := make([]string, 0, len(fused))
+			for _, c := range fused {
+				ids = append(ids, c.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+
+	t.Run("Chunk in both rankings keeps distance and rank", func(t *testing.T) {
+		fused := fuseRankings(vectorRanked, keywordRanked, HybridSearch{})
+		require.Equal(t, "c", fused[0].ID)
+		assert.Equal(t, 0.3, fused[0].Distance)
+		assert.Equal(t, 0.9, fused[0].Rank)
+	})
+}
+
+func TestAskHybridSearch(t *testing.T) {
+	var systemPrompt string
+
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			systemPrompt = in.Messages[0].Content
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "42"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			assert.Equal(t, defaultHybridCandidates, in.Limit)
+			return []storage.Chunk{{ID: "emb-1", Text: "vector match"}}, nil
+		},
+		FetchKeywordMatchesFunc: func(ctx context.Context, in storage.FetchKeywordMatchesInput) ([]storage.Chunk, error) {
+			assert.Equal(t, "simple", in.Language)
+			assert.Equal(t, "What is ERR-1234?", in.Query)
+			return []storage.Chunk{{ID: "emb-2", Text: "keyword match"}}, nil
+		},
+	}
+
+	svc := NewService("test-api-key", &client, &client, &repo,
+		WithTextSearchLanguage("simple"),
+		WithHybridSearch(HybridSearch{VectorWeight: 1, KeywordWeight: 2}),
+	)
+
+	answer, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: "coll-1",
+		Question:     "What is ERR-1234?",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "42", answer.Answer)
+	assert.Equal(t, "keyword match", systemPrompt)
+}
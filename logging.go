@@ -0,0 +1,45 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+)
+
+// Logger is the structured logger used by the Service.
+// It is implemented by *slog.Logger.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) DebugContext(context.Context, string, ...any) {}
+func (nopLogger) InfoContext(context.Context, string, ...any)  {}
+func (nopLogger) WarnContext(context.Context, string, ...any)  {}
+func (nopLogger) ErrorContext(context.Context, string, ...any) {}
+
+// WithLogger logs training, chunking, embedding,
+// storage and completion events.
+func WithLogger(l Logger) Option {
+	return func(s *Service) {
+		s.logger = l
+	}
+}
+
+// WithTextRedaction replaces questions and answers in log events with their length.
+func WithTextRedaction() Option {
+	return func(s *Service) {
+		s.redactText = true
+	}
+}
+
+// redact returns the text, or a placeholder with its length when redaction is enabled.
+func (s *Service) redact(text string) string {
+	if s.redactText {
+		return fmt.Sprintf("[redacted %d bytes]", len(text))
+	}
+	return text
+}
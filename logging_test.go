@@ -0,0 +1,163 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level string
+	msg   string
+	attrs map[string]any
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) record(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attrs := make(map[string]any, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[args[i].(string)] = args[i+1]
+	}
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, attrs: attrs})
+}
+
+func (l *recordingLogger) DebugContext(_ context.Context, msg string, args ...any) {
+	l.record("debug", msg, args)
+}
+
+func (l *recordingLogger) InfoContext(_ context.Context, msg string, args ...any) {
+	l.record("info", msg, args)
+}
+
+func (l *recordingLogger) WarnContext(_ context.Context, msg string, args ...any) {
+	l.record("warn", msg, args)
+}
+
+func (l *recordingLogger) ErrorContext(_ context.Context, msg string, args ...any) {
+	l.record("error", msg, args)
+}
+
+func (l *recordingLogger) find(msg string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var found []logEntry
+	for _, e := range l.entries {
+		if e.msg == msg {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func TestAskLogging(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "secret answer"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "emb-1", Text: "context"}}, nil
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		opts           []Option
+		expectQuestion string
+		expectAnswer   string
+	}{
+		{
+			name:           "text is logged by default",
+			expectQuestion: "secret question",
+			expectAnswer:   "secret answer",
+		},
+		{
+			name:           "text is redacted",
+			opts:           []Option{WithTextRedaction()},
+			expectQuestion: "[redacted 15 bytes]",
+			expectAnswer:   "[redacted 13 bytes]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := recordingLogger{}
+
+			svc := NewService("test-api-key", &client, &client, &repo, append(tc.opts, WithLogger(&logger))...)
+
+			_, err := svc.Ask(context.Background(), AskInput{
+				UserID:       "test-user",
+				CollectionID: "coll-1",
+				Question:     "secret question",
+			})
+			require.NoError(t, err)
+
+			entries := logger.find("completion created")
+			require.Len(t, entries, 1)
+
+			assert.Equal(t, "info", entries[0].level)
+			assert.Equal(t, tc.expectQuestion, entries[0].attrs["question"])
+			assert.Equal(t, tc.expectAnswer, entries[0].attrs["answer"])
+
+			assert.Len(t, logger.find("embedding created"), 1)
+			assert.Len(t, logger.find("chunks retrieved"), 1)
+		})
+	}
+}
+
+func TestTrainLogsChunkErrors(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return nil, errors.New("embedding failed")
+		},
+	}
+
+	logger := recordingLogger{}
+
+	svc := NewService("test-api-key", &client, &client, &mockRepository{}, WithLogger(&logger))
+
+	_, err := svc.Train(context.Background(), TrainInput{
+		UserID: "test-user",
+		Model:  defaultModel,
+		Data:   []io.Reader{strings.NewReader("some data"), strings.NewReader("more data")},
+	})
+	require.Error(t, err)
+
+	chunked := logger.find("data chunked")
+	require.Len(t, chunked, 1)
+	assert.Equal(t, 2, chunked[0].attrs["chunks"])
+
+	failed := logger.find("could not train chunk")
+	require.NotEmpty(t, failed)
+
+	for _, e := range failed {
+		assert.Equal(t, "error", e.level)
+		assert.ErrorContains(t, e.attrs["error"].(error), "embedding failed")
+	}
+}
@@ -0,0 +1,167 @@
+// Package metrics provides Prometheus instrumentation for the chatbot service,
+// the OpenAI client and the storage.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace string = "chatbot"
+
+// Collector holds the Prometheus metrics of the chatbot.
+// A nil *Collector is valid and records nothing, so packages
+// can call it unconditionally when metrics are disabled.
+type Collector struct {
+	serviceRequests *prometheus.CounterVec
+	serviceDuration *prometheus.HistogramVec
+
+	clientRequests *prometheus.CounterVec
+	clientDuration *prometheus.HistogramVec
+	clientTokens   *prometheus.CounterVec
+
+	storageQueries  *prometheus.CounterVec
+	storageDuration *prometheus.HistogramVec
+
+	retrievalDistance prometheus.Histogram
+}
+
+// New returns a new Collector with its metrics registered in reg.
+func New(reg prometheus.Registerer) (*Collector, error) {
+	c := &Collector{
+		serviceRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "service",
+			Name:      "requests_total",
+			Help:      "Number of Train and Ask calls by operation and status.",
+		}, []string{"operation", "status"}),
+
+		serviceDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "service",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of Train and Ask calls by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"operation"}),
+
+		clientRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "openai",
+			Name:      "requests_total",
+			Help:      "Number of OpenAI API requests by endpoint and HTTP status.",
+		}, []string{"endpoint", "status"}),
+
+		clientDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "openai",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of OpenAI API requests by endpoint.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"endpoint"}),
+
+		clientTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "openai",
+			Name:      "tokens_total",
+			Help:      "Number of tokens consumed by endpoint, model and type (prompt or completion).",
+		}, []string{"endpoint", "model", "type"}),
+
+		storageQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "queries_total",
+			Help:      "Number of storage queries by operation and status.",
+		}, []string{"operation", "status"}),
+
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of storage queries by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"operation"}),
+
+		retrievalDistance: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "retrieval",
+			Name:      "nearest_neighbor_distance",
+			Help:      "Distance between the question and its nearest chunk.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 15),
+		}),
+	}
+
+	for _, m := range []prometheus.Collector{
+		c.serviceRequests, c.serviceDuration,
+		c.clientRequests, c.clientDuration, c.clientTokens,
+		c.storageQueries, c.storageDuration,
+		c.retrievalDistance,
+	} {
+		if err := reg.Register(m); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ObserveServiceCall records a Train or Ask call.
+func (c *Collector) ObserveServiceCall(operation string, d time.Duration, err error) {
+	if c == nil {
+		return
+	}
+
+	c.serviceRequests.WithLabelValues(operation, status(err)).Inc()
+	c.serviceDuration.WithLabelValues(operation).Observe(d.Seconds())
+}
+
+// ObserveClientCall records an OpenAI API request. A zero statusCode
+// means the request failed before a response was received.
+func (c *Collector) ObserveClientCall(endpoint string, statusCode int, d time.Duration) {
+	if c == nil {
+		return
+	}
+
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+
+	c.clientRequests.WithLabelValues(endpoint, code).Inc()
+	c.clientDuration.WithLabelValues(endpoint).Observe(d.Seconds())
+}
+
+// ObserveClientTokens records the tokens consumed by an OpenAI API request.
+func (c *Collector) ObserveClientTokens(endpoint, model string, promptTokens, completionTokens int) {
+	if c == nil {
+		return
+	}
+
+	c.clientTokens.WithLabelValues(endpoint, model, "prompt").Add(float64(promptTokens))
+	c.clientTokens.WithLabelValues(endpoint, model, "completion").Add(float64(completionTokens))
+}
+
+// ObserveQuery records a storage query.
+func (c *Collector) ObserveQuery(operation string, d time.Duration, err error) {
+	if c == nil {
+		return
+	}
+
+	c.storageQueries.WithLabelValues(operation, status(err)).Inc()
+	c.storageDuration.WithLabelValues(operation).Observe(d.Seconds())
+}
+
+// ObserveNearestDistance records the distance between a question and its nearest chunk.
+func (c *Collector) ObserveNearestDistance(distance float64) {
+	if c == nil {
+		return
+	}
+	c.retrievalDistance.Observe(distance)
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
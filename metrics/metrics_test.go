@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	c, err := New(reg)
+	require.NoError(t, err)
+
+	c.ObserveServiceCall("ask", time.Second, nil)
+	c.ObserveServiceCall("ask", time.Second, errors.New("boom"))
+	c.ObserveClientCall("/embeddings", 200, time.Second)
+	c.ObserveClientCall("/embeddings", 0, time.Second)
+	c.ObserveClientTokens("/chat/completions", "gpt-3.5-turbo", 10, 5)
+	c.ObserveQuery("FetchNearestNeighbors", time.Millisecond, nil)
+	c.ObserveNearestDistance(0.3)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.serviceRequests.WithLabelValues("ask", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.serviceRequests.WithLabelValues("ask", "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.clientRequests.WithLabelValues("/embeddings", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.clientRequests.WithLabelValues("/embeddings", "error")))
+	assert.Equal(t, 10.0, testutil.ToFloat64(c.clientTokens.WithLabelValues("/chat/completions", "gpt-3.5-turbo", "prompt")))
+	assert.Equal(t, 5.0, testutil.ToFloat64(c.clientTokens.WithLabelValues("/chat/completions", "gpt-3.5-turbo", "completion")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.storageQueries.WithLabelValues("FetchNearestNeighbors", "ok")))
+	assert.Equal(t, 1, testutil.CollectAndCount(c.retrievalDistance))
+
+	// Registering twice in the same registry fails.
+	_, err = New(reg)
+	assert.Error(t, err)
+}
+
+func TestNilCollector(t *testing.T) {
+	var c *Collector
+
+	assert.NotPanics(t, func() {
+		c.ObserveServiceCall("train", time.Second, nil)
+		c.ObserveClientCall("/embeddings", 200, time.Second)
+		c.ObserveClientTokens("/embeddings", "model", 1, 0)
+		c.ObserveQuery("StoreEmbeddings", time.Second, nil)
+		c.ObserveNearestDistance(0.1)
+	})
+}
@@ -0,0 +1,3 @@
+-- Shadow collections are built by reindexing to replace another collection,
+-- and are neither listed nor counted until they do.
+ALTER TABLE embeddings ADD COLUMN shadow BOOLEAN NOT NULL DEFAULT FALSE;
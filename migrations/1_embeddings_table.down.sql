@@ -1,2 +1,2 @@
-DROP TABLE IF EXISTS embeddings
+DROP TABLE IF EXISTS embeddings;
 DROP EXTENSION IF EXISTS vector;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS embeddings_text_search_idx;
+
+ALTER TABLE embeddings DROP COLUMN IF EXISTS text_search;
+ALTER TABLE embeddings DROP COLUMN IF EXISTS language;
@@ -0,0 +1,4 @@
+ALTER TABLE embeddings ADD COLUMN language regconfig NOT NULL DEFAULT 'english';
+ALTER TABLE embeddings ADD COLUMN text_search tsvector GENERATED ALWAYS AS (to_tsvector(language, text)) STORED;
+
+CREATE INDEX embeddings_text_search_idx ON embeddings USING GIN (text_search);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS embeddings_metadata_idx;
+
+ALTER TABLE embeddings DROP COLUMN IF EXISTS metadata;
@@ -0,0 +1,3 @@
+ALTER TABLE embeddings ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX embeddings_metadata_idx ON embeddings USING GIN (metadata);
@@ -0,0 +1,9 @@
+CREATE TABLE embedding_cache (
+    model VARCHAR(255) NOT NULL,
+    dimensions INTEGER NOT NULL,
+    text_hash CHAR(64) NOT NULL,
+    vector vector NOT NULL,
+    tokens INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (model, dimensions, text_hash)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE answer_cache (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    collection_id VARCHAR(255) NOT NULL,
+    collection_version VARCHAR(255) NOT NULL,
+    question TEXT NOT NULL,
+    vector vector(1536) NOT NULL,
+    answer TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX answer_cache_user_id_collection_id_idx ON answer_cache(user_id, collection_id);
@@ -0,0 +1,13 @@
+CREATE TABLE usage_ledger (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    collection_id VARCHAR(255) NOT NULL,
+    operation VARCHAR(255) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX usage_ledger_user_id_created_at_idx ON usage_ledger(user_id, created_at);
+CREATE INDEX usage_ledger_collection_id_created_at_idx ON usage_ledger(collection_id, created_at);
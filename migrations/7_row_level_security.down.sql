@@ -0,0 +1,8 @@
+DROP POLICY IF EXISTS usage_ledger_tenant_isolation ON usage_ledger;
+ALTER TABLE usage_ledger DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS answer_cache_tenant_isolation ON answer_cache;
+ALTER TABLE answer_cache DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS embeddings_tenant_isolation ON embeddings;
+ALTER TABLE embeddings DISABLE ROW LEVEL SECURITY;
@@ -0,0 +1,18 @@
+-- embedding_cache is shared by all tenants on purpose and has no policy: its
+-- rows hold no user ID, only the vector of a text keyed by its hash, and are
+-- served only to whoever embeds the same text again.
+
+ALTER TABLE embeddings ENABLE ROW LEVEL SECURITY;
+CREATE POLICY embeddings_tenant_isolation ON embeddings
+    USING (user_id = current_setting('chatbot.user_id', true))
+    WITH CHECK (user_id = current_setting('chatbot.user_id', true));
+
+ALTER TABLE answer_cache ENABLE ROW LEVEL SECURITY;
+CREATE POLICY answer_cache_tenant_isolation ON answer_cache
+    USING (user_id = current_setting('chatbot.user_id', true))
+    WITH CHECK (user_id = current_setting('chatbot.user_id', true));
+
+ALTER TABLE usage_ledger ENABLE ROW LEVEL SECURITY;
+CREATE POLICY usage_ledger_tenant_isolation ON usage_ledger
+    USING (user_id = current_setting('chatbot.user_id', true))
+    WITH CHECK (user_id = current_setting('chatbot.user_id', true));
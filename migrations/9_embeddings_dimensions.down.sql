@@ -0,0 +1,5 @@
+ALTER TABLE embeddings DROP COLUMN IF EXISTS dimensions;
+
+ALTER TABLE answer_cache ALTER COLUMN vector TYPE vector(1536);
+
+ALTER TABLE embeddings ALTER COLUMN vector TYPE vector(1536);
@@ -0,0 +1,8 @@
+-- Collections may be embedded by models of any size,
+-- so vectors are no longer constrained to 1536 dimensions.
+ALTER TABLE embeddings ALTER COLUMN vector TYPE vector;
+
+ALTER TABLE answer_cache ALTER COLUMN vector TYPE vector;
+
+-- The dimensions requested from the model, 0 for its default.
+ALTER TABLE embeddings ADD COLUMN dimensions INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migrations of the Postgres schema,
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// They are applied with storage.Migrate, or with the migrate CLI.
+package migrations
+
+import "embed"
+
+// FS holds the migration files.
+//
+//go:embed *.sql
+var FS embed.FS
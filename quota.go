@@ -0,0 +1,179 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+)
+
+// QuotaKind identifies a quota.
+type QuotaKind string
+
+const (
+	QuotaTokensPerDay           QuotaKind = "tokens_per_day"
+	QuotaTokensPerMonth         QuotaKind = "tokens_per_month"
+	QuotaMaxCollections         QuotaKind = "max_collections"
+	QuotaMaxChunksPerCollection QuotaKind = "max_chunks_per_collection"
+)
+
+type (
+	// QuotaStore represents the storage queried for the current usage of a user.
+	QuotaStore interface {
+		FetchUserTokens(ctx context.Context, in storage.FetchUserTokensInput) (int64, error)
+		FetchCollectionCount(ctx context.Context, in storage.FetchCollectionCountInput) (int64, error)
+	}
+
+	// Quotas represents the limits of a user. Zero values are unlimited.
+	// Token quotas count the tokens recorded in the usage ledger,
+	// and reset at midnight UTC and on the first day of the month UTC.
+	Quotas struct {
+		TokensPerDay           int64
+		TokensPerMonth         int64
+		MaxCollections         int64
+		MaxChunksPerCollection int64
+	}
+
+	// QuotaPolicy returns the quotas of a user.
+	QuotaPolicy func(userID string) Quotas
+
+	// ErrQuotaExceeded is returned when a request would exceed one of the user's quotas.
+	ErrQuotaExceeded struct {
+		Quota QuotaKind
+		Limit int64
+		Used  int64
+
+		// Remaining is what is still available under the quota. For the chunks
+		// per collection quota, it is the number of chunks a collection may have.
+		Remaining int64
+
+		// ResetAt is when the quota resets. It is zero for quotas that do not reset.
+		ResetAt time.Time
+	}
+)
+
+// UniformQuotas returns a policy applying the same quotas to every user.
+func UniformQuotas(q Quotas) QuotaPolicy {
+	return func(string) Quotas {
+		return q
+	}
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	msg := fmt.Sprintf("quota %s exceeded: used %d of %d", e.Quota, e.Used, e.Limit)
+	if !e.ResetAt.IsZero() {
+		msg += fmt.Sprintf(", resets at %s", e.ResetAt.Format(time.RFC3339))
+	}
+	return msg
+}
+
+// checkTrainQuotas checks that training the given number of chunks into
+// the existing collection, or into a new one if nil, is within the user's quotas.
+func (s *Service) checkTrainQuotas(ctx context.Context, userID string, chunks int, existing *storage.Collection) error {
+	if s.quotaPolicy == nil {
+		return nil
+	}
+
+	if err := s.checkTokenQuotas(ctx, userID); err != nil {
+		return err
+	}
+	return s.checkCollectionQuotas(ctx, userID, chunks, existing)
+}
+
+// checkCollectionQuotas checks that adding the given number of chunks to the existing
+// collection, or to a new one if nil, is within the user's collection and chunk quotas.
+func (s *Service) checkCollectionQuotas(ctx context.Context, userID string, chunks int, existing *storage.Collection) error {
+	if s.quotaPolicy == nil {
+		return nil
+	}
+
+	quotas := s.quotaPolicy(userID)
+
+	var used int64
+	if existing != nil {
+		used = existing.Chunks
+	}
+
+	if limit := quotas.MaxChunksPerCollection; limit > 0 && used+int64(chunks) > limit {
+		return &ErrQuotaExceeded{
+			Quota:     QuotaMaxChunksPerCollection,
+			Limit:     limit,
+			Used:      used + int64(chunks),
+			Remaining: remaining(limit, used),
+		}
+	}
+
+	if limit := quotas.MaxCollections; limit > 0 && existing == nil {
+		count, err := s.quotaStore.FetchCollectionCount(ctx, storage.FetchCollectionCountInput{UserID: userID})
+		if err != nil {
+			return fmt.Errorf("could not fetch collection count: %w", err)
+		}
+
+		if count >= limit {
+			return &ErrQuotaExceeded{
+				Quota:     QuotaMaxCollections,
+				Limit:     limit,
+				Used:      count,
+				Remaining: remaining(limit, count),
+			}
+		}
+	}
+	return nil
+}
+
+// checkTokenQuotas checks that the user has tokens left for the current day and month.
+// The tokens of the call about to be made are not known yet, so they are not counted.
+func (s *Service) checkTokenQuotas(ctx context.Context, userID string) error {
+	if s.quotaPolicy == nil {
+		return nil
+	}
+
+	quotas := s.quotaPolicy(userID)
+	now := time.Now().UTC()
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	checks := []struct {
+		quota   QuotaKind
+		limit   int64
+		since   time.Time
+		resetAt time.Time
+	}{
+		{QuotaTokensPerDay, quotas.TokensPerDay, dayStart, dayStart.AddDate(0, 0, 1)},
+		{QuotaTokensPerMonth, quotas.TokensPerMonth, monthStart, monthStart.AddDate(0, 1, 0)},
+	}
+
+	for _, c := range checks {
+		if c.limit <= 0 {
+			continue
+		}
+
+		used, err := s.quotaStore.FetchUserTokens(ctx, storage.FetchUserTokensInput{
+			UserID: userID,
+			Since:  c.since,
+		})
+		if err != nil {
+			return fmt.Errorf("could not fetch user tokens: %w", err)
+		}
+
+		if used >= c.limit {
+			return &ErrQuotaExceeded{
+				Quota:     c.quota,
+				Limit:     c.limit,
+				Used:      used,
+				Remaining: remaining(c.limit, used),
+				ResetAt:   c.resetAt,
+			}
+		}
+	}
+	return nil
+}
+
+func remaining(limit, used int64) int64 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
@@ -0,0 +1,132 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockQuotaStore struct {
+	tokens      int64
+	collections int64
+}
+
+func (m *mockQuotaStore) FetchUserTokens(ctx context.Context, in storage.FetchUserTokensInput) (int64, error) {
+	return m.tokens, nil
+}
+
+func (m *mockQuotaStore) FetchCollectionCount(ctx context.Context, in storage.FetchCollectionCountInput) (int64, error) {
+	return m.collections, nil
+}
+
+func TestQuotas(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			t.Fatal("client must not be called when a quota is exceeded")
+			return nil, nil
+		},
+	}
+
+	tests := []struct {
+		name          string
+		store         mockQuotaStore
+		quotas        Quotas
+		expectedQuota QuotaKind
+		expectedReset bool
+	}{
+		{
+			name:          "Tokens per day",
+			store:         mockQuotaStore{tokens: 100},
+			quotas:        Quotas{TokensPerDay: 100},
+			expectedQuota: QuotaTokensPerDay,
+			expectedReset: true,
+		},
+		{
+			name:          "Tokens per month",
+			store:         mockQuotaStore{tokens: 100},
+			quotas:        Quotas{TokensPerDay: 1000, TokensPerMonth: 50},
+			expectedQuota: QuotaTokensPerMonth,
+			expectedReset: true,
+		},
+		{
+			name:          "Max collections",
+			store:         mockQuotaStore{collections: 2},
+			quotas:        Quotas{MaxCollections: 2},
+			expectedQuota: QuotaMaxCollections,
+		},
+		{
+			name:          "Max chunks per collection",
+			quotas:        Quotas{MaxChunksPerCollection: 2},
+			expectedQuota: QuotaMaxChunksPerCollection,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			svc := NewService("test-api-key", &client, &client, &mockRepository{}, WithQuotas(&store, UniformQuotas(tt.quotas)))
+
+			_, err := svc.Train(context.Background(), TrainInput{
+				UserID: "test-user",
+				Model:  defaultModel,
+				Data: []io.Reader{
+					strings.NewReader(strings.Repeat("word ", defaultChunkSize*3)),
+				},
+			})
+
+			var quotaErr *ErrQuotaExceeded
+			require.True(t, errors.As(err, &quotaErr))
+
+			assert.Equal(t, tt.expectedQuota, quotaErr.Quota)
+			assert.Equal(t, tt.expectedReset, quotaErr.ResetAt.After(time.Now()))
+		})
+	}
+}
+
+func TestAskQuota(t *testing.T) {
+	store := mockQuotaStore{tokens: 10}
+
+	svc := NewService("test-api-key", &mockClient{}, &mockClient{}, &mockRepository{},
+		WithQuotas(&store, func(userID string) Quotas {
+			if userID == "limited-user" {
+				return Quotas{TokensPerDay: 10}
+			}
+			return Quotas{}
+		}),
+	)
+
+	_, err := svc.Ask(context.Background(), AskInput{UserID: "limited-user", Question: "question"})
+
+	var quotaErr *ErrQuotaExceeded
+	require.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, int64(10), quotaErr.Used)
+	assert.Zero(t, quotaErr.Remaining)
+}
+
+func TestQuotasWithoutUsageLedger(t *testing.T) {
+	store := mockQuotaStore{}
+	quotas := UniformQuotas(Quotas{TokensPerDay: 10})
+
+	var withoutLedger recordingLogger
+	NewService("test-api-key", &mockClient{}, &mockClient{}, &mockRepository{},
+		WithLogger(&withoutLedger),
+		WithQuotas(&store, quotas),
+	)
+	assert.Len(t, withoutLedger.find("token quotas are not enforced without a usage ledger"), 1)
+
+	var withLedger recordingLogger
+	NewService("test-api-key", &mockClient{}, &mockClient{}, &mockRepository{},
+		WithLogger(&withLedger),
+		WithQuotas(&store, quotas),
+		WithUsageLedger(&memoryUsageLedger{}, nil),
+	)
+	assert.Empty(t, withLedger.find("token quotas are not enforced without a usage ledger"))
+}
@@ -0,0 +1,243 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/errgroup"
+)
+
+// reindexConcurrency bounds the embeddings created at once while reindexing.
+const reindexConcurrency int = 8
+
+// ErrCollectionChanged is returned when chunks are added to or removed
+// from a collection while it is reindexed. The collection is left as it was.
+var ErrCollectionChanged = errors.New("collection changed during reindex")
+
+type (
+	// ReindexInput represents the input for reindexing a collection.
+	ReindexInput struct {
+		UserID       string
+		CollectionID string
+
+		// Model is the new embedding model. Defaults to the model of
+		// a ModelEmbedder, or text-embedding-ada-002.
+		Model OpenAIModel
+
+		// Dimensions is the number of dimensions requested from the model, 0 for its default.
+		Dimensions int
+
+		// OnProgress is called after every chunk is embedded, never concurrently.
+		OnProgress func(ReindexProgress)
+	}
+
+	// ReindexProgress reports how many chunks of a collection have been reindexed.
+	ReindexProgress struct {
+		Done   int64
+		Total  int64
+		Tokens int64
+		Cost   float64
+	}
+
+	// ReindexResult represents a reindexed collection.
+	ReindexResult struct {
+		CollectionID string
+		Model        string
+		Dimensions   int
+		Chunks       int64
+		Tokens       int64
+
+		// Cost is the estimated cost of the embeddings created, from the prices set
+		// by WithUsageLedger or WithPrices. Embeddings served by the cache cost nothing.
+		Cost float64
+	}
+)
+
+// ReindexCollection re-embeds the chunks of the collection with another model
+// into a shadow collection, which is neither listed nor counted against quotas,
+// then atomically replaces the chunks of the collection with it, so questions are answered from the old embeddings until the new ones
+// are complete. Metadata, languages and creation times are kept.
+// It returns storage.ErrNotFound if the collection does not exist, and
+// ErrCollectionChanged if it is trained or deleted meanwhile. On failure the
+// shadow collection is deleted. Once it returns, questions are embedded
+// with the new model and dimensions.
+func (s *Service) ReindexCollection(ctx context.Context, in ReindexInput) (_ *ReindexResult, err error) {
+	if in.Model == "" {
+		in.Model = s.embeddingModel
+	}
+
+	ctx, span := s.startSpan(ctx, "chatbot.ReindexCollection",
+		attribute.String("chatbot.user_id", in.UserID),
+		attribute.String("chatbot.collection_id", in.CollectionID),
+		attribute.String("chatbot.model", string(in.Model)),
+	)
+
+	start := time.Now()
+	defer func() {
+		s.metrics.ObserveServiceCall("reindex", time.Since(start), err)
+		endSpan(span, err)
+	}()
+
+	collection, err := s.Collection(ctx, in.UserID, in.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkTokenQuotas(ctx, in.UserID); err != nil {
+		s.logger.WarnContext(ctx, "reindex rejected", "user_id", in.UserID, "error", err)
+		return nil, err
+	}
+
+	shadowID := in.CollectionID + "-reindex-" + uuid.NewString()
+
+	var (
+		mu       sync.Mutex
+		progress = ReindexProgress{Total: collection.Chunks}
+		billed   int64
+	)
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if delErr := s.repo.DeleteCollection(context.Background(), storage.DeleteCollectionInput{
+			UserID:       in.UserID,
+			CollectionID: shadowID,
+		}); delErr != nil && !errors.Is(delErr, storage.ErrNotFound) {
+			s.logger.ErrorContext(ctx, "could not delete reindexed collection",
+				"user_id", in.UserID,
+				"collection_id", shadowID,
+				"error", delErr,
+			)
+		}
+	}()
+
+	var afterID string
+	for {
+		page, err := s.repo.FetchEmbeddings(ctx, storage.FetchEmbeddingsInput{
+			UserID:       in.UserID,
+			CollectionID: in.CollectionID,
+			AfterID:      afterID,
+			Limit:        exportPageSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch embeddings: %w", err)
+		}
+
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(reindexConcurrency)
+
+		for _, e := range page {
+			e := e
+
+			g.Go(func() error {
+				embedd, err := s.reindexChunk(gctx, in, shadowID, e)
+				if err != nil {
+					return fmt.Errorf("could not reindex chunk %s: %w", e.ID, err)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				progress.Done++
+				progress.Tokens += embedd.Tokens
+				if !embedd.Cached {
+					billed += embedd.Tokens
+				}
+				progress.Cost = s.prices.Cost(string(in.Model), billed, 0)
+
+				if in.OnProgress != nil {
+					in.OnProgress(progress)
+				}
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+
+		if len(page) < exportPageSize {
+			break
+		}
+		afterID = page[len(page)-1].ID
+	}
+
+	if progress.Done != collection.Chunks {
+		return nil, ErrCollectionChanged
+	}
+
+	// The repository abandons the swap if chunks were trained or deleted
+	// meanwhile, since they would be lost by it.
+	err = s.repo.ReplaceCollection(ctx, storage.ReplaceCollectionInput{
+		UserID:             in.UserID,
+		CollectionID:       in.CollectionID,
+		SourceCollectionID: shadowID,
+		Chunks:             collection.Chunks,
+		UpdatedAt:          collection.UpdatedAt,
+	})
+	if errors.Is(err, storage.ErrConflict) {
+		return nil, ErrCollectionChanged
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not replace collection: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "collection reindexed",
+		"user_id", in.UserID,
+		"collection_id", in.CollectionID,
+		"previous_model", collection.Model,
+		"model", string(in.Model),
+		"dimensions", in.Dimensions,
+		"chunks", progress.Done,
+		"tokens", progress.Tokens,
+		"duration", time.Since(start),
+	)
+
+	return &ReindexResult{
+		CollectionID: in.CollectionID,
+		Model:        string(in.Model),
+		Dimensions:   in.Dimensions,
+		Chunks:       progress.Done,
+		Tokens:       progress.Tokens,
+		Cost:         progress.Cost,
+	}, nil
+}
+
+// reindexChunk embeds the text of the chunk with the model of the input
+// and stores it under the shadow collection.
+func (s *Service) reindexChunk(ctx context.Context, in ReindexInput, shadowID string, e storage.Embedding) (*embedding, error) {
+	embedd, err := s.embed(ctx, usageScope{
+		userID:       in.UserID,
+		collectionID: in.CollectionID,
+		operation:    OperationReindexEmbedding,
+	}, string(in.Model), in.Dimensions, e.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.StoreEmbeddings(ctx, storage.StoreEmbeddingInput{
+		ID:           "emb-" + uuid.NewString(),
+		UserID:       in.UserID,
+		CollectionID: shadowID,
+		Model:        string(in.Model),
+		Dimensions:   in.Dimensions,
+		Text:         e.Text,
+		Tokens:       embedd.Tokens,
+		Vector:       embedd.Vector,
+		Language:     e.Language,
+		Metadata:     e.Metadata,
+		CreatedAt:    e.CreatedAt,
+		Shadow:       true,
+	}); err != nil {
+		return nil, fmt.Errorf("could not store vector: %w", err)
+	}
+	return embedd, nil
+}
@@ -0,0 +1,269 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alesr/chatbot/client/offline"
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func trainReindexCollection(t *testing.T, svc *Service) string {
+	t.Helper()
+
+	var doc strings.Builder
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&doc, "Rule %d: employees get %d vacation days per year. ", i, i+20)
+	}
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID:   "user-1",
+		Data:     []io.Reader{strings.NewReader(doc.String())},
+		Metadata: map[string]any{"source": "handbook"},
+	})
+	require.NoError(t, err)
+	return collectionID
+}
+
+func TestReindexCollection(t *testing.T) {
+	client := offline.New(offline.WithDimensions(8))
+	repo := storage.NewMemory()
+
+	svc := NewService("", client, client, repo,
+		WithTextSearchLanguage("portuguese"),
+		WithPrices(PriceTable{"text-embedding-3-small": {Prompt: 0.02}}),
+	)
+
+	collectionID := trainReindexCollection(t, svc)
+	before := fetchAllEmbeddings(t, repo, "user-1", collectionID)
+	require.Greater(t, len(before), 1)
+
+	var progress []ReindexProgress
+
+	result, err := svc.ReindexCollection(context.Background(), ReindexInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Model:        "text-embedding-3-small",
+		Dimensions:   4,
+		OnProgress: func(p ReindexProgress) {
+			progress = append(progress, p)
+
+			// The shadow collection is not listed while it is built.
+			collections, err := svc.ListCollections(context.Background(), "user-1")
+			require.NoError(t, err)
+			require.Len(t, collections, 1)
+			assert.Equal(t, collectionID, collections[0].ID)
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, collectionID, result.CollectionID)
+	assert.Equal(t, "text-embedding-3-small", result.Model)
+	assert.Equal(t, 4, result.Dimensions)
+	assert.Equal(t, int64(len(before)), result.Chunks)
+	assert.Positive(t, result.Tokens)
+	assert.InDelta(t, float64(result.Tokens)/1000*0.02, result.Cost, 1e-9)
+
+	require.Len(t, progress, len(before))
+	for i, p := range progress {
+		assert.Equal(t, int64(i+1), p.Done)
+		assert.Equal(t, int64(len(before)), p.Total)
+	}
+	assert.Equal(t, result.Tokens, progress[len(progress)-1].Tokens)
+
+	collections, err := svc.ListCollections(context.Background(), "user-1")
+	require.NoError(t, err)
+	require.Len(t, collections, 1)
+	assert.Equal(t, "text-embedding-3-small", collections[0].Model)
+	assert.Equal(t, 4, collections[0].Dimensions)
+
+	after := fetchAllEmbeddings(t, repo, "user-1", collectionID)
+	require.Len(t, after, len(before))
+
+	byText := make(map[string]storage.Embedding)
+	for _, e := range after {
+		byText[e.Text] = e
+	}
+
+	for _, e := range before {
+		got, ok := byText[e.Text]
+		require.True(t, ok, "missing chunk %q", e.Text)
+
+		assert.Len(t, got.Vector, 4)
+		assert.Equal(t, offline.Embed(e.Text, 4), got.Vector)
+		assert.Equal(t, e.Metadata, got.Metadata)
+		assert.Equal(t, "portuguese", got.Language)
+		assert.Equal(t, e.CreatedAt, got.CreatedAt)
+	}
+
+	// Training more data embeds it like the reindexed chunks.
+	_, err = svc.Train(context.Background(), TrainInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Data:         []io.Reader{strings.NewReader("Remote work is allowed on Fridays.")},
+	})
+	require.NoError(t, err)
+
+	collection, err := svc.Collection(context.Background(), "user-1", collectionID)
+	require.NoError(t, err)
+	assert.Equal(t, "text-embedding-3-small", collection.Model)
+	assert.Equal(t, 4, collection.Dimensions)
+
+	for _, e := range fetchAllEmbeddings(t, repo, "user-1", collectionID) {
+		assert.Len(t, e.Vector, 4)
+	}
+}
+
+func TestReindexCollectionFailures(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		client := offline.New()
+		svc := NewService("", client, client, storage.NewMemory())
+
+		_, err := svc.ReindexCollection(context.Background(), ReindexInput{UserID: "user-1", CollectionID: "coll-1"})
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("embedding error", func(t *testing.T) {
+		client := offline.New(offline.WithDimensions(8))
+		repo := storage.NewMemory()
+
+		var calls atomic.Int64
+		embedder := EmbedderFunc(func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			if in.Model == "text-embedding-3-small" && calls.Add(1) > 1 {
+				return nil, errors.New("rate limited")
+			}
+			return client.CreateEmbedding(ctx, in)
+		})
+
+		svc := NewService("", embedder, client, repo)
+		collectionID := trainReindexCollection(t, svc)
+
+		_, err := svc.ReindexCollection(context.Background(), ReindexInput{
+			UserID:       "user-1",
+			CollectionID: collectionID,
+			Model:        "text-embedding-3-small",
+		})
+		assert.ErrorContains(t, err, "rate limited")
+
+		// The collection is left as it was and the shadow collection is deleted.
+		collections, err := svc.ListCollections(context.Background(), "user-1")
+		require.NoError(t, err)
+		require.Len(t, collections, 1)
+		assert.Equal(t, collectionID, collections[0].ID)
+		assert.Equal(t, string(defaultModel), collections[0].Model)
+	})
+
+	t.Run("collection changed", func(t *testing.T) {
+		client := offline.New(offline.WithDimensions(8))
+		repo := storage.NewMemory()
+
+		svc := NewService("", client, client, repo)
+		collectionID := trainReindexCollection(t, svc)
+
+		before := fetchAllEmbeddings(t, repo, "user-1", collectionID)
+
+		var trained bool
+		_, err := svc.ReindexCollection(context.Background(), ReindexInput{
+			UserID:       "user-1",
+			CollectionID: collectionID,
+			Model:        "text-embedding-3-small",
+			OnProgress: func(ReindexProgress) {
+				if trained {
+					return
+				}
+				trained = true
+
+				require.NoError(t, repo.StoreEmbeddings(context.Background(), storage.StoreEmbeddingInput{
+					ID:           "emb-new",
+					UserID:       "user-1",
+					CollectionID: collectionID,
+					Model:        string(defaultModel),
+					Text:         "Remote work is allowed on Fridays.",
+					Vector:       offline.Embed("Remote work is allowed on Fridays.", 8),
+				}))
+			},
+		})
+		assert.ErrorIs(t, err, ErrCollectionChanged)
+
+		collections, err := svc.ListCollections(context.Background(), "user-1")
+		require.NoError(t, err)
+		require.Len(t, collections, 1)
+		assert.Equal(t, string(defaultModel), collections[0].Model)
+		assert.Equal(t, int64(len(before)+1), collections[0].Chunks)
+	})
+}
+
+func TestAskAfterReindex(t *testing.T) {
+	client := offline.New(offline.WithDimensions(8))
+	repo := storage.NewMemory()
+
+	// Training and reindexing embed the chunks concurrently.
+	var (
+		mu   sync.Mutex
+		last openaicli.EmbbedingRequest
+	)
+
+	embedder := EmbedderFunc(func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+		mu.Lock()
+		last = in
+		mu.Unlock()
+		return client.CreateEmbedding(ctx, in)
+	})
+
+	svc := NewService("", embedder, client, repo)
+	collectionID := trainReindexCollection(t, svc)
+
+	_, err := svc.ReindexCollection(context.Background(), ReindexInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Model:        "text-embedding-3-small",
+		Dimensions:   4,
+	})
+	require.NoError(t, err)
+
+	// Questions are embedded like the reindexed chunks without naming the model.
+	result, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Question:     "How many vacation days?",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Answer)
+	assert.Equal(t, "text-embedding-3-small", last.Model)
+	assert.Equal(t, 4, last.Dimensions)
+
+	chunks, err := svc.Retrieve(context.Background(), AskInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Question:     "How many vacation days?",
+		Model:        "text-embedding-3-small",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, chunks)
+	assert.Equal(t, 4, last.Dimensions)
+
+	_, err = svc.Ask(context.Background(), AskInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Question:     "How many vacation days?",
+		Model:        defaultModel,
+	})
+	assert.ErrorIs(t, err, ErrModelMismatch)
+
+	_, err = svc.Ask(context.Background(), AskInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Question:     "How many vacation days?",
+		Dimensions:   8,
+	})
+	assert.ErrorIs(t, err, ErrModelMismatch)
+}
@@ -9,14 +9,44 @@ import (
 var _ Repository = &mockRepository{}
 
 type mockRepository struct {
-	StoreEmbeddingsFunc      func(ctx context.Context, in storage.StoreEmbeddingInput) error
-	FetchNearestNeighborFunc func(ctx context.Context, in storage.FetchNearestNeighborInput) (text string, distance float64, errr error)
+	StoreEmbeddingsFunc       func(ctx context.Context, in storage.StoreEmbeddingInput) error
+	FetchNearestNeighborsFunc func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error)
+	FetchKeywordMatchesFunc   func(ctx context.Context, in storage.FetchKeywordMatchesInput) ([]storage.Chunk, error)
+	ListCollectionsFunc       func(ctx context.Context, in storage.ListCollectionsInput) ([]storage.Collection, error)
+	FetchCollectionFunc       func(ctx context.Context, in storage.FetchCollectionInput) (*storage.Collection, error)
+	DeleteCollectionFunc      func(ctx context.Context, in storage.DeleteCollectionInput) error
+	FetchEmbeddingsFunc       func(ctx context.Context, in storage.FetchEmbeddingsInput) ([]storage.Embedding, error)
+	ReplaceCollectionFunc     func(ctx context.Context, in storage.ReplaceCollectionInput) error
 }
 
 func (m *mockRepository) StoreEmbeddings(ctx context.Context, in storage.StoreEmbeddingInput) error {
 	return m.StoreEmbeddingsFunc(ctx, in)
 }
 
-func (m *mockRepository) FetchNearestNeighbor(ctx context.Context, in storage.FetchNearestNeighborInput) (text string, distance float64, errr error) {
-	return m.FetchNearestNeighborFunc(ctx, in)
+func (m *mockRepository) FetchNearestNeighbors(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+	return m.FetchNearestNeighborsFunc(ctx, in)
+}
+
+func (m *mockRepository) FetchKeywordMatches(ctx context.Context, in storage.FetchKeywordMatchesInput) ([]storage.Chunk, error) {
+	return m.FetchKeywordMatchesFunc(ctx, in)
+}
+
+func (m *mockRepository) ListCollections(ctx context.Context, in storage.ListCollectionsInput) ([]storage.Collection, error) {
+	return m.ListCollectionsFunc(ctx, in)
+}
+
+func (m *mockRepository) FetchCollection(ctx context.Context, in storage.FetchCollectionInput) (*storage.Collection, error) {
+	return m.FetchCollectionFunc(ctx, in)
+}
+
+func (m *mockRepository) DeleteCollection(ctx context.Context, in storage.DeleteCollectionInput) error {
+	return m.DeleteCollectionFunc(ctx, in)
+}
+
+func (m *mockRepository) FetchEmbeddings(ctx context.Context, in storage.FetchEmbeddingsInput) ([]storage.Embedding, error) {
+	return m.FetchEmbeddingsFunc(ctx, in)
+}
+
+func (m *mockRepository) ReplaceCollection(ctx context.Context, in storage.ReplaceCollectionInput) error {
+	return m.ReplaceCollectionFunc(ctx, in)
 }
@@ -0,0 +1,218 @@
+package chatbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+)
+
+const defaultMMRLambda float64 = 0.5
+
+type (
+	// Reranker reorders the chunks retrieved for a question
+	// before they are used to build the prompt.
+	Reranker interface {
+		Rerank(ctx context.Context, in RerankInput) ([]storage.Chunk, error)
+	}
+
+	// RerankInput represents the input for reranking.
+	RerankInput struct {
+		Question string
+		Vector   []float32
+		Chunks   []storage.Chunk
+
+		// RecordUsage, if set, records the tokens of the model calls made to rerank.
+		// The service sets it to record them in its usage ledger.
+		RecordUsage func(ctx context.Context, model string, promptTokens, completionTokens int64) error
+	}
+)
+
+var (
+	_ Reranker = &MMRReranker{}
+	_ Reranker = &LLMReranker{}
+)
+
+// MMRReranker reorders chunks by maximal marginal relevance,
+// trading relevance to the question for diversity among the selected chunks
+// so that near-duplicates do not crowd out the prompt.
+type MMRReranker struct {
+	lambda float64
+}
+
+// NewMMRReranker returns a new maximal marginal relevance reranker.
+// Lambda ranges from 0 (maximum diversity) to 1 (pure relevance);
+// values outside that range fall back to 0.5.
+func NewMMRReranker(lambda float64) *MMRReranker {
+	if lambda < 0 || lambda > 1 {
+		lambda = defaultMMRLambda
+	}
+	return &MMRReranker{lambda: lambda}
+}
+
+// Rerank greedily selects the chunk maximizing
+// lambda * sim(question, chunk) - (1 - lambda) * max sim(chunk, selected)
+// until all chunks are ordered. Similarity is the cosine of the stored vectors.
+func (r *MMRReranker) Rerank(_ context.Context, in RerankInput) ([]storage.Chunk, error) {
+	remaining := make([]storage.Chunk, len(in.Chunks))
+	copy(remaining, in.Chunks)
+
+	relevance := make([]float64, len(remaining))
+	for i, c := range remaining {
+		relevance[i] = cosineSimilarity(in.Vector, c.Vector)
+	}
+
+	selected := make([]storage.Chunk, 0, len(remaining))
+
+	for len(remaining) > 0 {
+		best, bestScore := 0, math.Inf(-1)
+
+		for i, c := range remaining {
+			var redundancy float64
+			for j, sel := range selected {
+				if sim := cosineSimilarity(c.Vector, sel.Vector); j == 0 || sim > redundancy {
+					redundancy = sim
+				}
+			}
+
+			if score := r.lambda*relevance[i] - (1-r.lambda)*redundancy; score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		selected = append(selected, remaining[best])
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		relevance = append(relevance[:best], relevance[best+1:]...)
+	}
+	return selected, nil
+}
+
+// cosineSimilarity returns the cosine similarity of a and b,
+// or 0 if they differ in length or either is a zero vector.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+const llmRerankPrompt string = `You score passages by how well they help answer a question.
+Reply only with a JSON array of numbers from 0 (irrelevant) to 10 (answers the question),
+one score per passage, in the order the passages are given.`
+
+// LLMReranker reorders chunks by relevance scores
+// assigned by a chat completion model.
+type LLMReranker struct {
+	chat  ChatModel
+	model string
+}
+
+// NewLLMReranker returns a new reranker that scores candidates
+// with the given chat model and model name.
+func NewLLMReranker(chat ChatModel, model string) *LLMReranker {
+	return &LLMReranker{
+		chat:  chat,
+		model: model,
+	}
+}
+
+// Rerank asks the model to score every chunk and returns them
+// ordered by descending score. Ties keep the retrieval order.
+// The tokens of the completion are passed to in.RecordUsage.
+func (r *LLMReranker) Rerank(ctx context.Context, in RerankInput) ([]storage.Chunk, error) {
+	var sb strings.Builder
+	sb.WriteString("Question: ")
+	sb.WriteString(in.Question)
+
+	for i, c := range in.Chunks {
+		fmt.Fprintf(&sb, "\n\n[%d] %s", i+1, c.Text)
+	}
+
+	completition, err := r.chat.CreateChatCompletition(ctx, openaicli.CompletitionRequest{
+		Model: r.model,
+		Messages: []openaicli.Message{
+			{
+				Role:    "system",
+				Content: llmRerankPrompt,
+			},
+			{
+				Role:    "user",
+				Content: sb.String(),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create completition: %w", err)
+	}
+
+	if in.RecordUsage != nil {
+		model := completition.Model
+		if model == "" {
+			model = r.model
+		}
+
+		if err := in.RecordUsage(ctx, model,
+			int64(completition.Usage.PromptTokens), int64(completition.Usage.CompletionTokens),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(completition.Choices) == 0 {
+		return nil, fmt.Errorf("could not score chunks: empty completition")
+	}
+
+	scores, err := parseScores(completition.Choices[0].Message.Content, len(in.Chunks))
+	if err != nil {
+		return nil, fmt.Errorf("could not score chunks: %w", err)
+	}
+
+	order := make([]int, len(in.Chunks))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	reranked := make([]storage.Chunk, 0, len(order))
+	for _, i := range order {
+		reranked = append(reranked, in.Chunks[i])
+	}
+	return reranked, nil
+}
+
+// parseScores extracts the JSON array of scores from the model reply.
+func parseScores(reply string, n int) ([]float64, error) {
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("no scores found in reply %q", reply)
+	}
+
+	var scores []float64
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &scores); err != nil {
+		return nil, fmt.Errorf("could not unmarshal scores: %w", err)
+	}
+
+	if len(scores) != n {
+		return nil, fmt.Errorf("expected %d scores, got %d", n, len(scores))
+	}
+	return scores, nil
+}
@@ -0,0 +1,210 @@
+package chatbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/client/openaicli/openaitest"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMMRReranker(t *testing.T) {
+	chunks := []storage.Chunk{
+		{ID: "a", Vector: []float32{1, 0, 0}},
+		{ID: "a-duplicate", Vector: []float32{0.99, 0.01, 0}},
+		{ID: "b", Vector: []float32{0.7, 0.7, 0}},
+	}
+
+	tests := []struct {
+		name     string
+		lambda   float64
+		expected []string
+	}{
+		{
+			name:     "Pure relevance keeps similarity order",
+			lambda:   1,
+			expected: []string{"a", "a-duplicate", "b"},
+		},
+		{
+			name:     "Diversity pushes duplicate down",
+			lambda:   0.3,
+			expected: []string{"a", "b", "a-duplicate"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reranked, err := NewMMRReranker(tt.lambda).Rerank(context.Background(), RerankInput{
+				Vector: []float32{1, 0, 0},
+				Chunks: chunks,
+			})
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(reranked))
+			for _, c := range reranked {
+				ids = append(ids, c.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+}
+
+func TestLLMReranker(t *testing.T) {
+	client := mockClient{
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			assert.Contains(t, in.Messages[1].Content, "[2] second")
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "Scores: [2, 9, 2]"}}},
+			}, nil
+		},
+	}
+
+	reranked, err := NewLLMReranker(&client, "gpt-3.5-turbo").Rerank(context.Background(), RerankInput{
+		Question: "question",
+		Chunks: []storage.Chunk{
+			{ID: "1", Text: "first"},
+			{ID: "2", Text: "second"},
+			{ID: "3", Text: "third"},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, reranked, 3)
+	assert.Equal(t, "2", reranked[0].ID)
+	assert.Equal(t, "1", reranked[1].ID)
+	assert.Equal(t, "3", reranked[2].ID)
+}
+
+func TestLLMRerankerModel(t *testing.T) {
+	srv := openaitest.NewServer()
+	defer srv.Close()
+
+	srv.Reply("Scores: [2, 9]")
+
+	reranked, err := NewLLMReranker(srv.Client(), "gpt-4o-mini").Rerank(context.Background(), RerankInput{
+		Question: "question",
+		Chunks:   []storage.Chunk{{ID: "1", Text: "first"}, {ID: "2", Text: "second"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, reranked, 2)
+	assert.Equal(t, "2", reranked[0].ID)
+
+	// The OpenAI client sends the model of the reranker, not its default one.
+	requests := srv.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "gpt-4o-mini", requests[0].Completion.Model)
+}
+
+func TestParseScores(t *testing.T) {
+	_, err := parseScores("no scores", 1)
+	assert.Error(t, err)
+
+	_, err = parseScores("[1, 2]", 3)
+	assert.Error(t, err)
+
+	scores, err := parseScores("```json\n[1.5, 0]\n```", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1.5, 0}, scores)
+}
+
+func TestAskWithReranker(t *testing.T) {
+	var systemPrompt string
+
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1, 0, 0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			systemPrompt = in.Messages[0].Content
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "42"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			assert.Equal(t, defaultRerankCandidates, in.Limit)
+			return []storage.Chunk{
+				{ID: "a", Text: "a", Vector: []float32{1, 0, 0}},
+				{ID: "a-duplicate", Text: "a-duplicate", Vector: []float32{0.99, 0.01, 0}},
+				{ID: "b", Text: "b", Vector: []float32{0.7, 0.7, 0}},
+			}, nil
+		},
+	}
+
+	svc := NewService("test-api-key", &client, &client, &repo,
+		WithTopK(2),
+		WithReranker(NewMMRReranker(0.3)),
+	)
+
+	_, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: "coll-1",
+		Question:     "question",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "a\n\nb", systemPrompt)
+}
+
+func TestAskWithLLMRerankerRecordsUsage(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1, 0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			return &openaicli.CompletitionResponse{
+				Usage:   openaicli.Usage{PromptTokens: 20, CompletionTokens: 4, TotalTokens: 24},
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "[1, 9]"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "a", Text: "a"}, {ID: "b", Text: "b"}}, nil
+		},
+	}
+
+	ledger := &memoryUsageLedger{}
+	svc := NewService("test-api-key", &client, &client, &repo,
+		WithReranker(NewLLMReranker(&client, "gpt-4o-mini")),
+		WithUsageLedger(ledger, nil),
+	)
+
+	_, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: "coll-1",
+		Question:     "question",
+	})
+	require.NoError(t, err)
+
+	var operations []string
+	for _, r := range ledger.records {
+		operations = append(operations, r.Operation)
+
+		if r.Operation == string(OperationRerank) {
+			assert.Equal(t, "test-user", r.UserID)
+			assert.Equal(t, "coll-1", r.CollectionID)
+			assert.Equal(t, "gpt-4o-mini", r.Model)
+			assert.Equal(t, int64(20), r.PromptTokens)
+			assert.Equal(t, int64(4), r.CompletionTokens)
+		}
+	}
+
+	assert.Equal(t, []string{
+		string(OperationQuestionEmbedding),
+		string(OperationRerank),
+		string(OperationCompletion),
+	}, operations)
+}
@@ -0,0 +1,116 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alesr/chatbot/storage"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultRerankCandidates int = 20
+
+// Retrieve returns the chunks Ask would use as context for the question,
+// without creating a completition.
+func (s *Service) Retrieve(ctx context.Context, in AskInput) ([]storage.Chunk, error) {
+	in, err := s.resolveEmbedding(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	embedd, err := s.embed(ctx, in.usageScope(OperationQuestionEmbedding), in.embeddingModel(), in.Dimensions, in.Question)
+	if err != nil {
+		return nil, err
+	}
+	return s.retrieve(ctx, in, embedd.Vector)
+}
+
+// retrieve returns the chunks used as context for answering the question.
+// Candidates come from a vector search, or from the fused vector and keyword
+// rankings when hybrid search is enabled. They are reranked if a reranker
+// is configured, and the first topK are returned.
+func (s *Service) retrieve(ctx context.Context, in AskInput, vector []float32) (chunks []storage.Chunk, err error) {
+	ctx, span := s.startSpan(ctx, "chatbot.retrieve")
+	defer func() {
+		span.SetAttributes(attribute.Int("chatbot.chunks", len(chunks)))
+		endSpan(span, err)
+	}()
+
+	if s.hybrid != nil {
+		chunks, err = s.hybridSearch(ctx, in, vector)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		chunks, err = s.repo.FetchNearestNeighbors(ctx, storage.FetchNearestNeighborsInput{
+			UserID:       in.UserID,
+			CollectionID: in.CollectionID,
+			Vector:       vector,
+			Filters:      in.Filters,
+			Limit:        s.candidates(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch nearest neighbors: %w", err)
+		}
+
+		if len(chunks) > 0 {
+			s.metrics.ObserveNearestDistance(chunks[0].Distance)
+		}
+
+		chunks = withinDistance(chunks, in.MaxDistance)
+	}
+
+	if s.reranker != nil && len(chunks) > 1 {
+		chunks, err = s.reranker.Rerank(ctx, RerankInput{
+			Question: in.Question,
+			Vector:   vector,
+			Chunks:   chunks,
+			RecordUsage: func(ctx context.Context, model string, promptTokens, completionTokens int64) error {
+				return s.recordUsage(ctx, in.usageScope(OperationRerank), model, promptTokens, completionTokens)
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not rerank chunks: %w", err)
+		}
+	}
+
+	if len(chunks) > s.topK {
+		chunks = chunks[:s.topK]
+	}
+	return chunks, nil
+}
+
+// candidates returns how many chunks are fetched from a single ranking.
+// Reranking needs a larger pool than the chunks kept in the prompt.
+func (s *Service) candidates() int {
+	if s.reranker != nil && s.topK < defaultRerankCandidates {
+		return defaultRerankCandidates
+	}
+	return s.topK
+}
+
+// withinDistance returns the chunks not farther than maxDistance,
+// or all of them if maxDistance is not positive.
+func withinDistance(chunks []storage.Chunk, maxDistance float64) []storage.Chunk {
+	if maxDistance <= 0 {
+		return chunks
+	}
+
+	within := make([]storage.Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if c.Distance <= maxDistance {
+			within = append(within, c)
+		}
+	}
+	return within
+}
+
+// contextText joins the chunk texts into the system prompt.
+func contextText(chunks []storage.Chunk) string {
+	texts := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		texts = append(texts, c.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
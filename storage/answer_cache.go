@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+type FetchCollectionVersionInput struct {
+	UserID       string
+	CollectionID string
+}
+
+const queryFetchCollectionVersion string = `SELECT COUNT(*), COALESCE(MAX(created_at), 'epoch'::timestamp),
+COALESCE(MIN(model), ''), COALESCE(MIN(dimensions), 0)
+FROM embeddings
+WHERE user_id = $1 AND collection_id = $2`
+
+// FetchCollectionVersion returns a fingerprint of the collection contents
+// that changes whenever chunks are added to or removed from it,
+// or the collection is reindexed with another model.
+func (p *Postgres) FetchCollectionVersion(ctx context.Context, in FetchCollectionVersionInput) (string, error) {
+	var (
+		count      int64
+		latest     time.Time
+		model      string
+		dimensions int
+	)
+
+	if err := p.asTenant(ctx, "FetchCollectionVersion", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.QueryRowxContext(ctx,
+			queryFetchCollectionVersion,
+			in.UserID, in.CollectionID,
+		).Scan(&count, &latest, &model, &dimensions)
+	}); err != nil {
+		return "", fmt.Errorf("could not fetch collection version: %w", err)
+	}
+	return fmt.Sprintf("%d-%d-%s-%d", count, latest.UnixNano(), model, dimensions), nil
+}
+
+// CachedAnswer represents an answer stored in the answer cache.
+type CachedAnswer struct {
+	Question   string
+	Answer     string
+	Similarity float64
+}
+
+type FetchCachedAnswerInput struct {
+	UserID            string
+	CollectionID      string
+	CollectionVersion string
+	Vector            []float32
+}
+
+const queryFetchCachedAnswer string = `SELECT question, answer, 1 - (vector <=> $1) AS similarity
+FROM answer_cache
+WHERE user_id = $2 AND collection_id = $3 AND collection_version = $4
+ORDER BY vector <=> $1 ASC
+LIMIT 1`
+
+// FetchCachedAnswer returns the cached answer whose question is the most similar
+// to the given vector for the same collection version, or ErrNotFound if there is none.
+func (p *Postgres) FetchCachedAnswer(ctx context.Context, in FetchCachedAnswerInput) (*CachedAnswer, error) {
+	var answer CachedAnswer
+
+	if err := p.asTenant(ctx, "FetchCachedAnswer", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.QueryRowxContext(ctx,
+			queryFetchCachedAnswer,
+			pgvector.NewVector(in.Vector), in.UserID, in.CollectionID, in.CollectionVersion,
+		).Scan(&answer.Question, &answer.Answer, &answer.Similarity)
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not fetch cached answer: %w", err)
+	}
+	return &answer, nil
+}
+
+type StoreCachedAnswerInput struct {
+	ID                string
+	UserID            string
+	CollectionID      string
+	CollectionVersion string
+	Question          string
+	Vector            []float32
+	Answer            string
+	CreatedAt         time.Time
+}
+
+const (
+	queryDeleteStaleCachedAnswers string = `DELETE FROM answer_cache
+WHERE user_id = $1 AND collection_id = $2 AND collection_version <> $3`
+
+	queryInsertCachedAnswer string = `INSERT INTO answer_cache
+(id, user_id, collection_id, collection_version, question, vector, answer, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+)
+
+// StoreCachedAnswer stores the answer in the cache and removes
+// answers cached for previous versions of the collection.
+func (p *Postgres) StoreCachedAnswer(ctx context.Context, in StoreCachedAnswerInput) error {
+	return p.asTenant(ctx, "StoreCachedAnswer", in.UserID, func(ctx context.Context, q queryer) error {
+		if _, err := q.ExecContext(ctx,
+			queryDeleteStaleCachedAnswers,
+			in.UserID, in.CollectionID, in.CollectionVersion,
+		); err != nil {
+			return fmt.Errorf("could not delete stale cached answers: %w", err)
+		}
+
+		if _, err := q.ExecContext(
+			ctx, queryInsertCachedAnswer, in.ID, in.UserID, in.CollectionID,
+			in.CollectionVersion, in.Question, pgvector.NewVector(in.Vector),
+			in.Answer, in.CreatedAt,
+		); err != nil {
+			return fmt.Errorf("could not store cached answer: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchCollectionVersion(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	collectionID := uuid.New().String()
+
+	version := func() string {
+		v, err := repo.FetchCollectionVersion(context.TODO(), FetchCollectionVersionInput{UserID: userID, CollectionID: collectionID})
+		require.NoError(t, err)
+		return v
+	}
+
+	empty := version()
+
+	storeChunks(t, repo, userID, collectionID, StoreEmbeddingInput{ID: userID + "/1", Text: "text", Vector: []float32{1, 0}})
+	trained := version()
+	assert.NotEqual(t, empty, trained)
+	assert.Equal(t, trained, version())
+
+	storeChunks(t, repo, userID, collectionID, StoreEmbeddingInput{ID: userID + "/2", Text: "text", Vector: []float32{1, 0}})
+	assert.NotEqual(t, trained, version())
+
+	require.NoError(t, repo.DeleteCollection(context.TODO(), DeleteCollectionInput{UserID: userID, CollectionID: collectionID}))
+	assert.Equal(t, empty, version())
+}
+
+func TestAnswerCache(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	collectionID := uuid.New().String()
+
+	store := func(version, question string, vector []float32) {
+		require.NoError(t, repo.StoreCachedAnswer(context.TODO(), StoreCachedAnswerInput{
+			ID:                uuid.New().String(),
+			UserID:            userID,
+			CollectionID:      collectionID,
+			CollectionVersion: version,
+			Question:          question,
+			Vector:            vector,
+			Answer:            "answer to " + question,
+			CreatedAt:         time.Now().UTC(),
+		}))
+	}
+
+	fetch := func(version string, vector []float32) (*CachedAnswer, error) {
+		return repo.FetchCachedAnswer(context.TODO(), FetchCachedAnswerInput{
+			UserID:            userID,
+			CollectionID:      collectionID,
+			CollectionVersion: version,
+			Vector:            vector,
+		})
+	}
+
+	_, err := fetch("v1", []float32{1, 0})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	store("v1", "vacation", []float32{1, 0})
+	store("v1", "expenses", []float32{0, 1})
+
+	answer, err := fetch("v1", []float32{1, 0.1})
+	require.NoError(t, err)
+	assert.Equal(t, "vacation", answer.Question)
+	assert.Equal(t, "answer to vacation", answer.Answer)
+	assert.InDelta(t, 0.995, answer.Similarity, 0.001)
+
+	// Answers of other versions are never returned, and are deleted
+	// once an answer is cached for a newer version.
+	_, err = fetch("v2", []float32{1, 0})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	store("v2", "remote work", []float32{0, 1})
+
+	var questions []string
+	require.NoError(t, db.Select(&questions, "SELECT question FROM answer_cache WHERE user_id = $1", userID))
+	assert.Equal(t, []string{"remote work"}, questions)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey represents a stored API key. Only the hash of the key is stored.
+type APIKey struct {
+	ID            string         `db:"id"`
+	UserID        string         `db:"user_id"`
+	Name          string         `db:"name"`
+	KeyHash       string         `db:"key_hash"`
+	Scopes        pq.StringArray `db:"scopes"`
+	CollectionIDs pq.StringArray `db:"collection_ids"`
+	CreatedAt     time.Time      `db:"created_at"`
+	RevokedAt     *time.Time     `db:"revoked_at"`
+}
+
+type StoreAPIKeyInput struct {
+	ID            string
+	UserID        string
+	Name          string
+	KeyHash       string
+	Scopes        []string
+	CollectionIDs []string
+	CreatedAt     time.Time
+}
+
+const queryInsertAPIKey string = `INSERT INTO api_keys
+(id, user_id, name, key_hash, scopes, collection_ids, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+// StoreAPIKey stores a new API key.
+func (p *Postgres) StoreAPIKey(ctx context.Context, in StoreAPIKeyInput) error {
+	collectionIDs := in.CollectionIDs
+	if collectionIDs == nil {
+		collectionIDs = []string{}
+	}
+
+	if err := p.run(ctx, "StoreAPIKey", func(ctx context.Context, q queryer) error {
+		_, err := q.ExecContext(ctx, queryInsertAPIKey,
+			in.ID, in.UserID, in.Name, in.KeyHash,
+			pq.StringArray(in.Scopes), pq.StringArray(collectionIDs), in.CreatedAt,
+		)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not store api key: %w", err)
+	}
+	return nil
+}
+
+type FetchAPIKeyInput struct {
+	KeyHash string
+}
+
+const queryFetchAPIKey string = `SELECT id, user_id, name, key_hash, scopes, collection_ids, created_at, revoked_at
+FROM api_keys
+WHERE key_hash = $1 AND revoked_at IS NULL`
+
+// FetchAPIKey returns the unrevoked API key with the given hash, or ErrNotFound if there is none.
+func (p *Postgres) FetchAPIKey(ctx context.Context, in FetchAPIKeyInput) (*APIKey, error) {
+	var key APIKey
+	if err := p.run(ctx, "FetchAPIKey", func(ctx context.Context, q queryer) error {
+		return q.GetContext(ctx, &key, queryFetchAPIKey, in.KeyHash)
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not fetch api key: %w", err)
+	}
+	return &key, nil
+}
+
+type ListAPIKeysInput struct {
+	UserID string
+}
+
+const queryListAPIKeys string = `SELECT id, user_id, name, key_hash, scopes, collection_ids, created_at, revoked_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at, id`
+
+// ListAPIKeys returns the API keys of the user, including revoked ones, oldest first.
+func (p *Postgres) ListAPIKeys(ctx context.Context, in ListAPIKeysInput) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	if err := p.run(ctx, "ListAPIKeys", func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &keys, queryListAPIKeys, in.UserID)
+	}); err != nil {
+		return nil, fmt.Errorf("could not list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+type RevokeAPIKeyInput struct {
+	ID        string
+	RevokedAt time.Time
+}
+
+const queryRevokeAPIKey string = `UPDATE api_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`
+
+// RevokeAPIKey revokes the API key, or returns ErrNotFound if there is no unrevoked key with the ID.
+func (p *Postgres) RevokeAPIKey(ctx context.Context, in RevokeAPIKeyInput) error {
+	if err := p.run(ctx, "RevokeAPIKey", func(ctx context.Context, q queryer) error {
+		res, err := q.ExecContext(ctx, queryRevokeAPIKey, in.ID, in.RevokedAt)
+		if err != nil {
+			return err
+		}
+
+		revoked, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if revoked == 0 {
+			return ErrNotFound
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("could not revoke api key: %w", err)
+	}
+	return nil
+}
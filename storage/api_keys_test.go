@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	createdAt := time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	readKey := StoreAPIKeyInput{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		Name:          "read",
+		KeyHash:       testKeyHash(),
+		Scopes:        []string{"ask"},
+		CollectionIDs: []string{"coll-1", "coll-2"},
+		CreatedAt:     createdAt,
+	}
+	adminKey := StoreAPIKeyInput{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      "admin",
+		KeyHash:   testKeyHash(),
+		Scopes:    []string{"ask", "train", "admin"},
+		CreatedAt: createdAt.Add(time.Hour),
+	}
+
+	require.NoError(t, repo.StoreAPIKey(context.TODO(), readKey))
+	require.NoError(t, repo.StoreAPIKey(context.TODO(), adminKey))
+
+	t.Run("hash is unique", func(t *testing.T) {
+		duplicate := adminKey
+		duplicate.ID = uuid.New().String()
+		assert.Error(t, repo.StoreAPIKey(context.TODO(), duplicate))
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		key, err := repo.FetchAPIKey(context.TODO(), FetchAPIKeyInput{KeyHash: readKey.KeyHash})
+		require.NoError(t, err)
+
+		assert.Equal(t, readKey.ID, key.ID)
+		assert.Equal(t, userID, key.UserID)
+		assert.Equal(t, "read", key.Name)
+		assert.Equal(t, []string{"ask"}, []string(key.Scopes))
+		assert.Equal(t, []string{"coll-1", "coll-2"}, []string(key.CollectionIDs))
+		assert.True(t, createdAt.Equal(key.CreatedAt))
+		assert.Nil(t, key.RevokedAt)
+
+		key, err = repo.FetchAPIKey(context.TODO(), FetchAPIKeyInput{KeyHash: adminKey.KeyHash})
+		require.NoError(t, err)
+		assert.Empty(t, key.CollectionIDs)
+	})
+
+	t.Run("fetch unknown", func(t *testing.T) {
+		_, err := repo.FetchAPIKey(context.TODO(), FetchAPIKeyInput{KeyHash: testKeyHash()})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		revokedAt := createdAt.Add(24 * time.Hour)
+		require.NoError(t, repo.RevokeAPIKey(context.TODO(), RevokeAPIKeyInput{ID: readKey.ID, RevokedAt: revokedAt}))
+
+		_, err := repo.FetchAPIKey(context.TODO(), FetchAPIKeyInput{KeyHash: readKey.KeyHash})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		err = repo.RevokeAPIKey(context.TODO(), RevokeAPIKeyInput{ID: readKey.ID, RevokedAt: revokedAt})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		err = repo.RevokeAPIKey(context.TODO(), RevokeAPIKeyInput{ID: uuid.New().String(), RevokedAt: revokedAt})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		// Revoked keys are still listed.
+		keys, err := repo.ListAPIKeys(context.TODO(), ListAPIKeysInput{UserID: userID})
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+
+		assert.Equal(t, readKey.ID, keys[0].ID)
+		require.NotNil(t, keys[0].RevokedAt)
+		assert.True(t, revokedAt.Equal(*keys[0].RevokedAt))
+
+		assert.Equal(t, adminKey.ID, keys[1].ID)
+		assert.Nil(t, keys[1].RevokedAt)
+	})
+
+	t.Run("list without keys", func(t *testing.T) {
+		keys, err := repo.ListAPIKeys(context.TODO(), ListAPIKeysInput{UserID: setupUser(t, db)})
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+}
+
+func testKeyHash() string {
+	sum := sha256.Sum256([]byte(uuid.New().String()))
+	return hex.EncodeToString(sum[:])
+}
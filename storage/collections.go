@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// Collection summarizes the chunks trained under a collection ID.
+type Collection struct {
+	ID    string `db:"id"`
+	Model string `db:"model"`
+	// Dimensions is the number of dimensions requested from the model, 0 for its default.
+	Dimensions int       `db:"dimensions"`
+	Chunks     int64     `db:"chunks"`
+	Tokens     int64     `db:"tokens"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+type ListCollectionsInput struct {
+	UserID string
+}
+
+const queryListCollections string = `SELECT collection_id AS id, MIN(model) AS model,
+MIN(dimensions) AS dimensions, COUNT(*) AS chunks, COALESCE(SUM(tokens), 0)::BIGINT AS tokens,
+MIN(created_at) AS created_at, MAX(created_at) AS updated_at
+FROM embeddings
+WHERE user_id = $1 AND NOT shadow
+GROUP BY collection_id
+ORDER BY created_at, collection_id`
+
+// ListCollections returns the collections of the user, oldest first,
+// leaving out shadow collections.
+func (p *Postgres) ListCollections(ctx context.Context, in ListCollectionsInput) ([]Collection, error) {
+	collections := make([]Collection, 0)
+	if err := p.asTenant(ctx, "ListCollections", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &collections, queryListCollections, in.UserID)
+	}); err != nil {
+		return nil, fmt.Errorf("could not list collections: %w", err)
+	}
+	return collections, nil
+}
+
+type FetchCollectionInput struct {
+	UserID       string
+	CollectionID string
+}
+
+const queryFetchCollection string = `SELECT collection_id AS id, MIN(model) AS model,
+MIN(dimensions) AS dimensions, COUNT(*) AS chunks, COALESCE(SUM(tokens), 0)::BIGINT AS tokens,
+MIN(created_at) AS created_at, MAX(created_at) AS updated_at
+FROM embeddings
+WHERE user_id = $1 AND collection_id = $2
+GROUP BY collection_id`
+
+// FetchCollection returns the collection of the user, or ErrNotFound if it has no chunks.
+func (p *Postgres) FetchCollection(ctx context.Context, in FetchCollectionInput) (*Collection, error) {
+	var collections []Collection
+	if err := p.asTenant(ctx, "FetchCollection", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &collections, queryFetchCollection, in.UserID, in.CollectionID)
+	}); err != nil {
+		return nil, fmt.Errorf("could not fetch collection: %w", err)
+	}
+
+	if len(collections) == 0 {
+		return nil, fmt.Errorf("could not fetch collection: %w", ErrNotFound)
+	}
+	return &collections[0], nil
+}
+
+type DeleteCollectionInput struct {
+	UserID       string
+	CollectionID string
+}
+
+const (
+	queryDeleteCollection string = `DELETE FROM embeddings WHERE user_id = $1 AND collection_id = $2`
+
+	queryDeleteCollectionAnswers string = `DELETE FROM answer_cache WHERE user_id = $1 AND collection_id = $2`
+)
+
+// DeleteCollection deletes the chunks and the cached answers of the collection,
+// or returns ErrNotFound if it has no chunks.
+func (p *Postgres) DeleteCollection(ctx context.Context, in DeleteCollectionInput) error {
+	if err := p.asTenant(ctx, "DeleteCollection", in.UserID, func(ctx context.Context, q queryer) error {
+		res, err := q.ExecContext(ctx, queryDeleteCollection, in.UserID, in.CollectionID)
+		if err != nil {
+			return err
+		}
+
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if deleted == 0 {
+			return ErrNotFound
+		}
+
+		_, err = q.ExecContext(ctx, queryDeleteCollectionAnswers, in.UserID, in.CollectionID)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not delete collection: %w", err)
+	}
+	return nil
+}
+
+// Embedding is a stored chunk with everything needed to store it again.
+type Embedding struct {
+	ID         string
+	Model      string
+	Dimensions int
+	Text       string
+	Tokens     int64
+	Vector     []float32
+	Language   string
+	Metadata   map[string]any
+	CreatedAt  time.Time
+}
+
+// FetchEmbeddingsInput pages through the chunks of a collection by ID.
+type FetchEmbeddingsInput struct {
+	UserID       string
+	CollectionID string
+
+	// AfterID is the ID of the last chunk of the previous page, empty for the first page.
+	AfterID string
+	Limit   int
+}
+
+const queryFetchEmbeddings string = `SELECT id, model, dimensions, text, tokens, vector,
+language::TEXT AS language, metadata, created_at
+FROM embeddings
+WHERE user_id = $1 AND collection_id = $2 AND id > $3
+ORDER BY id
+LIMIT $4`
+
+type embeddingRow struct {
+	ID         string          `db:"id"`
+	Model      string          `db:"model"`
+	Dimensions int             `db:"dimensions"`
+	Text       string          `db:"text"`
+	Tokens     int64           `db:"tokens"`
+	Vector     pgvector.Vector `db:"vector"`
+	Language   string          `db:"language"`
+	Metadata   []byte          `db:"metadata"`
+	CreatedAt  time.Time       `db:"created_at"`
+}
+
+// FetchEmbeddings returns up to Limit chunks of the collection
+// with an ID greater than AfterID, ordered by ID.
+func (p *Postgres) FetchEmbeddings(ctx context.Context, in FetchEmbeddingsInput) ([]Embedding, error) {
+	var rows []embeddingRow
+	if err := p.asTenant(ctx, "FetchEmbeddings", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &rows, queryFetchEmbeddings, in.UserID, in.CollectionID, in.AfterID, in.Limit)
+	}); err != nil {
+		return nil, fmt.Errorf("could not fetch embeddings: %w", err)
+	}
+
+	embeddings := make([]Embedding, 0, len(rows))
+	for _, r := range rows {
+		var metadata map[string]any
+		if err := json.Unmarshal(r.Metadata, &metadata); err != nil {
+			return nil, fmt.Errorf("could not unmarshal metadata: %w", err)
+		}
+
+		embeddings = append(embeddings, Embedding{
+			ID:         r.ID,
+			Model:      r.Model,
+			Dimensions: r.Dimensions,
+			Text:       r.Text,
+			Tokens:     r.Tokens,
+			Vector:     r.Vector.Slice(),
+			Language:   r.Language,
+			Metadata:   metadata,
+			CreatedAt:  r.CreatedAt,
+		})
+	}
+	return embeddings, nil
+}
+
+type ReplaceCollectionInput struct {
+	UserID       string
+	CollectionID string
+
+	// SourceCollectionID is the collection whose chunks replace those of the collection.
+	SourceCollectionID string
+
+	// Chunks and UpdatedAt are those of the collection when the source collection
+	// was built from it. If chunks were added or removed since, ErrConflict is
+	// returned instead of losing them. Zero values expect no collection.
+	Chunks    int64
+	UpdatedAt time.Time
+}
+
+// unchanged reports whether the collection still has the chunks the input expects.
+func (in ReplaceCollectionInput) unchanged(chunks int64, updatedAt time.Time) bool {
+	return chunks == in.Chunks && (chunks == 0 || updatedAt.Equal(in.UpdatedAt))
+}
+
+const (
+	queryLockCollection string = `SELECT pg_advisory_xact_lock(hashtextextended($1 || '/' || $2, 0))`
+
+	queryFetchCollectionState string = `SELECT COUNT(*), MAX(created_at)
+FROM embeddings
+WHERE user_id = $1 AND collection_id = $2`
+
+	queryMoveCollection string = `UPDATE embeddings SET collection_id = $3, shadow = FALSE
+WHERE user_id = $1 AND collection_id = $2`
+)
+
+// ReplaceCollection atomically replaces the chunks of the collection with
+// those of the source collection, which is left empty, and deletes the cached
+// answers of both. The collection is locked against chunks being stored in it
+// meanwhile. It returns ErrConflict if the collection changed since the input
+// was read, and ErrNotFound if the source collection has no chunks.
+func (p *Postgres) ReplaceCollection(ctx context.Context, in ReplaceCollectionInput) error {
+	if err := p.run(ctx, "ReplaceCollection", func(ctx context.Context, _ queryer) error {
+		return p.inTenantTx(ctx, in.UserID, func(ctx context.Context, q queryer) error {
+			if _, err := q.ExecContext(ctx, queryLockCollection, in.UserID, in.CollectionID); err != nil {
+				return err
+			}
+
+			var (
+				chunks    int64
+				updatedAt sql.NullTime
+			)
+
+			if err := q.QueryRowxContext(ctx,
+				queryFetchCollectionState,
+				in.UserID, in.CollectionID,
+			).Scan(&chunks, &updatedAt); err != nil {
+				return err
+			}
+
+			if !in.unchanged(chunks, updatedAt.Time) {
+				return ErrConflict
+			}
+
+			if _, err := q.ExecContext(ctx, queryDeleteCollection, in.UserID, in.CollectionID); err != nil {
+				return err
+			}
+
+			res, err := q.ExecContext(ctx, queryMoveCollection, in.UserID, in.SourceCollectionID, in.CollectionID)
+			if err != nil {
+				return err
+			}
+
+			moved, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+
+			if moved == 0 {
+				return ErrNotFound
+			}
+
+			for _, id := range []string{in.CollectionID, in.SourceCollectionID} {
+				if _, err := q.ExecContext(ctx, queryDeleteCollectionAnswers, in.UserID, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return fmt.Errorf("could not replace collection: %w", err)
+	}
+	return nil
+}
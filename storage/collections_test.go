@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceCollection(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	collectionID := uuid.New().String()
+	shadowID := uuid.New().String()
+
+	storeChunks(t, repo, userID, collectionID,
+		StoreEmbeddingInput{ID: userID + "/old-1", Model: "old-model", Text: "old", Vector: []float32{1, 0}},
+		StoreEmbeddingInput{ID: userID + "/old-2", Model: "old-model", Text: "old", Vector: []float32{1, 0}},
+	)
+	storeChunks(t, repo, userID, shadowID,
+		StoreEmbeddingInput{ID: userID + "/new-1", Model: "new-model", Text: "new", Vector: []float32{0, 1, 0}, Shadow: true},
+		StoreEmbeddingInput{ID: userID + "/new-2", Model: "new-model", Text: "new", Vector: []float32{0, 1, 0}, Shadow: true},
+	)
+
+	require.NoError(t, repo.StoreCachedAnswer(context.TODO(), StoreCachedAnswerInput{
+		ID:                uuid.New().String(),
+		UserID:            userID,
+		CollectionID:      collectionID,
+		CollectionVersion: "v1",
+		Question:          "question",
+		Vector:            []float32{1, 0},
+		Answer:            "answer",
+		CreatedAt:         time.Now().UTC(),
+	}))
+
+	t.Run("shadow collections are neither listed nor counted", func(t *testing.T) {
+		collections, err := repo.ListCollections(context.TODO(), ListCollectionsInput{UserID: userID})
+		require.NoError(t, err)
+		require.Len(t, collections, 1)
+		assert.Equal(t, collectionID, collections[0].ID)
+
+		count, err := repo.FetchCollectionCount(context.TODO(), FetchCollectionCountInput{UserID: userID})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	collection, err := repo.FetchCollection(context.TODO(), FetchCollectionInput{UserID: userID, CollectionID: collectionID})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), collection.Chunks)
+
+	t.Run("changed collection", func(t *testing.T) {
+		err := repo.ReplaceCollection(context.TODO(), ReplaceCollectionInput{
+			UserID:             userID,
+			CollectionID:       collectionID,
+			SourceCollectionID: shadowID,
+			Chunks:             collection.Chunks - 1,
+			UpdatedAt:          collection.UpdatedAt,
+		})
+		assert.ErrorIs(t, err, ErrConflict)
+
+		err = repo.ReplaceCollection(context.TODO(), ReplaceCollectionInput{
+			UserID:             userID,
+			CollectionID:       collectionID,
+			SourceCollectionID: shadowID,
+			Chunks:             collection.Chunks,
+			UpdatedAt:          collection.UpdatedAt.Add(-time.Second),
+		})
+		assert.ErrorIs(t, err, ErrConflict)
+
+		// Nothing was replaced.
+		model, err := repo.FetchModel(context.TODO(), FetchModelInput{UserID: userID, CollectionID: collectionID})
+		require.NoError(t, err)
+		assert.Equal(t, "old-model", model)
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		require.NoError(t, repo.ReplaceCollection(context.TODO(), ReplaceCollectionInput{
+			UserID:             userID,
+			CollectionID:       collectionID,
+			SourceCollectionID: shadowID,
+			Chunks:             collection.Chunks,
+			UpdatedAt:          collection.UpdatedAt,
+		}))
+
+		embeddings, err := repo.FetchEmbeddings(context.TODO(), FetchEmbeddingsInput{UserID: userID, CollectionID: collectionID, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, embeddings, 2)
+		for _, e := range embeddings {
+			assert.Equal(t, "new-model", e.Model)
+			assert.Equal(t, []float32{0, 1, 0}, e.Vector)
+		}
+
+		// The replaced collection is no longer a shadow.
+		collections, err := repo.ListCollections(context.TODO(), ListCollectionsInput{UserID: userID})
+		require.NoError(t, err)
+		require.Len(t, collections, 1)
+		assert.Equal(t, collectionID, collections[0].ID)
+		assert.Equal(t, "new-model", collections[0].Model)
+
+		_, err = repo.FetchCollection(context.TODO(), FetchCollectionInput{UserID: userID, CollectionID: shadowID})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = repo.FetchCachedAnswer(context.TODO(), FetchCachedAnswerInput{
+			UserID:            userID,
+			CollectionID:      collectionID,
+			CollectionVersion: "v1",
+			Vector:            []float32{1, 0},
+		})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("empty source collection", func(t *testing.T) {
+		collection, err := repo.FetchCollection(context.TODO(), FetchCollectionInput{UserID: userID, CollectionID: collectionID})
+		require.NoError(t, err)
+
+		err = repo.ReplaceCollection(context.TODO(), ReplaceCollectionInput{
+			UserID:             userID,
+			CollectionID:       collectionID,
+			SourceCollectionID: shadowID,
+			Chunks:             collection.Chunks,
+			UpdatedAt:          collection.UpdatedAt,
+		})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		// The collection was kept.
+		_, err = repo.FetchCollection(context.TODO(), FetchCollectionInput{UserID: userID, CollectionID: collectionID})
+		assert.NoError(t, err)
+	})
+}
+
+func TestReplaceCollectionLocksStores(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	collectionID := uuid.New().String()
+
+	conn, err := db.Connx(context.TODO())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Hold the lock ReplaceCollection takes while it replaces the collection.
+	_, err = conn.ExecContext(context.TODO(), "SELECT pg_advisory_lock(hashtextextended($1 || '/' || $2, 0))", userID, collectionID)
+	require.NoError(t, err)
+
+	stored := make(chan error, 1)
+	go func() {
+		stored <- repo.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           userID + "/chunk",
+			UserID:       userID,
+			CollectionID: collectionID,
+			Model:        "test-model",
+			Text:         "text",
+			Vector:       []float32{1, 0},
+			CreatedAt:    time.Now().UTC(),
+		})
+	}()
+
+	select {
+	case err := <-stored:
+		t.Fatalf("chunk stored while the collection is locked: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_, err = conn.ExecContext(context.TODO(), "SELECT pg_advisory_unlock(hashtextextended($1 || '/' || $2, 0))", userID, collectionID)
+	require.NoError(t, err)
+
+	select {
+	case err := <-stored:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("chunk not stored after the collection was unlocked")
+	}
+}
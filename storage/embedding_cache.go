@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// CachedEmbedding represents an embedding stored in the cache.
+// The cache is shared by all users, so it is not scoped to a tenant.
+type CachedEmbedding struct {
+	Vector []float32
+	Tokens int64
+}
+
+type FetchCachedEmbeddingInput struct {
+	Model      string
+	Dimensions int
+	TextHash   string
+}
+
+const queryFetchCachedEmbedding string = `SELECT vector, tokens
+FROM embedding_cache
+WHERE model = $1 AND dimensions = $2 AND text_hash = $3`
+
+// FetchCachedEmbedding returns the cached embedding for the given key,
+// or ErrNotFound if there is none.
+func (p *Postgres) FetchCachedEmbedding(ctx context.Context, in FetchCachedEmbeddingInput) (*CachedEmbedding, error) {
+	var (
+		vector pgvector.Vector
+		tokens int64
+	)
+
+	if err := p.run(ctx, "FetchCachedEmbedding", func(ctx context.Context, q queryer) error {
+		return q.QueryRowxContext(ctx,
+			queryFetchCachedEmbedding,
+			in.Model, in.Dimensions, in.TextHash,
+		).Scan(&vector, &tokens)
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not fetch cached embedding: %w", err)
+	}
+
+	return &CachedEmbedding{
+		Vector: vector.Slice(),
+		Tokens: tokens,
+	}, nil
+}
+
+type StoreCachedEmbeddingInput struct {
+	Model      string
+	Dimensions int
+	TextHash   string
+	Vector     []float32
+	Tokens     int64
+	CreatedAt  time.Time
+}
+
+const queryInsertCachedEmbedding string = `INSERT INTO embedding_cache
+(model, dimensions, text_hash, vector, tokens, created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (model, dimensions, text_hash) DO NOTHING`
+
+// StoreCachedEmbedding stores the embedding in the cache.
+// Storing an already cached key is a no-op.
+func (p *Postgres) StoreCachedEmbedding(ctx context.Context, in StoreCachedEmbeddingInput) error {
+	if err := p.run(ctx, "StoreCachedEmbedding", func(ctx context.Context, q queryer) error {
+		_, err := q.ExecContext(
+			ctx, queryInsertCachedEmbedding, in.Model, in.Dimensions,
+			in.TextHash, pgvector.NewVector(in.Vector), in.Tokens, in.CreatedAt,
+		)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not store cached embedding: %w", err)
+	}
+	return nil
+}
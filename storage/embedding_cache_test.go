@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddingCache(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	textHash := testKeyHash()
+	t.Cleanup(func() {
+		_, err := db.Exec("DELETE FROM embedding_cache WHERE text_hash = $1", textHash)
+		assert.NoError(t, err)
+	})
+
+	_, err := repo.FetchCachedEmbedding(context.TODO(), FetchCachedEmbeddingInput{Model: "test-model", TextHash: textHash})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, repo.StoreCachedEmbedding(context.TODO(), StoreCachedEmbeddingInput{
+		Model:     "test-model",
+		TextHash:  textHash,
+		Vector:    []float32{1, 0},
+		Tokens:    3,
+		CreatedAt: time.Now().UTC(),
+	}))
+
+	// Storing the same key again keeps the first embedding.
+	require.NoError(t, repo.StoreCachedEmbedding(context.TODO(), StoreCachedEmbeddingInput{
+		Model:     "test-model",
+		TextHash:  textHash,
+		Vector:    []float32{0, 1},
+		Tokens:    5,
+		CreatedAt: time.Now().UTC(),
+	}))
+
+	embedding, err := repo.FetchCachedEmbedding(context.TODO(), FetchCachedEmbeddingInput{Model: "test-model", TextHash: textHash})
+	require.NoError(t, err)
+	assert.Equal(t, &CachedEmbedding{Vector: []float32{1, 0}, Tokens: 3}, embedding)
+
+	// Other models and dimensions are cached separately.
+	_, err = repo.FetchCachedEmbedding(context.TODO(), FetchCachedEmbeddingInput{Model: "other-model", TextHash: textHash})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = repo.FetchCachedEmbedding(context.TODO(), FetchCachedEmbeddingInput{Model: "test-model", Dimensions: 256, TextHash: textHash})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,737 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSegmentSize int64 = 64 << 20
+
+	fileIndexName     string = "index.json"
+	fileIndexVersion  int    = 1
+	segmentExt        string = ".seg"
+	recordHeaderSize  int    = 8
+	recordOpStore     string = "store"
+	recordOpDeleteAll string = "delete_collection"
+	recordOpReplace   string = "replace_collection"
+)
+
+var (
+	errCorruptRecord   = errors.New("corrupt record")
+	errTruncatedRecord = fmt.Errorf("%w: truncated", errCorruptRecord)
+	errStoreClosed     = errors.New("file store is closed")
+	errStoreFailed     = errors.New("file store failed")
+)
+
+// FileStore is a Repository persisting the chunks in a directory, for
+// deployments without Postgres. Searches are served by brute force from
+// memory, so the chunks of every collection must fit in memory.
+//
+// Every change is appended to a segment file and synced before it is applied.
+// Segments are never rewritten; once one reaches the segment size a new one
+// is started and the index, which locates the live chunks so that deleted
+// ones are skipped when the store is opened, is replaced atomically.
+// Changes made after the last index are replayed from the segments on open,
+// and a record torn by a crash at the end of the last segment is discarded.
+// Any other corrupt record fails the open rather than losing the records after it.
+//
+// A directory must only be opened by one FileStore at a time.
+type FileStore struct {
+	dir         string
+	segmentSize int64
+	mem         *Memory
+	nowFunc     func() time.Time
+
+	mu        sync.Mutex
+	active    *os.File
+	activeID  int
+	activeLen int64
+	segments  []int
+	locations map[memoryCollectionKey][]recordLocation
+
+	// failed is set when a segment could not be restored after a failed
+	// write, after which every change fails until the store is reopened.
+	failed error
+}
+
+// FileOption configures optional behaviour of FileStore.
+type FileOption func(*FileStore)
+
+// WithSegmentSize sets the size after which a new segment is started. Defaults to 64MB.
+func WithSegmentSize(n int64) FileOption {
+	return func(f *FileStore) {
+		f.segmentSize = n
+	}
+}
+
+// WithFileDistanceMetric sets how vectors are compared. Defaults to DistanceL2.
+func WithFileDistanceMetric(m DistanceMetric) FileOption {
+	return func(f *FileStore) {
+		f.mem.metric = m
+	}
+}
+
+type recordLocation struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+	Length  int64 `json:"length"`
+}
+
+type fileIndex struct {
+	Version     int                   `json:"version"`
+	Segments    []int                 `json:"segments"`
+	Active      int                   `json:"active"`
+	Offset      int64                 `json:"offset"`
+	Collections []fileIndexCollection `json:"collections"`
+}
+
+type fileIndexCollection struct {
+	UserID       string           `json:"user_id"`
+	CollectionID string           `json:"collection_id"`
+	Records      []recordLocation `json:"records"`
+}
+
+// fileRecord is a change appended to a segment.
+type fileRecord struct {
+	Op           string         `json:"op"`
+	ID           string         `json:"id,omitempty"`
+	UserID       string         `json:"user_id"`
+	CollectionID string         `json:"collection_id"`
+	Model        string         `json:"model,omitempty"`
+	Dimensions   int            `json:"dimensions,omitempty"`
+	Text         string         `json:"text,omitempty"`
+	Tokens       int64          `json:"tokens,omitempty"`
+	Vector       []float32      `json:"vector,omitempty"`
+	Language     string         `json:"language,omitempty"`
+	Metadata     map[string]any `json:"metadata,omitempty"`
+	CreatedAt    time.Time      `json:"created_at,omitempty"`
+	Shadow       bool           `json:"shadow,omitempty"`
+
+	// SourceCollectionID is the collection moved by a replace record.
+	SourceCollectionID string `json:"source_collection_id,omitempty"`
+}
+
+// OpenFileStore opens the store in the directory, creating it if needed.
+func OpenFileStore(dir string, opts ...FileOption) (*FileStore, error) {
+	f := &FileStore{
+		dir:         dir,
+		segmentSize: defaultSegmentSize,
+		mem:         NewMemory(),
+		nowFunc:     time.Now,
+		locations:   make(map[memoryCollectionKey][]recordLocation),
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create store directory: %w", err)
+	}
+
+	if err := f.load(); err != nil {
+		return nil, fmt.Errorf("could not open file store: %w", err)
+	}
+	return f, nil
+}
+
+// StoreEmbeddings stores the chunk. Chunks without a creation time are stamped with the current time.
+func (f *FileStore) StoreEmbeddings(ctx context.Context, in StoreEmbeddingInput) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not store vector: %w", err)
+	}
+
+	if in.CreatedAt.IsZero() {
+		in.CreatedAt = f.nowFunc()
+	}
+
+	rec := fileRecord{
+		Op:           recordOpStore,
+		ID:           in.ID,
+		UserID:       in.UserID,
+		CollectionID: in.CollectionID,
+		Model:        in.Model,
+		Dimensions:   in.Dimensions,
+		Text:         in.Text,
+		Tokens:       in.Tokens,
+		Vector:       in.Vector,
+		Language:     in.Language,
+		Metadata:     in.Metadata,
+		CreatedAt:    in.CreatedAt.UTC(),
+		Shadow:       in.Shadow,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	loc, err := f.append(rec)
+	if err != nil {
+		return fmt.Errorf("could not store vector: %w", err)
+	}
+	return f.apply(rec, loc)
+}
+
+// FetchNearestNeighbors returns up to Limit chunks matching the filters,
+// ordered by their distance to the given vector.
+func (f *FileStore) FetchNearestNeighbors(ctx context.Context, in FetchNearestNeighborsInput) ([]Chunk, error) {
+	return f.mem.FetchNearestNeighbors(ctx, in)
+}
+
+// FetchKeywordMatches returns up to Limit chunks containing every word of the
+// query and matching the filters, ordered by the frequency of the query words.
+func (f *FileStore) FetchKeywordMatches(ctx context.Context, in FetchKeywordMatchesInput) ([]Chunk, error) {
+	return f.mem.FetchKeywordMatches(ctx, in)
+}
+
+// ListCollections returns the collections of the user, oldest first.
+func (f *FileStore) ListCollections(ctx context.Context, in ListCollectionsInput) ([]Collection, error) {
+	return f.mem.ListCollections(ctx, in)
+}
+
+// FetchCollection returns the collection of the user, or ErrNotFound if it has no chunks.
+func (f *FileStore) FetchCollection(ctx context.Context, in FetchCollectionInput) (*Collection, error) {
+	return f.mem.FetchCollection(ctx, in)
+}
+
+// FetchEmbeddings returns up to Limit chunks of the collection
+// with an ID greater than AfterID, ordered by ID.
+func (f *FileStore) FetchEmbeddings(ctx context.Context, in FetchEmbeddingsInput) ([]Embedding, error) {
+	return f.mem.FetchEmbeddings(ctx, in)
+}
+
+// DeleteCollection deletes the chunks of the collection, or returns ErrNotFound if it has none.
+// The space they take in the segments is not reclaimed.
+func (f *FileStore) DeleteCollection(ctx context.Context, in DeleteCollectionInput) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not delete collection: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}
+	if len(f.locations[key]) == 0 {
+		return fmt.Errorf("could not delete collection: %w", ErrNotFound)
+	}
+
+	rec := fileRecord{Op: recordOpDeleteAll, UserID: in.UserID, CollectionID: in.CollectionID}
+
+	loc, err := f.append(rec)
+	if err != nil {
+		return fmt.Errorf("could not delete collection: %w", err)
+	}
+	return f.apply(rec, loc)
+}
+
+// ReplaceCollection atomically replaces the chunks of the collection with those
+// of the source collection, which is left empty. It returns ErrConflict if the
+// collection changed since the input was read, and ErrNotFound if the source
+// collection has no chunks. The space the replaced chunks take in the segments
+// is not reclaimed.
+func (f *FileStore) ReplaceCollection(ctx context.Context, in ReplaceCollectionInput) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not replace collection: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var current Collection
+	if c, err := f.mem.FetchCollection(ctx, FetchCollectionInput{UserID: in.UserID, CollectionID: in.CollectionID}); err == nil {
+		current = *c
+	}
+
+	if !in.unchanged(current.Chunks, current.UpdatedAt) {
+		return fmt.Errorf("could not replace collection: %w", ErrConflict)
+	}
+
+	source := memoryCollectionKey{userID: in.UserID, collectionID: in.SourceCollectionID}
+	if len(f.locations[source]) == 0 {
+		return fmt.Errorf("could not replace collection: %w", ErrNotFound)
+	}
+
+	rec := fileRecord{
+		Op:                 recordOpReplace,
+		UserID:             in.UserID,
+		CollectionID:       in.CollectionID,
+		SourceCollectionID: in.SourceCollectionID,
+	}
+
+	loc, err := f.append(rec)
+	if err != nil {
+		return fmt.Errorf("could not replace collection: %w", err)
+	}
+	return f.apply(rec, loc)
+}
+
+// Close writes the index and closes the active segment.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.active == nil {
+		return nil
+	}
+
+	if err := f.writeIndex(); err != nil {
+		_ = f.active.Close()
+		f.active = nil
+		return fmt.Errorf("could not close file store: %w", err)
+	}
+
+	err := f.active.Close()
+	f.active = nil
+	if err != nil {
+		return fmt.Errorf("could not close file store: %w", err)
+	}
+	return nil
+}
+
+// load restores the chunks from the index and the segments written after it,
+// and opens the last segment for appending.
+func (f *FileStore) load() error {
+	existing, err := f.listSegments()
+	if err != nil {
+		return err
+	}
+
+	idx, err := f.readIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range idx.Collections {
+		for _, loc := range c.Records {
+			rec, err := f.readRecord(loc)
+			if err != nil {
+				return fmt.Errorf("could not read indexed record of collection %q: %w", c.CollectionID, err)
+			}
+
+			// The record may have been stored under the shadow collection that
+			// replaced its collection, and is no longer shadowed if so.
+			rec.Shadow = rec.Shadow && rec.CollectionID == c.CollectionID
+			rec.UserID, rec.CollectionID = c.UserID, c.CollectionID
+
+			if err := f.apply(rec, loc); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Replay the segments from where the index left off.
+	for i, id := range existing {
+		if id < idx.Active {
+			continue
+		}
+
+		var offset int64
+		if id == idx.Active {
+			offset = idx.Offset
+		}
+
+		last := i == len(existing)-1
+
+		end, err := f.replay(id, offset, last)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			f.activeID, f.activeLen = id, end
+		}
+	}
+
+	f.segments = existing
+
+	if len(existing) > 0 && f.activeID == 0 {
+		return fmt.Errorf("index refers to missing segment %d", idx.Active)
+	}
+
+	if len(existing) == 0 {
+		f.activeID = 1
+		f.segments = []int{1}
+	}
+
+	active, err := os.OpenFile(f.segmentPath(f.activeID), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open segment: %w", err)
+	}
+
+	// Discard a torn record at the end of the segment.
+	if err := active.Truncate(f.activeLen); err != nil {
+		_ = active.Close()
+		return fmt.Errorf("could not truncate segment: %w", err)
+	}
+
+	if _, err := active.Seek(f.activeLen, io.SeekStart); err != nil {
+		_ = active.Close()
+		return fmt.Errorf("could not seek segment: %w", err)
+	}
+
+	f.active = active
+	return syncDir(f.dir)
+}
+
+// replay applies the records of the segment from the offset and returns
+// where the last valid record ends. A record cut short by the end of the
+// last segment, where a crash may have torn it, is tolerated; any other
+// corrupt record is an error.
+func (f *FileStore) replay(id int, offset int64, last bool) (int64, error) {
+	data, err := os.ReadFile(f.segmentPath(id))
+	if err != nil {
+		return 0, fmt.Errorf("could not read segment: %w", err)
+	}
+
+	for offset < int64(len(data)) {
+		rec, n, err := decodeRecord(data[offset:])
+		if err != nil {
+			if last && errors.Is(err, errTruncatedRecord) {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("could not replay segment %d at offset %d: %w", id, offset, err)
+		}
+
+		if err := f.apply(rec, recordLocation{Segment: id, Offset: offset, Length: n}); err != nil {
+			return 0, err
+		}
+		offset += n
+	}
+	return offset, nil
+}
+
+// apply applies the record to the in-memory index.
+func (f *FileStore) apply(rec fileRecord, loc recordLocation) error {
+	key := memoryCollectionKey{userID: rec.UserID, collectionID: rec.CollectionID}
+
+	switch rec.Op {
+	case recordOpStore:
+		if err := f.mem.StoreEmbeddings(context.Background(), StoreEmbeddingInput{
+			ID:           rec.ID,
+			UserID:       rec.UserID,
+			CollectionID: rec.CollectionID,
+			Model:        rec.Model,
+			Dimensions:   rec.Dimensions,
+			Text:         rec.Text,
+			Tokens:       rec.Tokens,
+			Vector:       rec.Vector,
+			Language:     rec.Language,
+			Metadata:     rec.Metadata,
+			CreatedAt:    rec.CreatedAt,
+			Shadow:       rec.Shadow,
+		}); err != nil {
+			return err
+		}
+
+		f.locations[key] = append(f.locations[key], loc)
+	case recordOpDeleteAll:
+		if err := f.mem.DeleteCollection(context.Background(), DeleteCollectionInput{
+			UserID:       rec.UserID,
+			CollectionID: rec.CollectionID,
+		}); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		delete(f.locations, key)
+	case recordOpReplace:
+		// The collection was checked before the record was appended.
+		in := ReplaceCollectionInput{
+			UserID:             rec.UserID,
+			CollectionID:       rec.CollectionID,
+			SourceCollectionID: rec.SourceCollectionID,
+		}
+
+		if current, err := f.mem.FetchCollection(context.Background(), FetchCollectionInput{
+			UserID:       rec.UserID,
+			CollectionID: rec.CollectionID,
+		}); err == nil {
+			in.Chunks, in.UpdatedAt = current.Chunks, current.UpdatedAt
+		}
+
+		if err := f.mem.ReplaceCollection(context.Background(), in); err != nil {
+			return err
+		}
+
+		source := memoryCollectionKey{userID: rec.UserID, collectionID: rec.SourceCollectionID}
+		f.locations[key] = f.locations[source]
+		delete(f.locations, source)
+	default:
+		return fmt.Errorf("unknown record operation %q", rec.Op)
+	}
+	return nil
+}
+
+// append writes the record to the active segment and syncs it,
+// starting a new segment first if the active one is full.
+func (f *FileStore) append(rec fileRecord) (recordLocation, error) {
+	if f.active == nil {
+		return recordLocation{}, errStoreClosed
+	}
+
+	if f.failed != nil {
+		return recordLocation{}, fmt.Errorf("%w: %w", errStoreFailed, f.failed)
+	}
+
+	data, err := encodeRecord(rec)
+	if err != nil {
+		return recordLocation{}, err
+	}
+
+	if f.activeLen > 0 && f.activeLen+int64(len(data)) > f.segmentSize {
+		if err := f.rotate(); err != nil {
+			return recordLocation{}, err
+		}
+	}
+
+	loc := recordLocation{Segment: f.activeID, Offset: f.activeLen, Length: int64(len(data))}
+
+	if _, err := f.active.Write(data); err != nil {
+		f.discardTail()
+		return recordLocation{}, fmt.Errorf("could not write record: %w", err)
+	}
+
+	// The record may or may not be on disk, so it is dropped rather than
+	// applied, or it could reappear when the store is opened again.
+	if err := f.active.Sync(); err != nil {
+		f.discardTail()
+		return recordLocation{}, fmt.Errorf("could not sync segment: %w", err)
+	}
+
+	f.activeLen += int64(len(data))
+	return loc, nil
+}
+
+// discardTail drops what was written after the last record of the active
+// segment, so that later records stay readable. The store is marked failed
+// if it cannot be.
+func (f *FileStore) discardTail() {
+	if err := f.active.Truncate(f.activeLen); err != nil {
+		f.failed = fmt.Errorf("could not truncate segment: %w", err)
+		return
+	}
+
+	if err := f.active.Sync(); err != nil {
+		f.failed = fmt.Errorf("could not sync segment: %w", err)
+		return
+	}
+
+	if _, err := f.active.Seek(f.activeLen, io.SeekStart); err != nil {
+		f.failed = fmt.Errorf("could not seek segment: %w", err)
+	}
+}
+
+// rotate seals the active segment, starts the next one and writes the index.
+func (f *FileStore) rotate() error {
+	if err := f.active.Close(); err != nil {
+		return fmt.Errorf("could not close segment: %w", err)
+	}
+
+	next := f.activeID + 1
+
+	active, err := os.OpenFile(f.segmentPath(next), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not create segment: %w", err)
+	}
+
+	if err := syncDir(f.dir); err != nil {
+		_ = active.Close()
+		return err
+	}
+
+	f.active, f.activeID, f.activeLen = active, next, 0
+	f.segments = append(f.segments, next)
+
+	return f.writeIndex()
+}
+
+// writeIndex atomically replaces the index with the current locations.
+func (f *FileStore) writeIndex() error {
+	idx := fileIndex{
+		Version:     fileIndexVersion,
+		Segments:    f.segments,
+		Active:      f.activeID,
+		Offset:      f.activeLen,
+		Collections: make([]fileIndexCollection, 0, len(f.locations)),
+	}
+
+	for key, locs := range f.locations {
+		idx.Collections = append(idx.Collections, fileIndexCollection{
+			UserID:       key.userID,
+			CollectionID: key.collectionID,
+			Records:      locs,
+		})
+	}
+
+	sort.Slice(idx.Collections, func(i, j int) bool {
+		a, b := idx.Collections[i], idx.Collections[j]
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.CollectionID < b.CollectionID
+	})
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("could not marshal index: %w", err)
+	}
+
+	return writeFileAtomic(filepath.Join(f.dir, fileIndexName), data)
+}
+
+func (f *FileStore) readIndex() (*fileIndex, error) {
+	data, err := os.ReadFile(filepath.Join(f.dir, fileIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &fileIndex{Version: fileIndexVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read index: %w", err)
+	}
+
+	var idx fileIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("could not unmarshal index: %w", err)
+	}
+
+	if idx.Version != fileIndexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", idx.Version)
+	}
+	return &idx, nil
+}
+
+func (f *FileStore) readRecord(loc recordLocation) (fileRecord, error) {
+	file, err := os.Open(f.segmentPath(loc.Segment))
+	if err != nil {
+		return fileRecord{}, fmt.Errorf("could not open segment: %w", err)
+	}
+	defer file.Close()
+
+	data := make([]byte, loc.Length)
+	if _, err := file.ReadAt(data, loc.Offset); err != nil {
+		return fileRecord{}, fmt.Errorf("could not read record: %w", err)
+	}
+
+	rec, _, err := decodeRecord(data)
+	return rec, err
+}
+
+// listSegments returns the IDs of the segments in the directory, in order.
+func (f *FileStore) listSegments() ([]int, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list segments: %w", err)
+	}
+
+	var ids []int
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (f *FileStore) segmentPath(id int) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// encodeRecord frames the JSON encoded record with its length and CRC-32 checksum.
+func encodeRecord(rec fileRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal record: %w", err)
+	}
+
+	data := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+	copy(data[recordHeaderSize:], payload)
+	return data, nil
+}
+
+// decodeRecord decodes the record at the start of data and returns its framed length.
+func decodeRecord(data []byte) (fileRecord, int64, error) {
+	if len(data) < recordHeaderSize {
+		return fileRecord{}, 0, fmt.Errorf("%w header", errTruncatedRecord)
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[0:4]))
+	if len(data)-recordHeaderSize < size {
+		return fileRecord{}, 0, fmt.Errorf("%w payload", errTruncatedRecord)
+	}
+
+	payload := data[recordHeaderSize : recordHeaderSize+size]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[4:8]) {
+		return fileRecord{}, 0, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+
+	var rec fileRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return fileRecord{}, 0, fmt.Errorf("could not unmarshal record: %w", err)
+	}
+	return rec, int64(recordHeaderSize + size), nil
+}
+
+// writeFileAtomic replaces the file with the data by writing and syncing
+// a temporary file, renaming it over the file and syncing the directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not write temporary file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not sync temporary file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not rename temporary file: %w", err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs the directory so that the files created or renamed in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeFileChunks stores n chunks in the collection, as shadow chunks
+// if the collection ID starts with "shadow-".
+func storeFileChunks(t *testing.T, f *FileStore, collectionID string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, f.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           fmt.Sprintf("%s-emb-%d", collectionID, i),
+			UserID:       "user-1",
+			CollectionID: collectionID,
+			Model:        "text-embedding-3-small",
+			Text:         fmt.Sprintf("chunk %d", i),
+			Tokens:       2,
+			Vector:       []float32{float32(i), 1},
+			Metadata:     map[string]any{"n": i},
+			Shadow:       strings.HasPrefix(collectionID, "shadow-"),
+		}))
+	}
+}
+
+func fileChunkIDs(t *testing.T, f *FileStore, collectionID string) []string {
+	t.Helper()
+
+	chunks, err := f.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+		UserID:       "user-1",
+		CollectionID: collectionID,
+		Vector:       []float32{0, 1},
+		Limit:        100,
+	})
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	// A small segment size makes the store rotate segments and write the index.
+	f, err := OpenFileStore(dir, WithSegmentSize(512))
+	require.NoError(t, err)
+
+	storeFileChunks(t, f, "coll-1", 5)
+	storeFileChunks(t, f, "coll-2", 3)
+
+	require.NoError(t, f.DeleteCollection(context.TODO(), DeleteCollectionInput{UserID: "user-1", CollectionID: "coll-2"}))
+
+	storeFileChunks(t, f, "coll-3", 2)
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	assert.Greater(t, len(segments), 1)
+
+	require.NoError(t, f.Close())
+
+	err = f.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{UserID: "user-1", CollectionID: "coll-1"})
+	assert.ErrorIs(t, err, errStoreClosed)
+
+	f, err = OpenFileStore(dir, WithSegmentSize(512))
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Equal(t, []string{"coll-1-emb-0", "coll-1-emb-1", "coll-1-emb-2", "coll-1-emb-3", "coll-1-emb-4"}, fileChunkIDs(t, f, "coll-1"))
+	assert.Empty(t, fileChunkIDs(t, f, "coll-2"))
+	assert.Equal(t, []string{"coll-3-emb-0", "coll-3-emb-1"}, fileChunkIDs(t, f, "coll-3"))
+
+	chunks, err := f.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+		UserID:       "user-1",
+		CollectionID: "coll-1",
+		Vector:       []float32{0, 1},
+		Filters:      []Filter{Gte("n", 3)},
+		Limit:        10,
+	})
+	require.NoError(t, err)
+	assert.Len(t, chunks, 2)
+
+	collection, err := f.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: "coll-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), collection.Chunks)
+	assert.Equal(t, int64(10), collection.Tokens)
+}
+
+func TestFileStoreReplaceCollection(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := OpenFileStore(dir, WithSegmentSize(512))
+	require.NoError(t, err)
+
+	storeFileChunks(t, f, "coll-1", 3)
+	storeFileChunks(t, f, "shadow-1", 2)
+	storeFileChunks(t, f, "coll-2", 1)
+	storeFileChunks(t, f, "shadow-2", 2)
+
+	collections, err := f.ListCollections(context.TODO(), ListCollectionsInput{UserID: "user-1"})
+	require.NoError(t, err)
+	require.Len(t, collections, 2)
+	assert.Equal(t, "coll-1", collections[0].ID)
+	assert.Equal(t, "coll-2", collections[1].ID)
+
+	replaceInput := func(collectionID, sourceID string) ReplaceCollectionInput {
+		collection, err := f.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: collectionID})
+		require.NoError(t, err)
+
+		return ReplaceCollectionInput{
+			UserID:             "user-1",
+			CollectionID:       collectionID,
+			SourceCollectionID: sourceID,
+			Chunks:             collection.Chunks,
+			UpdatedAt:          collection.UpdatedAt,
+		}
+	}
+
+	// The first replace is captured by the index written on rotation,
+	// the second is replayed from the segments.
+	require.NoError(t, f.ReplaceCollection(context.TODO(), replaceInput("coll-1", "shadow-1")))
+	storeFileChunks(t, f, "coll-3", 5)
+
+	// Chunks stored after the input was read are not lost.
+	in := replaceInput("coll-2", "shadow-2")
+	storeFileChunks(t, f, "coll-2", 2)
+	err = f.ReplaceCollection(context.TODO(), in)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	require.NoError(t, f.ReplaceCollection(context.TODO(), replaceInput("coll-2", "shadow-2")))
+
+	err = f.ReplaceCollection(context.TODO(), replaceInput("coll-1", "shadow-1"))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assertReplaced := func(f *FileStore) {
+		assert.Equal(t, []string{"shadow-1-emb-0", "shadow-1-emb-1"}, fileChunkIDs(t, f, "coll-1"))
+		assert.Equal(t, []string{"shadow-2-emb-0", "shadow-2-emb-1"}, fileChunkIDs(t, f, "coll-2"))
+		assert.Empty(t, fileChunkIDs(t, f, "shadow-1"))
+		assert.Empty(t, fileChunkIDs(t, f, "shadow-2"))
+
+		collections, err := f.ListCollections(context.TODO(), ListCollectionsInput{UserID: "user-1"})
+		require.NoError(t, err)
+
+		var ids []string
+		for _, c := range collections {
+			ids = append(ids, c.ID)
+		}
+		assert.ElementsMatch(t, []string{"coll-1", "coll-2", "coll-3"}, ids)
+	}
+
+	assertReplaced(f)
+	require.NoError(t, f.active.Close())
+
+	f, err = OpenFileStore(dir, WithSegmentSize(512))
+	require.NoError(t, err)
+	assertReplaced(f)
+	require.NoError(t, f.Close())
+
+	f, err = OpenFileStore(dir, WithSegmentSize(512))
+	require.NoError(t, err)
+	defer f.Close()
+	assertReplaced(f)
+}
+
+func TestFileStoreRecoversWithoutClose(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := OpenFileStore(dir)
+	require.NoError(t, err)
+
+	storeFileChunks(t, f, "coll-1", 3)
+
+	// Simulate a crash: no index is written and the last record is torn.
+	require.NoError(t, f.active.Close())
+
+	segment := f.segmentPath(f.activeID)
+
+	data, err := os.ReadFile(segment)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(segment, data[:len(data)-5], 0o644))
+
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"coll-1-emb-0", "coll-1-emb-1"}, fileChunkIDs(t, f, "coll-1"))
+
+	// The torn record is discarded, so new records are readable after it.
+	storeFileChunks(t, f, "coll-2", 1)
+	require.NoError(t, f.Close())
+
+	f, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Equal(t, []string{"coll-1-emb-0", "coll-1-emb-1"}, fileChunkIDs(t, f, "coll-1"))
+	assert.Equal(t, []string{"coll-2-emb-0"}, fileChunkIDs(t, f, "coll-2"))
+}
+
+func TestFileStoreDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := OpenFileStore(dir, WithSegmentSize(256))
+	require.NoError(t, err)
+
+	storeFileChunks(t, f, "coll-1", 4)
+	require.NoError(t, f.Close())
+
+	// Corrupt the first, sealed, segment.
+	segment := f.segmentPath(1)
+
+	data, err := os.ReadFile(segment)
+	require.NoError(t, err)
+
+	data[recordHeaderSize+2] ^= 0xff
+	require.NoError(t, os.WriteFile(segment, data, 0o644))
+
+	_, err = OpenFileStore(dir, WithSegmentSize(256))
+	assert.ErrorIs(t, err, errCorruptRecord)
+}
+
+func TestFileStoreDetectsCorruptionInLastSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := OpenFileStore(dir)
+	require.NoError(t, err)
+
+	storeFileChunks(t, f, "coll-1", 3)
+	require.NoError(t, f.active.Close())
+
+	// Corrupt the first record of the last segment, which is followed by valid ones.
+	segment := f.segmentPath(f.activeID)
+
+	data, err := os.ReadFile(segment)
+	require.NoError(t, err)
+
+	data[recordHeaderSize+2] ^= 0xff
+	require.NoError(t, os.WriteFile(segment, data, 0o644))
+
+	_, err = OpenFileStore(dir)
+	assert.ErrorIs(t, err, errCorruptRecord)
+	assert.NotErrorIs(t, err, errTruncatedRecord)
+
+	// The records after the corrupt one are not truncated.
+	info, err := os.Stat(segment)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+}
+
+func TestFileStoreFailsAfterUnrecoverableWrite(t *testing.T) {
+	f, err := OpenFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	storeFileChunks(t, f, "coll-1", 1)
+
+	// Writes to the segment, and truncating it back, now fail.
+	require.NoError(t, f.active.Close())
+
+	in := StoreEmbeddingInput{ID: "emb-2", UserID: "user-1", CollectionID: "coll-1", Vector: []float32{1, 1}}
+
+	err = f.StoreEmbeddings(context.TODO(), in)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errStoreFailed)
+
+	err = f.StoreEmbeddings(context.TODO(), in)
+	assert.ErrorIs(t, err, errStoreFailed)
+
+	assert.Equal(t, []string{"coll-1-emb-0"}, fileChunkIDs(t, f, "coll-1"))
+}
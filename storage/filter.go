@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// FilterOp represents the comparison applied by a Filter.
+type FilterOp string
+
+const (
+	FilterEq     FilterOp = "eq"
+	FilterIn     FilterOp = "in"
+	FilterGt     FilterOp = "gt"
+	FilterGte    FilterOp = "gte"
+	FilterLt     FilterOp = "lt"
+	FilterLte    FilterOp = "lte"
+	FilterHasTag FilterOp = "has_tag"
+)
+
+var (
+	// ErrInvalidFilter is returned when a filter has no field or an unsupported operator.
+	ErrInvalidFilter = errors.New("invalid filter")
+
+	errEmptyFilterField = fmt.Errorf("%w: field is empty", ErrInvalidFilter)
+)
+
+// Filter represents a condition on the metadata of a chunk.
+// Filters given together must all match.
+//
+// Range operators compare numbers numerically and time.Time values
+// as timestamps, in which case the metadata field must hold an ISO 8601 string.
+// Any other value is compared as text.
+type Filter struct {
+	Field  string
+	Op     FilterOp
+	Value  any
+	Values []any
+}
+
+// Eq matches chunks whose field equals the value.
+func Eq(field string, value any) Filter {
+	return Filter{Field: field, Op: FilterEq, Value: value}
+}
+
+// In matches chunks whose field equals any of the values.
+func In(field string, values ...any) Filter {
+	return Filter{Field: field, Op: FilterIn, Values: values}
+}
+
+// Gt matches chunks whose field is greater than the value.
+func Gt(field string, value any) Filter {
+	return Filter{Field: field, Op: FilterGt, Value: value}
+}
+
+// Gte matches chunks whose field is greater than or equal to the value.
+func Gte(field string, value any) Filter {
+	return Filter{Field: field, Op: FilterGte, Value: value}
+}
+
+// Lt matches chunks whose field is less than the value.
+func Lt(field string, value any) Filter {
+	return Filter{Field: field, Op: FilterLt, Value: value}
+}
+
+// Lte matches chunks whose field is less than or equal to the value.
+func Lte(field string, value any) Filter {
+	return Filter{Field: field, Op: FilterLte, Value: value}
+}
+
+// HasTag matches chunks whose field is an array containing the tag.
+func HasTag(field string, tag string) Filter {
+	return Filter{Field: field, Op: FilterHasTag, Value: tag}
+}
+
+var rangeOperators = map[FilterOp]string{
+	FilterGt:  ">",
+	FilterGte: ">=",
+	FilterLt:  "<",
+	FilterLte: "<=",
+}
+
+// NewFilter returns the filter with the operator of the given name,
+// as decoded from JSON or protobuf. String values of range filters
+// in RFC 3339 format are parsed as timestamps so that dates compare as such.
+func NewFilter(field, op string, value any, values []any) Filter {
+	f := Filter{Field: field, Op: FilterOp(op), Value: value, Values: values}
+
+	switch f.Op {
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				f.Value = t
+			}
+		}
+	}
+	return f
+}
+
+// compileFilters returns the SQL condition matching all filters, prefixed
+// with AND, and its arguments. Placeholders are numbered after offset.
+// Field names and values are always passed as arguments.
+func compileFilters(filters []Filter, offset int) (string, []any, error) {
+	var (
+		sb   strings.Builder
+		args []any
+	)
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", offset+len(args))
+	}
+
+	for _, f := range filters {
+		if f.Field == "" {
+			return "", nil, errEmptyFilterField
+		}
+
+		switch f.Op {
+		case FilterEq:
+			doc, err := json.Marshal(map[string]any{f.Field: f.Value})
+			if err != nil {
+				return "", nil, fmt.Errorf("could not marshal filter value: %w", err)
+			}
+			fmt.Fprintf(&sb, " AND metadata @> %s::jsonb", arg(string(doc)))
+
+		case FilterIn:
+			doc, err := json.Marshal(f.Values)
+			if err != nil {
+				return "", nil, fmt.Errorf("could not marshal filter values: %w", err)
+			}
+			fmt.Fprintf(&sb, " AND %s::jsonb @> (metadata -> %s)", arg(string(doc)), arg(f.Field))
+
+		case FilterHasTag:
+			doc, err := json.Marshal(map[string][]any{f.Field: {f.Value}})
+			if err != nil {
+				return "", nil, fmt.Errorf("could not marshal filter value: %w", err)
+			}
+			fmt.Fprintf(&sb, " AND metadata @> %s::jsonb", arg(string(doc)))
+
+		case FilterGt, FilterGte, FilterLt, FilterLte:
+			op := rangeOperators[f.Op]
+
+			switch v := f.Value.(type) {
+			case time.Time:
+				fmt.Fprintf(&sb,
+					" AND (CASE WHEN jsonb_typeof(metadata -> %s) = 'string' THEN (metadata ->> %s)::timestamptz %s %s::timestamptz END)",
+					arg(f.Field), arg(f.Field), op, arg(v.UTC().Format(time.RFC3339Nano)),
+				)
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+				fmt.Fprintf(&sb,
+					" AND (CASE WHEN jsonb_typeof(metadata -> %s) = 'number' THEN (metadata ->> %s)::numeric %s %s::numeric END)",
+					arg(f.Field), arg(f.Field), op, arg(fmt.Sprint(v)),
+				)
+			default:
+				fmt.Fprintf(&sb, " AND (metadata ->> %s) %s %s", arg(f.Field), op, arg(fmt.Sprint(v)))
+			}
+
+		default:
+			return "", nil, fmt.Errorf("%w: unsupported operator %q", ErrInvalidFilter, f.Op)
+		}
+	}
+	return sb.String(), args, nil
+}
+
+// matchFilters reports whether the metadata, as decoded from JSON,
+// matches all filters with the same semantics as compileFilters.
+func matchFilters(metadata map[string]any, filters []Filter) (bool, error) {
+	for _, f := range filters {
+		if f.Field == "" {
+			return false, errEmptyFilterField
+		}
+
+		ok, err := matchFilter(metadata, f)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchFilter(metadata map[string]any, f Filter) (bool, error) {
+	field, exists := metadata[f.Field]
+
+	switch f.Op {
+	case FilterEq:
+		value, err := normalizeJSON(f.Value)
+		if err != nil {
+			return false, fmt.Errorf("could not marshal filter value: %w", err)
+		}
+		return exists && jsonContains(field, value), nil
+
+	case FilterIn:
+		values, err := normalizeJSON(f.Values)
+		if err != nil {
+			return false, fmt.Errorf("could not marshal filter values: %w", err)
+		}
+
+		if !exists {
+			return false, nil
+		}
+
+		// Like jsonb, an array contains a primitive value directly.
+		if _, ok := field.([]any); !ok {
+			field = []any{field}
+		}
+		return jsonContains(values, field), nil
+
+	case FilterHasTag:
+		tag, err := normalizeJSON(f.Value)
+		if err != nil {
+			return false, fmt.Errorf("could not marshal filter value: %w", err)
+		}
+
+		_, isArray := field.([]any)
+		return isArray && jsonContains(field, []any{tag}), nil
+
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if !exists || field == nil {
+			return false, nil
+		}
+
+		var cmp int
+
+		switch v := f.Value.(type) {
+		case time.Time:
+			s, ok := field.(string)
+			if !ok {
+				return false, nil
+			}
+
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return false, fmt.Errorf("could not parse %q of field %q as a timestamp: %w", s, f.Field, err)
+			}
+			cmp = t.Compare(v)
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			n, ok := field.(float64)
+			if !ok {
+				return false, nil
+			}
+
+			cmp = compareFloats(n, toFloat64(v))
+		default:
+			text, ok := field.(string)
+			if !ok {
+				raw, err := json.Marshal(field)
+				if err != nil {
+					return false, fmt.Errorf("could not marshal metadata value: %w", err)
+				}
+				text = string(raw)
+			}
+			cmp = strings.Compare(text, fmt.Sprint(v))
+		}
+
+		switch f.Op {
+		case FilterGt:
+			return cmp > 0, nil
+		case FilterGte:
+			return cmp >= 0, nil
+		case FilterLt:
+			return cmp < 0, nil
+		default:
+			return cmp <= 0, nil
+		}
+
+	default:
+		return false, fmt.Errorf("%w: unsupported operator %q", ErrInvalidFilter, f.Op)
+	}
+}
+
+// jsonContains reports whether a contains b, following the jsonb @> operator:
+// objects contain the keys of b with contained values, and arrays contain
+// every element of b in any order.
+func jsonContains(a, b any) bool {
+	switch bv := b.(type) {
+	case map[string]any:
+		av, ok := a.(map[string]any)
+		if !ok {
+			return false
+		}
+
+		for k, v := range bv {
+			field, ok := av[k]
+			if !ok || !jsonContains(field, v) {
+				return false
+			}
+		}
+		return true
+
+	case []any:
+		av, ok := a.([]any)
+		if !ok {
+			return false
+		}
+
+		for _, v := range bv {
+			var found bool
+			for _, elem := range av {
+				if jsonContains(elem, v) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return a == b
+	}
+}
+
+// normalizeJSON returns the value as it would be decoded from its JSON encoding.
+func normalizeJSON(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toFloat64(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int8:
+		return float64(n)
+	case int16:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint:
+		return float64(n)
+	case uint8:
+		return float64(n)
+	case uint16:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	default:
+		return math.NaN()
+	}
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileFilters(t *testing.T) {
+	tests := []struct {
+		name         string
+		filters      []Filter
+		expectedCond string
+		expectedArgs []any
+	}{
+		{
+			name:         "No filters",
+			filters:      nil,
+			expectedCond: "",
+			expectedArgs: nil,
+		},
+		{
+			name:         "Equality",
+			filters:      []Filter{Eq("department", "hr")},
+			expectedCond: " AND metadata @> $5::jsonb",
+			expectedArgs: []any{`{"department":"hr"}`},
+		},
+		{
+			name:         "In",
+			filters:      []Filter{In("year", 2023, 2024)},
+			expectedCond: " AND $5::jsonb @> (metadata -> $6)",
+			expectedArgs: []any{`[2023,2024]`, "year"},
+		},
+		{
+			name:         "Tag membership",
+			filters:      []Filter{HasTag("tags", "policy")},
+			expectedCond: " AND metadata @> $5::jsonb",
+			expectedArgs: []any{`{"tags":["policy"]}`},
+		},
+		{
+			name:    "Date range",
+			filters: []Filter{Gte("date", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))},
+			expectedCond: " AND (CASE WHEN jsonb_typeof(metadata -> $5) = 'string' " +
+				"THEN (metadata ->> $6)::timestamptz >= $7::timestamptz END)",
+			expectedArgs: []any{"date", "date", "2024-01-01T00:00:00Z"},
+		},
+		{
+			name:    "Numeric range",
+			filters: []Filter{Lt("pages", 10)},
+			expectedCond: " AND (CASE WHEN jsonb_typeof(metadata -> $5) = 'number' " +
+				"THEN (metadata ->> $6)::numeric < $7::numeric END)",
+			expectedArgs: []any{"pages", "pages", "10"},
+		},
+		{
+			name:         "Text range and equality combined",
+			filters:      []Filter{Gt("name", "m"), Eq("lang", "en")},
+			expectedCond: " AND (metadata ->> $5) > $6 AND metadata @> $7::jsonb",
+			expectedArgs: []any{"name", "m", `{"lang":"en"}`},
+		},
+		{
+			name:         "Field names are never interpolated",
+			filters:      []Filter{Eq("x'; DROP TABLE embeddings; --", 1)},
+			expectedCond: " AND metadata @> $5::jsonb",
+			expectedArgs: []any{`{"x'; DROP TABLE embeddings; --":1}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args, err := compileFilters(tt.filters, 4)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedCond, cond)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestCompileFiltersErrors(t *testing.T) {
+	_, _, err := compileFilters([]Filter{Eq("", "value")}, 0)
+	assert.ErrorIs(t, err, errEmptyFilterField)
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+
+	_, _, err = compileFilters([]Filter{{Field: "field", Op: "like"}}, 0)
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
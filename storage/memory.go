@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DistanceMetric is how Memory measures the distance between two vectors.
+// Lower distances are closer, as with pgvector operators.
+type DistanceMetric string
+
+const (
+	// DistanceL2 is the Euclidean distance, as pgvector's <-> used by Postgres.
+	DistanceL2 DistanceMetric = "l2"
+	// DistanceCosine is one minus the cosine similarity, as pgvector's <=>.
+	DistanceCosine DistanceMetric = "cosine"
+	// DistanceInnerProduct is the negative inner product, as pgvector's <#>.
+	DistanceInnerProduct DistanceMetric = "inner_product"
+)
+
+// Memory is a Repository keeping the chunks in memory, searched by brute force.
+// It is safe for concurrent use and meant for tests, demos and small collections.
+type Memory struct {
+	mu      sync.RWMutex
+	chunks  map[memoryCollectionKey][]memoryChunk
+	metric  DistanceMetric
+	nowFunc func() time.Time
+}
+
+type memoryCollectionKey struct {
+	userID       string
+	collectionID string
+}
+
+type memoryChunk struct {
+	id         string
+	model      string
+	dimensions int
+	text       string
+	tokens     int64
+	vector     []float32
+	language   string
+	terms      map[string]int
+	words      int
+	metadata   map[string]any
+	rawMeta    []byte
+	createdAt  time.Time
+	shadow     bool
+}
+
+// MemoryOption configures optional behaviour of Memory.
+type MemoryOption func(*Memory)
+
+// WithDistanceMetric sets how vectors are compared. Defaults to DistanceL2.
+func WithDistanceMetric(m DistanceMetric) MemoryOption {
+	return func(mem *Memory) {
+		mem.metric = m
+	}
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory(opts ...MemoryOption) *Memory {
+	m := &Memory{
+		chunks:  make(map[memoryCollectionKey][]memoryChunk),
+		metric:  DistanceL2,
+		nowFunc: time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// StoreEmbeddings stores the chunk. Chunks without a creation time are stamped with the current time.
+func (m *Memory) StoreEmbeddings(ctx context.Context, in StoreEmbeddingInput) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not store vector: %w", err)
+	}
+
+	rawMeta, err := marshalMetadata(in.Metadata)
+	if err != nil {
+		return err
+	}
+
+	// Round trip the metadata through JSON so that it is filtered and
+	// returned with the same types as when it is stored in Postgres.
+	var metadata map[string]any
+	if err := json.Unmarshal(rawMeta, &metadata); err != nil {
+		return fmt.Errorf("could not unmarshal metadata: %w", err)
+	}
+
+	createdAt := in.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = m.nowFunc()
+	}
+
+	terms, words := termFrequencies(in.Text)
+
+	c := memoryChunk{
+		id:         in.ID,
+		model:      in.Model,
+		dimensions: in.Dimensions,
+		text:       in.Text,
+		tokens:     in.Tokens,
+		vector:     append([]float32(nil), in.Vector...),
+		language:   textSearchLanguage(in.Language),
+		terms:      terms,
+		words:      words,
+		metadata:   metadata,
+		rawMeta:    rawMeta,
+		createdAt:  createdAt,
+		shadow:     in.Shadow,
+	}
+
+	key := memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chunks[key] = append(m.chunks[key], c)
+	return nil
+}
+
+// FetchNearestNeighbors returns up to Limit chunks matching the filters,
+// ordered by their distance to the given vector.
+func (m *Memory) FetchNearestNeighbors(ctx context.Context, in FetchNearestNeighborsInput) ([]Chunk, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not fetch nearest neighbors: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var chunks []Chunk
+	for _, c := range m.chunks[memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}] {
+		ok, err := matchFilters(c.metadata, in.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch nearest neighbors: %w", err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		chunk, err := c.toChunk()
+		if err != nil {
+			return nil, err
+		}
+
+		chunk.Distance = m.distance(in.Vector, c.vector)
+		chunks = append(chunks, chunk)
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Distance < chunks[j].Distance
+	})
+	return limitChunks(chunks, in.Limit), nil
+}
+
+// FetchKeywordMatches returns up to Limit chunks containing every word of the
+// query and matching the filters, ordered by the frequency of the query words.
+// Words are matched case insensitively, without stemming, so the language is ignored.
+func (m *Memory) FetchKeywordMatches(ctx context.Context, in FetchKeywordMatchesInput) ([]Chunk, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not fetch keyword matches: %w", err)
+	}
+
+	query, _ := termFrequencies(in.Query)
+	if len(query) == 0 {
+		return []Chunk{}, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var chunks []Chunk
+
+	for _, c := range m.chunks[memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}] {
+		var hits int
+		for term := range query {
+			n := c.terms[term]
+			if n == 0 {
+				hits = 0
+				break
+			}
+			hits += n
+		}
+
+		if hits == 0 {
+			continue
+		}
+
+		ok, err := matchFilters(c.metadata, in.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch keyword matches: %w", err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		chunk, err := c.toChunk()
+		if err != nil {
+			return nil, err
+		}
+
+		chunk.Rank = float64(hits) / float64(c.words)
+		chunks = append(chunks, chunk)
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Rank > chunks[j].Rank
+	})
+	return limitChunks(chunks, in.Limit), nil
+}
+
+// ListCollections returns the collections of the user, oldest first,
+// leaving out shadow collections.
+func (m *Memory) ListCollections(ctx context.Context, in ListCollectionsInput) ([]Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not list collections: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	collections := make([]Collection, 0)
+	for key, chunks := range m.chunks {
+		if key.userID == in.UserID && len(chunks) > 0 && !chunks[0].shadow {
+			collections = append(collections, summarize(key.collectionID, chunks))
+		}
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		if !collections[i].CreatedAt.Equal(collections[j].CreatedAt) {
+			return collections[i].CreatedAt.Before(collections[j].CreatedAt)
+		}
+		return collections[i].ID < collections[j].ID
+	})
+	return collections, nil
+}
+
+// FetchCollection returns the collection of the user, or ErrNotFound if it has no chunks.
+func (m *Memory) FetchCollection(ctx context.Context, in FetchCollectionInput) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not fetch collection: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chunks := m.chunks[memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}]
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("could not fetch collection: %w", ErrNotFound)
+	}
+
+	collection := summarize(in.CollectionID, chunks)
+	return &collection, nil
+}
+
+// DeleteCollection deletes the chunks of the collection, or returns ErrNotFound if it has none.
+func (m *Memory) DeleteCollection(ctx context.Context, in DeleteCollectionInput) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not delete collection: %w", err)
+	}
+
+	key := memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.chunks[key]) == 0 {
+		return fmt.Errorf("could not delete collection: %w", ErrNotFound)
+	}
+
+	delete(m.chunks, key)
+	return nil
+}
+
+// FetchEmbeddings returns up to Limit chunks of the collection
+// with an ID greater than AfterID, ordered by ID.
+func (m *Memory) FetchEmbeddings(ctx context.Context, in FetchEmbeddingsInput) ([]Embedding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("could not fetch embeddings: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	embeddings := make([]Embedding, 0)
+	for _, c := range m.chunks[memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}] {
+		if c.id <= in.AfterID {
+			continue
+		}
+
+		var metadata map[string]any
+		if err := json.Unmarshal(c.rawMeta, &metadata); err != nil {
+			return nil, fmt.Errorf("could not unmarshal metadata: %w", err)
+		}
+
+		embeddings = append(embeddings, Embedding{
+			ID:         c.id,
+			Model:      c.model,
+			Dimensions: c.dimensions,
+			Text:       c.text,
+			Tokens:     c.tokens,
+			Vector:     append([]float32(nil), c.vector...),
+			Language:   c.language,
+			Metadata:   metadata,
+			CreatedAt:  c.createdAt,
+		})
+	}
+
+	sort.Slice(embeddings, func(i, j int) bool {
+		return embeddings[i].ID < embeddings[j].ID
+	})
+
+	if in.Limit > 0 && len(embeddings) > in.Limit {
+		embeddings = embeddings[:in.Limit]
+	}
+	return embeddings, nil
+}
+
+// ReplaceCollection atomically replaces the chunks of the collection with those
+// of the source collection, which is left empty. It returns ErrConflict if the
+// collection changed since the input was read, and ErrNotFound if the source
+// collection has no chunks.
+func (m *Memory) ReplaceCollection(ctx context.Context, in ReplaceCollectionInput) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("could not replace collection: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := memoryCollectionKey{userID: in.UserID, collectionID: in.CollectionID}
+	source := memoryCollectionKey{userID: in.UserID, collectionID: in.SourceCollectionID}
+
+	current := summarize(in.CollectionID, m.chunks[target])
+	if !in.unchanged(current.Chunks, current.UpdatedAt) {
+		return fmt.Errorf("could not replace collection: %w", ErrConflict)
+	}
+
+	chunks := m.chunks[source]
+	if len(chunks) == 0 {
+		return fmt.Errorf("could not replace collection: %w", ErrNotFound)
+	}
+
+	for i := range chunks {
+		chunks[i].shadow = false
+	}
+
+	m.chunks[target] = chunks
+	delete(m.chunks, source)
+	return nil
+}
+
+func (m *Memory) distance(a, b []float32) float64 {
+	var dot, normA, normB, l2 float64
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+		l2 += (x - y) * (x - y)
+	}
+
+	switch m.metric {
+	case DistanceCosine:
+		if normA == 0 || normB == 0 {
+			return 1
+		}
+		return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+	case DistanceInnerProduct:
+		return -dot
+	default:
+		return math.Sqrt(l2)
+	}
+}
+
+func (c *memoryChunk) toChunk() (Chunk, error) {
+	var metadata map[string]any
+	if err := json.Unmarshal(c.rawMeta, &metadata); err != nil {
+		return Chunk{}, fmt.Errorf("could not unmarshal metadata: %w", err)
+	}
+
+	return Chunk{
+		ID:       c.id,
+		Text:     c.text,
+		Vector:   append([]float32(nil), c.vector...),
+		Metadata: metadata,
+	}, nil
+}
+
+func summarize(collectionID string, chunks []memoryChunk) Collection {
+	collection := Collection{ID: collectionID}
+
+	for i, c := range chunks {
+		collection.Chunks++
+		collection.Tokens += c.tokens
+
+		if i == 0 || c.model < collection.Model {
+			collection.Model = c.model
+		}
+
+		if i == 0 || c.dimensions < collection.Dimensions {
+			collection.Dimensions = c.dimensions
+		}
+
+		if i == 0 || c.createdAt.Before(collection.CreatedAt) {
+			collection.CreatedAt = c.createdAt
+		}
+
+		if c.createdAt.After(collection.UpdatedAt) {
+			collection.UpdatedAt = c.createdAt
+		}
+	}
+	return collection
+}
+
+func limitChunks(chunks []Chunk, limit int) []Chunk {
+	if chunks == nil {
+		return []Chunk{}
+	}
+
+	if limit >= 0 && len(chunks) > limit {
+		return chunks[:limit]
+	}
+	return chunks
+}
+
+// termFrequencies returns how many times each lowercased word appears in the text,
+// and the number of words.
+func termFrequencies(text string) (map[string]int, int) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make(map[string]int, len(words))
+	for _, w := range words {
+		terms[w]++
+	}
+	return terms, len(words)
+}
@@ -0,0 +1,378 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryNearestNeighbors(t *testing.T) {
+	vectors := map[string][]float32{
+		"a": {1, 0},
+		"b": {0.9, 0.1},
+		"c": {-1, 0},
+		"d": {10, 0},
+	}
+
+	testCases := []struct {
+		name     string
+		metric   DistanceMetric
+		expected []string
+	}{
+		{
+			name:     "l2",
+			metric:   DistanceL2,
+			expected: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "cosine",
+			metric:   DistanceCosine,
+			expected: []string{"a", "d", "b", "c"},
+		},
+		{
+			name:     "inner product",
+			metric:   DistanceInnerProduct,
+			expected: []string{"d", "a", "b", "c"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemory(WithDistanceMetric(tc.metric))
+
+			for _, id := range []string{"a", "b", "c", "d"} {
+				require.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+					ID:           id,
+					UserID:       "user-1",
+					CollectionID: "coll-1",
+					Text:         id,
+					Vector:       vectors[id],
+				}))
+			}
+
+			chunks, err := m.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+				UserID:       "user-1",
+				CollectionID: "coll-1",
+				Vector:       []float32{1, 0},
+				Limit:        10,
+			})
+			require.NoError(t, err)
+
+			var ids []string
+			for _, c := range chunks {
+				ids = append(ids, c.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
+
+func TestMemoryScopingAndFilters(t *testing.T) {
+	m := NewMemory()
+
+	store := func(id, userID, collectionID string, metadata map[string]any) {
+		require.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           id,
+			UserID:       userID,
+			CollectionID: collectionID,
+			Text:         "the vacation policy of " + id,
+			Vector:       []float32{1, 0},
+			Metadata:     metadata,
+		}))
+	}
+
+	store("hr-2023", "user-1", "coll-1", map[string]any{"department": "hr", "year": 2023, "tags": []string{"policy"}, "date": "2023-06-01T00:00:00Z"})
+	store("hr-2024", "user-1", "coll-1", map[string]any{"department": "hr", "year": 2024, "tags": []string{"policy", "new"}, "date": "2024-06-01T00:00:00Z"})
+	store("it-2024", "user-1", "coll-1", map[string]any{"department": "it", "year": 2024})
+	store("other-collection", "user-1", "coll-2", nil)
+	store("other-user", "user-2", "coll-1", nil)
+
+	testCases := []struct {
+		name     string
+		filters  []Filter
+		expected []string
+	}{
+		{
+			name:     "no filters",
+			expected: []string{"hr-2023", "hr-2024", "it-2024"},
+		},
+		{
+			name:     "equality",
+			filters:  []Filter{Eq("department", "hr")},
+			expected: []string{"hr-2023", "hr-2024"},
+		},
+		{
+			name:     "in",
+			filters:  []Filter{In("year", 2022, 2023)},
+			expected: []string{"hr-2023"},
+		},
+		{
+			name:     "tag",
+			filters:  []Filter{HasTag("tags", "new")},
+			expected: []string{"hr-2024"},
+		},
+		{
+			name:     "numeric range",
+			filters:  []Filter{Gte("year", 2024), Eq("department", "it")},
+			expected: []string{"it-2024"},
+		},
+		{
+			name:     "date range",
+			filters:  []Filter{Lt("date", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))},
+			expected: []string{"hr-2023"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks, err := m.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+				UserID:       "user-1",
+				CollectionID: "coll-1",
+				Vector:       []float32{1, 0},
+				Filters:      tc.filters,
+				Limit:        10,
+			})
+			require.NoError(t, err)
+
+			var ids []string
+			for _, c := range chunks {
+				ids = append(ids, c.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+
+	_, err := m.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+		UserID:       "user-1",
+		CollectionID: "coll-1",
+		Filters:      []Filter{{Field: "year", Op: "like"}},
+		Limit:        10,
+	})
+	assert.Error(t, err)
+}
+
+func TestMemoryKeywordMatches(t *testing.T) {
+	m := NewMemory()
+
+	for id, text := range map[string]string{
+		"a": "Vacation days accrue monthly.",
+		"b": "Vacation requests need approval. Vacation is paid.",
+		"c": "Expenses are reimbursed monthly.",
+	} {
+		require.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           id,
+			UserID:       "user-1",
+			CollectionID: "coll-1",
+			Text:         text,
+		}))
+	}
+
+	chunks, err := m.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+		UserID:       "user-1",
+		CollectionID: "coll-1",
+		Query:        "vacation",
+		Limit:        10,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "b", chunks[0].ID)
+	assert.Equal(t, "a", chunks[1].ID)
+
+	chunks, err = m.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+		UserID:       "user-1",
+		CollectionID: "coll-1",
+		Query:        "vacation monthly",
+		Limit:        10,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "a", chunks[0].ID)
+}
+
+func TestMemoryCollections(t *testing.T) {
+	m := NewMemory()
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           fmt.Sprintf("emb-%d", i),
+			UserID:       "user-1",
+			CollectionID: fmt.Sprintf("coll-%d", i%2),
+			Model:        "text-embedding-3-small",
+			Tokens:       10,
+			CreatedAt:    created.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	collections, err := m.ListCollections(context.TODO(), ListCollectionsInput{UserID: "user-1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Collection{
+		{ID: "coll-0", Model: "text-embedding-3-small", Chunks: 2, Tokens: 20, CreatedAt: created, UpdatedAt: created.Add(2 * time.Hour)},
+		{ID: "coll-1", Model: "text-embedding-3-small", Chunks: 1, Tokens: 10, CreatedAt: created.Add(time.Hour), UpdatedAt: created.Add(time.Hour)},
+	}, collections)
+
+	collections, err = m.ListCollections(context.TODO(), ListCollectionsInput{UserID: "user-2"})
+	require.NoError(t, err)
+	assert.Empty(t, collections)
+
+	require.NoError(t, m.DeleteCollection(context.TODO(), DeleteCollectionInput{UserID: "user-1", CollectionID: "coll-0"}))
+
+	_, err = m.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: "coll-0"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = m.DeleteCollection(context.TODO(), DeleteCollectionInput{UserID: "user-1", CollectionID: "coll-0"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryFetchEmbeddings(t *testing.T) {
+	m := NewMemory()
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, id := range []string{"c", "a", "d", "b"} {
+		require.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           id,
+			UserID:       "user-1",
+			CollectionID: "coll-1",
+			Model:        "test-model",
+			Text:         "text " + id,
+			Tokens:       2,
+			Vector:       []float32{1, 2},
+			Language:     "portuguese",
+			Metadata:     map[string]any{"id": id},
+			CreatedAt:    createdAt,
+		}))
+	}
+
+	page, err := m.FetchEmbeddings(context.TODO(), FetchEmbeddingsInput{UserID: "user-1", CollectionID: "coll-1", Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, page, 3)
+
+	assert.Equal(t, Embedding{
+		ID:        "a",
+		Model:     "test-model",
+		Text:      "text a",
+		Tokens:    2,
+		Vector:    []float32{1, 2},
+		Language:  "portuguese",
+		Metadata:  map[string]any{"id": "a"},
+		CreatedAt: createdAt,
+	}, page[0])
+
+	page, err = m.FetchEmbeddings(context.TODO(), FetchEmbeddingsInput{UserID: "user-1", CollectionID: "coll-1", AfterID: page[2].ID, Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "d", page[0].ID)
+
+	page, err = m.FetchEmbeddings(context.TODO(), FetchEmbeddingsInput{UserID: "user-2", CollectionID: "coll-1", Limit: 3})
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+func TestMemoryReplaceCollection(t *testing.T) {
+	m := NewMemory()
+
+	store := func(id, collectionID, model string, dimensions int, shadow bool) {
+		require.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+			ID:           id,
+			UserID:       "user-1",
+			CollectionID: collectionID,
+			Model:        model,
+			Dimensions:   dimensions,
+			Tokens:       2,
+			Vector:       []float32{1, 2},
+			Shadow:       shadow,
+		}))
+	}
+
+	store("emb-1", "coll-1", "text-embedding-ada-002", 0, false)
+	store("emb-2", "coll-1-reindex", "text-embedding-3-small", 256, true)
+
+	// The shadow collection is not listed.
+	collections, err := m.ListCollections(context.TODO(), ListCollectionsInput{UserID: "user-1"})
+	require.NoError(t, err)
+	require.Len(t, collections, 1)
+	assert.Equal(t, "coll-1", collections[0].ID)
+
+	in := ReplaceCollectionInput{
+		UserID:             "user-1",
+		CollectionID:       "coll-1",
+		SourceCollectionID: "coll-1-reindex",
+		Chunks:             collections[0].Chunks,
+		UpdatedAt:          collections[0].UpdatedAt,
+	}
+
+	// A chunk stored after the collection was read would be lost.
+	store("emb-3", "coll-1", "text-embedding-ada-002", 0, false)
+	assert.ErrorIs(t, m.ReplaceCollection(context.TODO(), in), ErrConflict)
+
+	current, err := m.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: "coll-1"})
+	require.NoError(t, err)
+
+	in.Chunks, in.UpdatedAt = current.Chunks, current.UpdatedAt
+	require.NoError(t, m.ReplaceCollection(context.TODO(), in))
+
+	collection, err := m.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: "coll-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text-embedding-3-small", collection.Model)
+	assert.Equal(t, 256, collection.Dimensions)
+	assert.Equal(t, int64(1), collection.Chunks)
+
+	collections, err = m.ListCollections(context.TODO(), ListCollectionsInput{UserID: "user-1"})
+	require.NoError(t, err)
+	require.Len(t, collections, 1)
+	assert.Equal(t, "coll-1", collections[0].ID)
+
+	_, err = m.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: "coll-1-reindex"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = m.ReplaceCollection(context.TODO(), ReplaceCollectionInput{
+		UserID:             "user-2",
+		CollectionID:       "coll-1",
+		SourceCollectionID: "coll-1",
+	})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryConcurrentUse(t *testing.T) {
+	m := NewMemory()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				assert.NoError(t, m.StoreEmbeddings(context.TODO(), StoreEmbeddingInput{
+					ID:           fmt.Sprintf("emb-%d-%d", i, j),
+					UserID:       "user-1",
+					CollectionID: "coll-1",
+					Vector:       []float32{float32(i), float32(j)},
+				}))
+
+				_, err := m.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+					UserID:       "user-1",
+					CollectionID: "coll-1",
+					Vector:       []float32{1, 1},
+					Limit:        5,
+				})
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	collection, err := m.FetchCollection(context.TODO(), FetchCollectionInput{UserID: "user-1", CollectionID: "coll-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(400), collection.Chunks)
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alesr/chatbot/migrations"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrSchemaOutdated is returned by CheckSchema when migrations are pending.
+	ErrSchemaOutdated = errors.New("database schema is outdated")
+
+	// ErrSchemaDirty is returned when a migration failed halfway,
+	// and the schema must be repaired by hand before migrating again.
+	ErrSchemaDirty = errors.New("database schema is dirty")
+)
+
+// NoSchemaVersion is the schema version of a database without migrations.
+const NoSchemaVersion int = -1
+
+// migrationsLockID is the key of the advisory lock
+// serializing migrations across processes.
+const migrationsLockID int64 = 7146257138240932521
+
+// The versions are tracked in the table the migrate CLI uses,
+// so databases migrated by either are recognized by both.
+const (
+	queryCreateSchemaMigrations string = `CREATE TABLE IF NOT EXISTS schema_migrations
+(version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`
+
+	querySchemaMigrationsExists string = `SELECT to_regclass('schema_migrations') IS NOT NULL`
+
+	querySelectSchemaVersion string = `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	queryDeleteSchemaVersion string = `DELETE FROM schema_migrations`
+
+	queryInsertSchemaVersion string = `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`
+)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the migrations of fsys, ordered by version.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("could not list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+
+		v, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", file)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration: %w", err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	list := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		list = append(list, *m)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].version < list[j].version
+	})
+	return list, nil
+}
+
+// LatestSchemaVersion returns the version of the last embedded migration.
+func LatestSchemaVersion() int {
+	list, err := loadMigrations(migrations.FS)
+	if err != nil || len(list) == 0 {
+		return NoSchemaVersion
+	}
+	return list[len(list)-1].version
+}
+
+// SchemaVersion returns the version of the last migration applied to
+// the database, or NoSchemaVersion, and whether that migration failed halfway.
+func SchemaVersion(ctx context.Context, db *sqlx.DB) (version int, dirty bool, err error) {
+	version, dirty, err = schemaVersion(ctx, db)
+	if err != nil {
+		return NoSchemaVersion, false, fmt.Errorf("could not fetch schema version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+// CheckSchema returns ErrSchemaOutdated if the database misses embedded migrations,
+// and ErrSchemaDirty if a migration failed halfway. Run it on startup to refuse
+// serving against a schema the code does not match.
+func CheckSchema(ctx context.Context, db *sqlx.DB) error {
+	version, dirty, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w: migration %d failed", ErrSchemaDirty, version)
+	}
+
+	if latest := LatestSchemaVersion(); version < latest {
+		return fmt.Errorf("%w: version %d, want %d", ErrSchemaOutdated, version, latest)
+	}
+	return nil
+}
+
+// Migrate applies the embedded migrations the database misses, in order.
+// Each migration is recorded before and after it runs, so a failed migration
+// leaves the schema dirty and further migrations return ErrSchemaDirty.
+func Migrate(ctx context.Context, db *sqlx.DB) error {
+	return migrate(ctx, db, upSteps)
+}
+
+// MigrateDown reverts the last n applied migrations, in reverse order.
+func MigrateDown(ctx context.Context, db *sqlx.DB, n int) error {
+	return migrate(ctx, db, func(list []migration, version int) []step {
+		return downSteps(list, version, n)
+	})
+}
+
+// step runs the SQL of a migration, leaving the database at the target version.
+type step struct {
+	sql     string
+	version int
+	target  int
+}
+
+// upSteps plans the migrations newer than the version.
+func upSteps(list []migration, version int) []step {
+	var steps []step
+	for _, m := range list {
+		if m.version > version {
+			steps = append(steps, step{sql: m.up, version: m.version, target: m.version})
+		}
+	}
+	return steps
+}
+
+// downSteps plans reverting the last n migrations up to the version,
+// each leaving the database at the version of the previous one.
+func downSteps(list []migration, version, n int) []step {
+	var steps []step
+	for i := len(list) - 1; i >= 0 && len(steps) < n; i-- {
+		if list[i].version > version {
+			continue
+		}
+
+		target := NoSchemaVersion
+		if i > 0 {
+			target = list[i-1].version
+		}
+		steps = append(steps, step{sql: list[i].down, version: list[i].version, target: target})
+	}
+	return steps
+}
+
+// migrate runs the steps planned from the current version,
+// holding an advisory lock so concurrent processes migrate one at a time.
+func migrate(ctx context.Context, db *sqlx.DB, plan func(list []migration, version int) []step) (err error) {
+	list, err := loadMigrations(migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationsLockID); err != nil {
+		return fmt.Errorf("could not lock migrations: %w", err)
+	}
+
+	defer func() {
+		// The lock is released with the session if the context is already done.
+		if _, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationsLockID); unlockErr != nil && err == nil {
+			err = fmt.Errorf("could not unlock migrations: %w", unlockErr)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, queryCreateSchemaMigrations); err != nil {
+		return fmt.Errorf("could not create schema migrations table: %w", err)
+	}
+
+	version, dirty, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("could not fetch schema version: %w", err)
+	}
+
+	if dirty {
+		return fmt.Errorf("%w: migration %d failed", ErrSchemaDirty, version)
+	}
+
+	for _, s := range plan(list, version) {
+		if err := setSchemaVersion(ctx, conn, s.target, true); err != nil {
+			return err
+		}
+
+		// Migrations run as written, outside of a transaction,
+		// since some statements such as CREATE DATABASE cannot run in one.
+		if strings.TrimSpace(s.sql) != "" {
+			if _, err := conn.ExecContext(ctx, s.sql); err != nil {
+				return fmt.Errorf("could not run migration %d: %w", s.version, err)
+			}
+		}
+
+		if err := setSchemaVersion(ctx, conn, s.target, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type schemaQueryer interface {
+	QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row
+}
+
+func schemaVersion(ctx context.Context, q schemaQueryer) (int, bool, error) {
+	var exists bool
+	if err := q.QueryRowxContext(ctx, querySchemaMigrationsExists).Scan(&exists); err != nil {
+		return NoSchemaVersion, false, err
+	}
+
+	if !exists {
+		return NoSchemaVersion, false, nil
+	}
+
+	var (
+		version int
+		dirty   bool
+	)
+
+	if err := q.QueryRowxContext(ctx, querySelectSchemaVersion).Scan(&version, &dirty); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return NoSchemaVersion, false, nil
+		}
+		return NoSchemaVersion, false, err
+	}
+	return version, dirty, nil
+}
+
+// setSchemaVersion replaces the recorded version. Like the migrate CLI,
+// no version is recorded for a clean database without migrations.
+func setSchemaVersion(ctx context.Context, conn *sqlx.Conn, version int, dirty bool) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, queryDeleteSchemaVersion); err != nil {
+		return fmt.Errorf("could not set schema version: %w", err)
+	}
+
+	if version >= 0 || dirty {
+		if _, err := tx.ExecContext(ctx, queryInsertSchemaVersion, version, dirty); err != nil {
+			return fmt.Errorf("could not set schema version: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
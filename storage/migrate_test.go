@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/alesr/chatbot/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := loadMigrations(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+
+	for i, m := range list {
+		assert.Equal(t, i, m.version, "versions must be contiguous")
+		assert.NotEmpty(t, m.name)
+
+		for direction, sql := range map[string]string{"up": m.up, "down": m.down} {
+			sql = strings.TrimSpace(sql)
+			require.NotEmpty(t, sql, "migration %d has no %s file", m.version, direction)
+
+			// Statements run as one script, so a missing semicolon
+			// silently merges two statements.
+			for _, line := range strings.Split(sql, "\n") {
+				line = strings.TrimSpace(line)
+				if strings.HasPrefix(line, "DROP ") {
+					assert.True(t, strings.HasSuffix(line, ";"), "migration %d %s: unterminated %q", m.version, direction, line)
+				}
+			}
+			assert.True(t, strings.HasSuffix(sql, ";"), "migration %d %s is unterminated", m.version, direction)
+		}
+	}
+
+	assert.Equal(t, list[len(list)-1].version, LatestSchemaVersion())
+
+	_, err = loadMigrations(fstest.MapFS{"1_table.sideways.sql": {}})
+	assert.Error(t, err)
+
+	_, err = loadMigrations(fstest.MapFS{"one_table.up.sql": {}})
+	assert.Error(t, err)
+}
+
+func TestMigrationSteps(t *testing.T) {
+	list := []migration{
+		{version: 0, up: "up 0", down: "down 0"},
+		{version: 1, up: "up 1", down: "down 1"},
+		{version: 2, up: "up 2", down: "down 2"},
+	}
+
+	assert.Equal(t, []step{
+		{sql: "up 0", version: 0, target: 0},
+		{sql: "up 1", version: 1, target: 1},
+		{sql: "up 2", version: 2, target: 2},
+	}, upSteps(list, NoSchemaVersion))
+
+	assert.Equal(t, []step{{sql: "up 2", version: 2, target: 2}}, upSteps(list, 1))
+	assert.Empty(t, upSteps(list, 2))
+
+	assert.Equal(t, []step{
+		{sql: "down 2", version: 2, target: 1},
+		{sql: "down 1", version: 1, target: 0},
+	}, downSteps(list, 2, 2))
+
+	assert.Equal(t, []step{
+		{sql: "down 1", version: 1, target: 0},
+		{sql: "down 0", version: 0, target: NoSchemaVersion},
+	}, downSteps(list, 1, 5))
+
+	assert.Empty(t, downSteps(list, NoSchemaVersion, 1))
+}
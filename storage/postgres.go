@@ -2,41 +2,93 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/alesr/chatbot/metrics"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
-type Postgres struct{ *sqlx.DB }
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("not found")
 
-func NewPostgres(dbConn *sqlx.DB) *Postgres {
-	return &Postgres{DB: dbConn}
+	// ErrConflict is returned when a record changed since it was read.
+	ErrConflict = errors.New("conflict")
+)
+
+type Postgres struct {
+	*sqlx.DB
+	rowLevelSecurity bool
+	tracer           trace.Tracer
+	metrics          *metrics.Collector
 }
 
+func NewPostgres(dbConn *sqlx.DB, opts ...Option) *Postgres {
+	p := &Postgres{
+		DB:     dbConn,
+		tracer: noop.NewTracerProvider().Tracer(tracerName),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// DefaultTextSearchLanguage is the text search configuration
+// used for keyword search when none is given.
+const DefaultTextSearchLanguage string = "english"
+
 type StoreEmbeddingInput struct {
 	ID           string
 	UserID       string
 	CollectionID string
 	Model        string
-	Text         string
-	Tokens       int64
-	Vector       []float32
-	CreatedAt    time.Time
+	// Dimensions is the number of dimensions requested from the model, 0 for its default.
+	Dimensions int
+	Text       string
+	Tokens     int64
+	Vector     []float32
+	Language   string
+	Metadata   map[string]any
+	CreatedAt  time.Time
+
+	// Shadow stores the chunk in a collection built to replace another one
+	// with ReplaceCollection. Shadow collections are neither listed nor counted.
+	Shadow bool
 }
 
-const queryInsertEmbedding string = `INSERT INTO embeddings 
-(id, user_id, collection_id, model, text, tokens, vector, created_at) 
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+// queryInsertEmbedding holds a shared lock on the collection while inserting,
+// so that ReplaceCollection sees every chunk stored before it replaces the collection.
+const queryInsertEmbedding string = `WITH collection_lock AS (
+	SELECT pg_advisory_xact_lock_shared(hashtextextended($2 || '/' || $3, 0))
+)
+INSERT INTO embeddings 
+(id, user_id, collection_id, model, text, tokens, vector, language, metadata, created_at, dimensions, shadow) 
+SELECT $1, $2, $3, $4, $5, $6::INTEGER, $7::vector, $8::regconfig, $9::JSONB, $10::TIMESTAMP, $11::INTEGER, $12::BOOLEAN
+FROM collection_lock`
 
 func (p *Postgres) StoreEmbeddings(ctx context.Context, in StoreEmbeddingInput) error {
-	if _, err := p.ExecContext(
-		ctx, queryInsertEmbedding, in.ID, in.UserID,
-		in.CollectionID, in.Model, in.Text, in.Tokens,
-		pgvector.NewVector(in.Vector), in.CreatedAt,
-	); err != nil {
+	metadata, err := marshalMetadata(in.Metadata)
+	if err != nil {
+		return err
+	}
+
+	if err := p.asTenant(ctx, "StoreEmbeddings", in.UserID, func(ctx context.Context, q queryer) error {
+		_, err := q.ExecContext(
+			ctx, queryInsertEmbedding, in.ID, in.UserID,
+			in.CollectionID, in.Model, in.Text, in.Tokens,
+			pgvector.NewVector(in.Vector), textSearchLanguage(in.Language),
+			metadata, in.CreatedAt, in.Dimensions, in.Shadow,
+		)
+		return err
+	}); err != nil {
 		return fmt.Errorf("could not store vector: %w", err)
 	}
 	return nil
@@ -53,10 +105,12 @@ const queryFetchModel string = "SELECT model FROM embeddings WHERE user_id = $1
 
 func (p *Postgres) FetchModel(ctx context.Context, in FetchModelInput) (string, error) {
 	var model string
-	if err := p.GetContext(ctx, &model,
-		queryFetchModel,
-		in.UserID, in.CollectionID,
-	); err != nil {
+	if err := p.asTenant(ctx, "FetchModel", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.GetContext(ctx, &model,
+			queryFetchModel,
+			in.UserID, in.CollectionID,
+		)
+	}); err != nil {
 		return "", fmt.Errorf("could not fetch model: %w", err)
 	}
 	return model, nil
@@ -80,14 +134,141 @@ func (p *Postgres) FetchNearestNeighbor(ctx context.Context, in FetchNearestNeig
 		distance float64
 	)
 
-	row := p.QueryRowxContext(ctx,
-		queryFetchNearestNeighbor,
-		pgvector.NewVector(in.Vector), in.UserID, in.CollectionID,
-	)
-
-	if err := row.Scan(&text, &distance); err != nil {
+	if err := p.asTenant(ctx, "FetchNearestNeighbor", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.QueryRowxContext(ctx,
+			queryFetchNearestNeighbor,
+			pgvector.NewVector(in.Vector), in.UserID, in.CollectionID,
+		).Scan(&text, &distance)
+	}); err != nil {
 		return "", 0, fmt.Errorf("could not fetch nearest neighbor: %w", err)
 	}
 
 	return text, distance, nil
 }
+
+// Chunk represents a stored text chunk returned by a search.
+// Distance is set by vector searches and Rank by keyword searches.
+type Chunk struct {
+	ID       string
+	Text     string
+	Vector   []float32
+	Metadata map[string]any
+	Distance float64
+	Rank     float64
+}
+
+// chunkRow is the database representation of a Chunk.
+type chunkRow struct {
+	ID       string          `db:"id"`
+	Text     string          `db:"text"`
+	Vector   pgvector.Vector `db:"vector"`
+	Metadata []byte          `db:"metadata"`
+	Distance float64         `db:"distance"`
+	Rank     float64         `db:"rank"`
+}
+
+func toChunks(rows []chunkRow) ([]Chunk, error) {
+	chunks := make([]Chunk, 0, len(rows))
+	for _, r := range rows {
+		var metadata map[string]any
+		if err := json.Unmarshal(r.Metadata, &metadata); err != nil {
+			return nil, fmt.Errorf("could not unmarshal metadata: %w", err)
+		}
+
+		chunks = append(chunks, Chunk{
+			ID:       r.ID,
+			Text:     r.Text,
+			Vector:   r.Vector.Slice(),
+			Metadata: metadata,
+			Distance: r.Distance,
+			Rank:     r.Rank,
+		})
+	}
+	return chunks, nil
+}
+
+func marshalMetadata(metadata map[string]any) ([]byte, error) {
+	if metadata == nil {
+		return []byte("{}"), nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal metadata: %w", err)
+	}
+	return data, nil
+}
+
+type FetchNearestNeighborsInput struct {
+	UserID       string
+	CollectionID string
+	Vector       []float32
+	Filters      []Filter
+	Limit        int
+}
+
+const queryFetchNearestNeighbors string = `SELECT id, text, vector, metadata, vector <-> $1 AS distance
+FROM embeddings
+WHERE user_id = $2 AND collection_id = $3%s
+ORDER BY distance ASC
+LIMIT $4`
+
+// FetchNearestNeighbors returns up to Limit chunks matching the filters,
+// ordered by their distance to the given vector.
+func (p *Postgres) FetchNearestNeighbors(ctx context.Context, in FetchNearestNeighborsInput) ([]Chunk, error) {
+	cond, filterArgs, err := compileFilters(in.Filters, 4)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile filters: %w", err)
+	}
+
+	args := append([]any{pgvector.NewVector(in.Vector), in.UserID, in.CollectionID, in.Limit}, filterArgs...)
+
+	var rows []chunkRow
+	if err := p.asTenant(ctx, "FetchNearestNeighbors", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &rows, fmt.Sprintf(queryFetchNearestNeighbors, cond), args...)
+	}); err != nil {
+		return nil, fmt.Errorf("could not fetch nearest neighbors: %w", err)
+	}
+	return toChunks(rows)
+}
+
+type FetchKeywordMatchesInput struct {
+	UserID       string
+	CollectionID string
+	Query        string
+	Language     string
+	Filters      []Filter
+	Limit        int
+}
+
+const queryFetchKeywordMatches string = `SELECT id, text, vector, metadata, ts_rank_cd(text_search, query) AS rank
+FROM embeddings, websearch_to_tsquery($1::regconfig, $2) query
+WHERE user_id = $3 AND collection_id = $4 AND text_search @@ query%s
+ORDER BY rank DESC
+LIMIT $5`
+
+// FetchKeywordMatches returns up to Limit chunks matching the query and the filters
+// using Postgres full-text search, ordered by their rank.
+func (p *Postgres) FetchKeywordMatches(ctx context.Context, in FetchKeywordMatchesInput) ([]Chunk, error) {
+	cond, filterArgs, err := compileFilters(in.Filters, 5)
+	if err != nil {
+		return nil, fmt.Errorf("could not compile filters: %w", err)
+	}
+
+	args := append([]any{textSearchLanguage(in.Language), in.Query, in.UserID, in.CollectionID, in.Limit}, filterArgs...)
+
+	var rows []chunkRow
+	if err := p.asTenant(ctx, "FetchKeywordMatches", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &rows, fmt.Sprintf(queryFetchKeywordMatches, cond), args...)
+	}); err != nil {
+		return nil, fmt.Errorf("could not fetch keyword matches: %w", err)
+	}
+	return toChunks(rows)
+}
+
+func textSearchLanguage(lang string) string {
+	if lang == "" {
+		return DefaultTextSearchLanguage
+	}
+	return lang
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -28,6 +29,249 @@ func TestStoreVector(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestFetchKeywordMatches(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	collectionID := uuid.New().String()
+
+	storeChunks(t, repo, userID, collectionID,
+		StoreEmbeddingInput{
+			ID:       userID + "/vacation",
+			Text:     "Employees are entitled to 25 vacation days per year.",
+			Vector:   []float32{1, 0, 0},
+			Metadata: map[string]any{"source": "handbook"},
+		},
+		StoreEmbeddingInput{
+			ID:     userID + "/remote",
+			Text:   "Remote work is allowed two days per week.",
+			Vector: []float32{0, 1, 0},
+		},
+		StoreEmbeddingInput{
+			ID:     userID + "/expenses",
+			Text:   "Expenses are reimbursed within thirty days. Expenses need a receipt.",
+			Vector: []float32{0, 0, 1},
+		},
+	)
+
+	// Chunks of other collections never match.
+	storeChunks(t, repo, userID, uuid.New().String(), StoreEmbeddingInput{
+		ID:     userID + "/other",
+		Text:   "Vacation days do not roll over.",
+		Vector: []float32{1, 0, 0},
+	})
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "stemmed word",
+			query:    "vacations",
+			expected: []string{userID + "/vacation"},
+		},
+		{
+			name:     "ranked by matches",
+			query:    "days",
+			expected: []string{userID + "/vacation", userID + "/remote", userID + "/expenses"},
+		},
+		{
+			name:     "quoted phrase",
+			query:    `"two days"`,
+			expected: []string{userID + "/remote"},
+		},
+		{
+			name:     "excluded word",
+			query:    "days -remote",
+			expected: []string{userID + "/vacation", userID + "/expenses"},
+		},
+		{
+			name:     "either word",
+			query:    "remote or receipt",
+			expected: []string{userID + "/remote", userID + "/expenses"},
+		},
+		{
+			name:  "no match",
+			query: "salary",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chunks, err := repo.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+				UserID:       userID,
+				CollectionID: collectionID,
+				Query:        tc.query,
+				Limit:        10,
+			})
+			require.NoError(t, err)
+
+			assert.ElementsMatch(t, tc.expected, chunkIDs(chunks))
+
+			for i := 1; i < len(chunks); i++ {
+				assert.GreaterOrEqual(t, chunks[i-1].Rank, chunks[i].Rank)
+			}
+		})
+	}
+
+	t.Run("most matches rank first", func(t *testing.T) {
+		chunks, err := repo.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+			UserID:       userID,
+			CollectionID: collectionID,
+			Query:        "expenses or vacation",
+			Limit:        10,
+		})
+		require.NoError(t, err)
+		require.Len(t, chunks, 2)
+		assert.Equal(t, userID+"/expenses", chunks[0].ID)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		chunks, err := repo.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+			UserID:       userID,
+			CollectionID: collectionID,
+			Query:        "days",
+			Limit:        1,
+		})
+		require.NoError(t, err)
+		assert.Len(t, chunks, 1)
+	})
+
+	t.Run("returns vector and metadata for fusion", func(t *testing.T) {
+		chunks, err := repo.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+			UserID:       userID,
+			CollectionID: collectionID,
+			Query:        "vacation",
+			Limit:        10,
+		})
+		require.NoError(t, err)
+		require.Len(t, chunks, 1)
+
+		assert.Equal(t, "Employees are entitled to 25 vacation days per year.", chunks[0].Text)
+		assert.Equal(t, []float32{1, 0, 0}, chunks[0].Vector)
+		assert.Equal(t, map[string]any{"source": "handbook"}, chunks[0].Metadata)
+		assert.Greater(t, chunks[0].Rank, 0.0)
+	})
+}
+
+func TestFetchWithFilters(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	collectionID := uuid.New().String()
+
+	chunk := func(id string, metadata map[string]any) StoreEmbeddingInput {
+		return StoreEmbeddingInput{
+			ID:       userID + "/" + id,
+			Text:     "the vacation policy of " + id,
+			Vector:   []float32{1, 0},
+			Metadata: metadata,
+		}
+	}
+
+	storeChunks(t, repo, userID, collectionID,
+		chunk("hr-2023", map[string]any{"department": "hr", "year": 2023, "tags": []string{"policy"}, "date": "2023-06-01T00:00:00Z"}),
+		chunk("hr-2024", map[string]any{"department": "hr", "year": 2024, "tags": []string{"policy", "new"}, "date": "2024-06-01T00:00:00Z"}),
+		chunk("it-2024", map[string]any{"department": "it", "year": 2024}),
+		chunk("undated", map[string]any{"department": "ops", "year": "unknown", "date": 2024}),
+	)
+
+	testCases := []struct {
+		name     string
+		filters  []Filter
+		expected []string
+	}{
+		{
+			name:     "no filters",
+			expected: []string{"hr-2023", "hr-2024", "it-2024", "undated"},
+		},
+		{
+			name:     "equality",
+			filters:  []Filter{Eq("department", "hr")},
+			expected: []string{"hr-2023", "hr-2024"},
+		},
+		{
+			name:     "in",
+			filters:  []Filter{In("year", 2022, 2023)},
+			expected: []string{"hr-2023"},
+		},
+		{
+			name:     "tag",
+			filters:  []Filter{HasTag("tags", "new")},
+			expected: []string{"hr-2024"},
+		},
+		{
+			name:     "numeric range skips other types",
+			filters:  []Filter{Gte("year", 2024)},
+			expected: []string{"hr-2024", "it-2024"},
+		},
+		{
+			name:     "date range skips other types",
+			filters:  []Filter{Lt("date", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))},
+			expected: []string{"hr-2023"},
+		},
+		{
+			name:     "text range",
+			filters:  []Filter{Gt("department", "hr")},
+			expected: []string{"it-2024", "undated"},
+		},
+		{
+			name:     "combined",
+			filters:  []Filter{Gte("year", 2024), Eq("department", "it")},
+			expected: []string{"it-2024"},
+		},
+		{
+			name:    "missing field",
+			filters: []Filter{Eq("author", "alice")},
+		},
+	}
+
+	for _, tc := range testCases {
+		var expected []string
+		for _, id := range tc.expected {
+			expected = append(expected, userID+"/"+id)
+		}
+
+		t.Run(tc.name, func(t *testing.T) {
+			neighbors, err := repo.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+				UserID:       userID,
+				CollectionID: collectionID,
+				Vector:       []float32{1, 0},
+				Filters:      tc.filters,
+				Limit:        10,
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, expected, chunkIDs(neighbors))
+
+			matches, err := repo.FetchKeywordMatches(context.TODO(), FetchKeywordMatchesInput{
+				UserID:       userID,
+				CollectionID: collectionID,
+				Query:        "vacation",
+				Filters:      tc.filters,
+				Limit:        10,
+			})
+			require.NoError(t, err)
+			assert.ElementsMatch(t, expected, chunkIDs(matches))
+		})
+	}
+
+	_, err := repo.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+		UserID:       userID,
+		CollectionID: collectionID,
+		Vector:       []float32{1, 0},
+		Filters:      []Filter{{Field: "year", Op: "like"}},
+		Limit:        10,
+	})
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
 func setupDB(t *testing.T) *sqlx.DB {
 	db, err := sqlx.Open(
 		"postgres",
@@ -42,6 +286,41 @@ func teardownDB(t *testing.T, db *sqlx.DB) {
 	require.NoError(t, err)
 }
 
+// setupUser returns a user ID unique to the test and deletes
+// all of its rows once the test is done.
+func setupUser(t *testing.T, db *sqlx.DB) string {
+	userID := "user-" + uuid.New().String()
+	t.Cleanup(func() {
+		for _, table := range []string{"embeddings", "answer_cache", "usage_ledger", "api_keys"} {
+			_, err := db.Exec("DELETE FROM "+table+" WHERE user_id = $1", userID)
+			assert.NoError(t, err)
+		}
+	})
+	return userID
+}
+
+func storeChunks(t *testing.T, repo *Postgres, userID, collectionID string, chunks ...StoreEmbeddingInput) {
+	for _, c := range chunks {
+		c.UserID = userID
+		c.CollectionID = collectionID
+		if c.Model == "" {
+			c.Model = "test-model"
+		}
+		if c.CreatedAt.IsZero() {
+			c.CreatedAt = time.Now().UTC()
+		}
+		require.NoError(t, repo.StoreEmbeddings(context.TODO(), c))
+	}
+}
+
+func chunkIDs(chunks []Chunk) []string {
+	ids := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
 // pq: expected 1536 dimensions
 func vectorInputHelper(t *testing.T) []float32 {
 	vector := make([]float32, 1536)
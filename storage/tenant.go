@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Option configures optional behaviour of Postgres.
+type Option func(*Postgres)
+
+// WithRowLevelSecurity runs every query scoped to a user inside a transaction
+// that sets the chatbot.user_id session setting to that user, so that the
+// row level security policies created by the migrations restrict the query
+// to the user's rows even if its WHERE clause does not.
+//
+// Policies do not apply to the table owner, so the database user of the
+// application must not own the tables.
+func WithRowLevelSecurity() Option {
+	return func(p *Postgres) {
+		p.rowLevelSecurity = true
+	}
+}
+
+// queryer is implemented by both *sqlx.DB and *sqlx.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	QueryRowxContext(ctx context.Context, query string, args ...any) *sqlx.Row
+}
+
+const querySetTenant string = `SELECT set_config('chatbot.user_id', $1, true)`
+
+// asTenant runs fn as the named operation with the user as the current tenant.
+// Without row level security it runs fn directly against the database.
+func (p *Postgres) asTenant(ctx context.Context, op, userID string, fn func(ctx context.Context, q queryer) error) error {
+	if !p.rowLevelSecurity {
+		return p.run(ctx, op, fn)
+	}
+
+	return p.run(ctx, op, func(ctx context.Context, _ queryer) error {
+		return p.inTenantTx(ctx, userID, fn)
+	})
+}
+
+// inTenantTx runs fn in a transaction with the user as the current tenant.
+func (p *Postgres) inTenantTx(ctx context.Context, userID string, fn func(ctx context.Context, q queryer) error) error {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, querySetTenant, userID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("could not set tenant: %w", err)
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
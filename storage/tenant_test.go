@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rlsTestRole is the role the tests query as, since policies
+// do not apply to the superuser the tables are created by.
+const rlsTestRole string = "chatbot_rls_test"
+
+func TestRowLevelSecurity(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	appDB := setupTenantDB(t, db)
+	repo := NewPostgres(appDB, WithRowLevelSecurity())
+
+	userID := setupUser(t, db)
+	otherUserID := setupUser(t, db)
+
+	// Both users name their collection the same.
+	collectionID := uuid.New().String()
+
+	storeChunks(t, repo, userID, collectionID, StoreEmbeddingInput{
+		ID:     userID + "/chunk",
+		Text:   "the vacation policy",
+		Vector: []float32{1, 0},
+	})
+	storeChunks(t, repo, otherUserID, collectionID, StoreEmbeddingInput{
+		ID:     otherUserID + "/chunk",
+		Text:   "the vacation policy",
+		Vector: []float32{1, 0},
+	})
+
+	require.NoError(t, repo.StoreUsage(context.TODO(), StoreUsageInput{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		CollectionID: collectionID,
+		Operation:    "ask",
+		Model:        "test-model",
+		PromptTokens: 10,
+		CreatedAt:    time.Now().UTC(),
+	}))
+
+	t.Run("scoped queries return the user's rows", func(t *testing.T) {
+		chunks, err := repo.FetchNearestNeighbors(context.TODO(), FetchNearestNeighborsInput{
+			UserID:       userID,
+			CollectionID: collectionID,
+			Vector:       []float32{1, 0},
+			Limit:        10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{userID + "/chunk"}, chunkIDs(chunks))
+	})
+
+	t.Run("policies restrict queries to the tenant", func(t *testing.T) {
+		var ids []string
+		require.NoError(t, repo.inTenantTx(context.TODO(), userID, func(ctx context.Context, q queryer) error {
+			return q.SelectContext(ctx, &ids, "SELECT id FROM embeddings WHERE collection_id = $1", collectionID)
+		}))
+		assert.Equal(t, []string{userID + "/chunk"}, ids)
+	})
+
+	t.Run("queries without a tenant return no rows", func(t *testing.T) {
+		var ids []string
+		require.NoError(t, appDB.Select(&ids, "SELECT id FROM embeddings WHERE collection_id = $1", collectionID))
+		assert.Empty(t, ids)
+
+		totals, err := repo.FetchUsage(context.TODO(), FetchUsageInput{Period: "day"})
+		require.NoError(t, err)
+		assert.Empty(t, totals)
+	})
+
+	t.Run("rows of other users cannot be written", func(t *testing.T) {
+		err := repo.inTenantTx(context.TODO(), userID, func(ctx context.Context, q queryer) error {
+			_, err := q.ExecContext(ctx,
+				"INSERT INTO usage_ledger (id, user_id, collection_id, operation, model, prompt_tokens, completion_tokens) VALUES ($1, $2, $3, 'ask', 'test-model', 1, 1)",
+				uuid.New().String(), otherUserID, collectionID,
+			)
+			return err
+		})
+		assert.ErrorContains(t, err, "row-level security")
+	})
+
+	t.Run("tenant does not outlive the transaction", func(t *testing.T) {
+		var tenant string
+		require.NoError(t, appDB.Get(&tenant, "SELECT COALESCE(current_setting('chatbot.user_id', true), '')"))
+		assert.Empty(t, tenant)
+	})
+}
+
+// setupTenantDB returns a connection to the database as a role
+// subject to row level security, created with the admin connection.
+func setupTenantDB(t *testing.T, admin *sqlx.DB) *sqlx.DB {
+	_, err := admin.Exec(`DO $$ BEGIN
+	CREATE ROLE ` + rlsTestRole + `;
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$`)
+	require.NoError(t, err)
+
+	_, err = admin.Exec("GRANT SELECT, INSERT, UPDATE, DELETE ON embeddings, answer_cache, usage_ledger TO " + rlsTestRole)
+	require.NoError(t, err)
+
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	// SET ROLE holds for the session, so every query must use the same connection.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("SET ROLE " + rlsTestRole)
+	require.NoError(t, err)
+	return db
+}
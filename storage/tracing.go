@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/alesr/chatbot/metrics"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName string = "github.com/alesr/chatbot/storage"
+
+// WithTracerProvider creates a span for every storage operation.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(p *Postgres) {
+		p.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithMetrics records every storage operation in the collector.
+func WithMetrics(m *metrics.Collector) Option {
+	return func(p *Postgres) {
+		p.metrics = m
+	}
+}
+
+// run runs fn against the database as the named operation,
+// recording it in a span and in the metrics when enabled.
+// fn is given the context of the span, to query the database with.
+func (p *Postgres) run(ctx context.Context, op string, fn func(ctx context.Context, q queryer) error) error {
+	ctx, span := p.tracer.Start(ctx, "storage."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", op),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := fn(ctx, p.DB)
+	p.metrics.ObserveQuery(op, time.Since(start), err)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRunPassesSpanContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	p := NewPostgres(nil, WithTracerProvider(tp))
+
+	var queried trace.SpanContext
+	require.NoError(t, p.run(context.Background(), "FetchModel", func(ctx context.Context, _ queryer) error {
+		queried = trace.SpanContextFromContext(ctx)
+		return nil
+	}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "storage.FetchModel", spans[0].Name())
+
+	// Queries run in the span, so drivers and child spans are attributed to it.
+	assert.Equal(t, spans[0].SpanContext().SpanID(), queried.SpanID())
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var usagePeriods = map[string]bool{
+	"hour":  true,
+	"day":   true,
+	"week":  true,
+	"month": true,
+	"year":  true,
+}
+
+type StoreUsageInput struct {
+	ID               string
+	UserID           string
+	CollectionID     string
+	Operation        string
+	Model            string
+	PromptTokens     int64
+	CompletionTokens int64
+	CreatedAt        time.Time
+}
+
+const queryInsertUsage string = `INSERT INTO usage_ledger
+(id, user_id, collection_id, operation, model, prompt_tokens, completion_tokens, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+// StoreUsage records the token usage of a single client call.
+func (p *Postgres) StoreUsage(ctx context.Context, in StoreUsageInput) error {
+	if err := p.asTenant(ctx, "StoreUsage", in.UserID, func(ctx context.Context, q queryer) error {
+		_, err := q.ExecContext(
+			ctx, queryInsertUsage, in.ID, in.UserID, in.CollectionID,
+			in.Operation, in.Model, in.PromptTokens, in.CompletionTokens, in.CreatedAt,
+		)
+		return err
+	}); err != nil {
+		return fmt.Errorf("could not store usage: %w", err)
+	}
+	return nil
+}
+
+// FetchUsageInput selects the usage to aggregate.
+// Empty UserID or CollectionID match any, and a zero To means no upper bound.
+// With row level security, an empty UserID matches no rows.
+// Period is one of hour, day, week, month or year.
+type FetchUsageInput struct {
+	UserID       string
+	CollectionID string
+	Period       string
+	From         time.Time
+	To           time.Time
+}
+
+// UsageTotal represents the tokens used per user, collection and model in a period.
+type UsageTotal struct {
+	UserID           string    `db:"user_id"`
+	CollectionID     string    `db:"collection_id"`
+	Model            string    `db:"model"`
+	Period           time.Time `db:"period"`
+	PromptTokens     int64     `db:"prompt_tokens"`
+	CompletionTokens int64     `db:"completion_tokens"`
+}
+
+const queryFetchUsage string = `SELECT user_id, collection_id, model, date_trunc($1, created_at) AS period,
+SUM(prompt_tokens)::BIGINT AS prompt_tokens, SUM(completion_tokens)::BIGINT AS completion_tokens
+FROM usage_ledger
+WHERE created_at >= $2 AND ($3::timestamp IS NULL OR created_at < $3)
+AND ($4 = '' OR user_id = $4) AND ($5 = '' OR collection_id = $5)
+GROUP BY user_id, collection_id, model, period
+ORDER BY period, user_id, collection_id, model`
+
+// FetchUsage returns the token usage aggregated by user, collection, model and period.
+func (p *Postgres) FetchUsage(ctx context.Context, in FetchUsageInput) ([]UsageTotal, error) {
+	if !usagePeriods[in.Period] {
+		return nil, fmt.Errorf("invalid usage period %q", in.Period)
+	}
+
+	var to *time.Time
+	if !in.To.IsZero() {
+		to = &in.To
+	}
+
+	var totals []UsageTotal
+	if err := p.asTenant(ctx, "FetchUsage", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.SelectContext(ctx, &totals,
+			queryFetchUsage,
+			in.Period, in.From, to, in.UserID, in.CollectionID,
+		)
+	}); err != nil {
+		return nil, fmt.Errorf("could not fetch usage: %w", err)
+	}
+	return totals, nil
+}
+
+type FetchUserTokensInput struct {
+	UserID string
+	Since  time.Time
+}
+
+const queryFetchUserTokens string = `SELECT COALESCE(SUM(prompt_tokens + completion_tokens), 0)::BIGINT
+FROM usage_ledger
+WHERE user_id = $1 AND created_at >= $2`
+
+// FetchUserTokens returns the tokens used by the user since the given time.
+func (p *Postgres) FetchUserTokens(ctx context.Context, in FetchUserTokensInput) (int64, error) {
+	var tokens int64
+	if err := p.asTenant(ctx, "FetchUserTokens", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.GetContext(ctx, &tokens,
+			queryFetchUserTokens,
+			in.UserID, in.Since,
+		)
+	}); err != nil {
+		return 0, fmt.Errorf("could not fetch user tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+type FetchCollectionCountInput struct {
+	UserID string
+}
+
+const queryFetchCollectionCount string = `SELECT COUNT(DISTINCT collection_id) FROM embeddings WHERE user_id = $1 AND NOT shadow`
+
+// FetchCollectionCount returns the number of collections of the user, not counting shadow collections.
+func (p *Postgres) FetchCollectionCount(ctx context.Context, in FetchCollectionCountInput) (int64, error) {
+	var count int64
+	if err := p.asTenant(ctx, "FetchCollectionCount", in.UserID, func(ctx context.Context, q queryer) error {
+		return q.GetContext(ctx, &count,
+			queryFetchCollectionCount,
+			in.UserID,
+		)
+	}); err != nil {
+		return 0, fmt.Errorf("could not fetch collection count: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage(t *testing.T) {
+	db := setupDB(t)
+	t.Cleanup(func() { teardownDB(t, db) })
+
+	repo := NewPostgres(db)
+
+	userID := setupUser(t, db)
+	otherUserID := setupUser(t, db)
+
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	for _, in := range []StoreUsageInput{
+		{UserID: userID, CollectionID: "coll-1", Operation: "ask", Model: "gpt-4", PromptTokens: 100, CompletionTokens: 10, CreatedAt: day.Add(9 * time.Hour)},
+		{UserID: userID, CollectionID: "coll-1", Operation: "ask", Model: "gpt-4", PromptTokens: 200, CompletionTokens: 20, CreatedAt: day.Add(17 * time.Hour)},
+		{UserID: userID, CollectionID: "coll-1", Operation: "train", Model: "embedding", PromptTokens: 1000, CreatedAt: day.Add(10 * time.Hour)},
+		{UserID: userID, CollectionID: "coll-2", Operation: "ask", Model: "gpt-4", PromptTokens: 50, CompletionTokens: 5, CreatedAt: day.AddDate(0, 0, 1)},
+		{UserID: userID, CollectionID: "coll-1", Operation: "ask", Model: "gpt-4", PromptTokens: 300, CompletionTokens: 30, CreatedAt: day.AddDate(0, 1, 0)},
+		{UserID: otherUserID, CollectionID: "coll-1", Operation: "ask", Model: "gpt-4", PromptTokens: 7, CompletionTokens: 7, CreatedAt: day},
+	} {
+		in.ID = uuid.New().String()
+		require.NoError(t, repo.StoreUsage(context.TODO(), in))
+	}
+
+	fetch := func(t *testing.T, in FetchUsageInput) []UsageTotal {
+		totals, err := repo.FetchUsage(context.TODO(), in)
+		require.NoError(t, err)
+
+		for i := range totals {
+			totals[i].Period = totals[i].Period.UTC()
+		}
+		return totals
+	}
+
+	t.Run("by day", func(t *testing.T) {
+		totals := fetch(t, FetchUsageInput{UserID: userID, Period: "day", From: day})
+
+		assert.Equal(t, []UsageTotal{
+			{UserID: userID, CollectionID: "coll-1", Model: "embedding", Period: day, PromptTokens: 1000},
+			{UserID: userID, CollectionID: "coll-1", Model: "gpt-4", Period: day, PromptTokens: 300, CompletionTokens: 30},
+			{UserID: userID, CollectionID: "coll-2", Model: "gpt-4", Period: day.AddDate(0, 0, 1), PromptTokens: 50, CompletionTokens: 5},
+			{UserID: userID, CollectionID: "coll-1", Model: "gpt-4", Period: day.AddDate(0, 1, 0), PromptTokens: 300, CompletionTokens: 30},
+		}, totals)
+	})
+
+	t.Run("by month in a collection", func(t *testing.T) {
+		totals := fetch(t, FetchUsageInput{UserID: userID, CollectionID: "coll-1", Period: "month", From: day})
+
+		march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, []UsageTotal{
+			{UserID: userID, CollectionID: "coll-1", Model: "embedding", Period: march, PromptTokens: 1000},
+			{UserID: userID, CollectionID: "coll-1", Model: "gpt-4", Period: march, PromptTokens: 300, CompletionTokens: 30},
+			{UserID: userID, CollectionID: "coll-1", Model: "gpt-4", Period: march.AddDate(0, 1, 0), PromptTokens: 300, CompletionTokens: 30},
+		}, totals)
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		totals := fetch(t, FetchUsageInput{
+			UserID: userID,
+			Period: "day",
+			From:   day.Add(12 * time.Hour),
+			To:     day.AddDate(0, 0, 1),
+		})
+
+		assert.Equal(t, []UsageTotal{
+			{UserID: userID, CollectionID: "coll-1", Model: "gpt-4", Period: day, PromptTokens: 200, CompletionTokens: 20},
+		}, totals)
+	})
+
+	t.Run("invalid period", func(t *testing.T) {
+		_, err := repo.FetchUsage(context.TODO(), FetchUsageInput{UserID: userID, Period: "decade", From: day})
+		assert.Error(t, err)
+	})
+
+	t.Run("user tokens", func(t *testing.T) {
+		tokens, err := repo.FetchUserTokens(context.TODO(), FetchUserTokensInput{UserID: userID, Since: day.AddDate(0, 0, 1)})
+		require.NoError(t, err)
+		assert.Equal(t, int64(50+5+300+30), tokens)
+
+		tokens, err = repo.FetchUserTokens(context.TODO(), FetchUserTokensInput{UserID: userID, Since: day.AddDate(1, 0, 0)})
+		require.NoError(t, err)
+		assert.Zero(t, tokens)
+	})
+}
@@ -0,0 +1,33 @@
+package chatbot
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName string = "github.com/alesr/chatbot"
+
+// WithTracerProvider creates spans for Train, Ask and their steps.
+// Pass the same provider to openaicli and storage to trace client calls
+// and queries as children of those spans.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Service) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func (s *Service) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,60 @@
+package chatbot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestAskTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Data: []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			return &openaicli.CompletitionResponse{
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "42"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "emb-1", Text: "context"}}, nil
+		},
+	}
+
+	svc := NewService("test-api-key", &client, &client, &repo, WithTracerProvider(tp))
+
+	_, err := svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: "coll-1",
+		Question:     "question",
+	})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	ask := spans[2]
+	assert.Equal(t, "chatbot.Ask", ask.Name())
+
+	for _, span := range spans[:2] {
+		assert.Equal(t, ask.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+
+	assert.Equal(t, "chatbot.embed", spans[0].Name())
+	assert.Equal(t, "chatbot.retrieve", spans[1].Name())
+}
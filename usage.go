@@ -0,0 +1,139 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alesr/chatbot/storage"
+	"github.com/google/uuid"
+)
+
+const defaultUsagePeriod string = "day"
+
+// UsageOperation identifies the kind of client call recorded in the usage ledger.
+type UsageOperation string
+
+const (
+	OperationTrainEmbedding    UsageOperation = "train_embedding"
+	OperationQuestionEmbedding UsageOperation = "question_embedding"
+	OperationCompletion        UsageOperation = "completion"
+	OperationReindexEmbedding  UsageOperation = "reindex_embedding"
+	OperationRerank            UsageOperation = "rerank"
+)
+
+type (
+	// UsageLedger represents the storage of token usage records.
+	UsageLedger interface {
+		StoreUsage(ctx context.Context, in storage.StoreUsageInput) error
+		FetchUsage(ctx context.Context, in storage.FetchUsageInput) ([]storage.UsageTotal, error)
+	}
+
+	// ModelPrice represents the price in USD per thousand tokens of a model.
+	ModelPrice struct {
+		Prompt     float64
+		Completion float64
+	}
+
+	// PriceTable maps model names to their prices.
+	PriceTable map[string]ModelPrice
+
+	// UsageInput represents the input for querying usage.
+	// Empty UserID or CollectionID match any, and a zero To means up to now.
+	// Period is one of hour, day, week, month or year, and defaults to day.
+	UsageInput struct {
+		UserID       string
+		CollectionID string
+		Period       string
+		From         time.Time
+		To           time.Time
+	}
+
+	// UsageReport represents the tokens used and their estimated cost
+	// per user, collection and model in a period.
+	UsageReport struct {
+		UserID           string
+		CollectionID     string
+		Model            string
+		Period           time.Time
+		PromptTokens     int64
+		CompletionTokens int64
+		Cost             float64
+	}
+
+	// usageScope identifies who a client call is made for.
+	usageScope struct {
+		userID       string
+		collectionID string
+		operation    UsageOperation
+	}
+)
+
+// Cost returns the estimated cost of the tokens for the model.
+// Models missing from the table cost nothing.
+func (p PriceTable) Cost(model string, promptTokens, completionTokens int64) float64 {
+	price, ok := p[model]
+	if !ok {
+		return 0
+	}
+	return float64(promptTokens)/1000*price.Prompt + float64(completionTokens)/1000*price.Completion
+}
+
+// Usage returns the token usage recorded in the ledger,
+// aggregated by user, collection, model and period, with its estimated cost.
+func (s *Service) Usage(ctx context.Context, in UsageInput) ([]UsageReport, error) {
+	if s.ledger == nil {
+		return nil, fmt.Errorf("could not fetch usage: no usage ledger configured")
+	}
+
+	period := in.Period
+	if period == "" {
+		period = defaultUsagePeriod
+	}
+
+	totals, err := s.ledger.FetchUsage(ctx, storage.FetchUsageInput{
+		UserID:       in.UserID,
+		CollectionID: in.CollectionID,
+		Period:       period,
+		From:         in.From,
+		To:           in.To,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch usage: %w", err)
+	}
+
+	reports := make([]UsageReport, 0, len(totals))
+	for _, t := range totals {
+		reports = append(reports, UsageReport{
+			UserID:           t.UserID,
+			CollectionID:     t.CollectionID,
+			Model:            t.Model,
+			Period:           t.Period,
+			PromptTokens:     t.PromptTokens,
+			CompletionTokens: t.CompletionTokens,
+			Cost:             s.prices.Cost(t.Model, t.PromptTokens, t.CompletionTokens),
+		})
+	}
+	return reports, nil
+}
+
+// recordUsage stores the tokens of a client call in the ledger, if any.
+func (s *Service) recordUsage(ctx context.Context, scope usageScope, model string, promptTokens, completionTokens int64) error {
+	if s.ledger == nil {
+		return nil
+	}
+
+	if err := s.ledger.StoreUsage(ctx, storage.StoreUsageInput{
+		ID:               "use-" + uuid.NewString(),
+		UserID:           scope.userID,
+		CollectionID:     scope.collectionID,
+		Operation:        string(scope.operation),
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		CreatedAt:        time.Now().UTC(),
+	}); err != nil {
+		return fmt.Errorf("could not record usage: %w", err)
+	}
+	return nil
+}
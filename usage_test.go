@@ -0,0 +1,118 @@
+package chatbot
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alesr/chatbot/client/openaicli"
+	"github.com/alesr/chatbot/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryUsageLedger struct {
+	mu      sync.Mutex
+	records []storage.StoreUsageInput
+	totals  []storage.UsageTotal
+}
+
+func (m *memoryUsageLedger) StoreUsage(ctx context.Context, in storage.StoreUsageInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records = append(m.records, in)
+	return nil
+}
+
+func (m *memoryUsageLedger) FetchUsage(ctx context.Context, in storage.FetchUsageInput) ([]storage.UsageTotal, error) {
+	return m.totals, nil
+}
+
+func TestUsageRecording(t *testing.T) {
+	client := mockClient{
+		CreateEmbeddingFunc: func(ctx context.Context, in openaicli.EmbbedingRequest) (*openaicli.EmbeddingResponse, error) {
+			return &openaicli.EmbeddingResponse{
+				Usage: openaicli.Usage{PromptTokens: 3, TotalTokens: 3},
+				Data:  []openaicli.Embedding{{Embedding: []float32{1.0, 2.0, 3.0}}},
+			}, nil
+		},
+		CreateChatCompletitionFunc: func(ctx context.Context, in openaicli.CompletitionRequest) (*openaicli.CompletitionResponse, error) {
+			return &openaicli.CompletitionResponse{
+				Model:   "gpt-3.5-turbo",
+				Usage:   openaicli.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+				Choices: []openaicli.Choice{{Message: openaicli.Message{Content: "42"}}},
+			}, nil
+		},
+	}
+
+	repo := mockRepository{
+		StoreEmbeddingsFunc: func(ctx context.Context, in storage.StoreEmbeddingInput) error {
+			return nil
+		},
+		FetchCollectionFunc: fetchDefaultCollection,
+		FetchNearestNeighborsFunc: func(ctx context.Context, in storage.FetchNearestNeighborsInput) ([]storage.Chunk, error) {
+			return []storage.Chunk{{ID: "emb-1", Text: "context"}}, nil
+		},
+	}
+
+	ledger := &memoryUsageLedger{}
+	svc := NewService("test-api-key", &client, &client, &repo, WithUsageLedger(ledger, nil))
+
+	collectionID, err := svc.Train(context.Background(), TrainInput{
+		UserID: "test-user",
+		Model:  defaultModel,
+		Data:   []io.Reader{strings.NewReader("word1 word2 word3")},
+	})
+	require.NoError(t, err)
+
+	_, err = svc.Ask(context.Background(), AskInput{
+		UserID:       "test-user",
+		CollectionID: collectionID,
+		Question:     "question",
+	})
+	require.NoError(t, err)
+
+	require.Len(t, ledger.records, 3)
+
+	for _, r := range ledger.records {
+		assert.Equal(t, "test-user", r.UserID)
+		assert.Equal(t, collectionID, r.CollectionID)
+	}
+
+	assert.Equal(t, string(OperationTrainEmbedding), ledger.records[0].Operation)
+	assert.Equal(t, int64(3), ledger.records[0].PromptTokens)
+
+	assert.Equal(t, string(OperationQuestionEmbedding), ledger.records[1].Operation)
+	assert.Equal(t, string(defaultModel), ledger.records[1].Model)
+
+	assert.Equal(t, string(OperationCompletion), ledger.records[2].Operation)
+	assert.Equal(t, "gpt-3.5-turbo", ledger.records[2].Model)
+	assert.Equal(t, int64(10), ledger.records[2].PromptTokens)
+	assert.Equal(t, int64(5), ledger.records[2].CompletionTokens)
+}
+
+func TestUsageCost(t *testing.T) {
+	period := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ledger := &memoryUsageLedger{
+		totals: []storage.UsageTotal{
+			{UserID: "u", CollectionID: "c", Model: "gpt-3.5-turbo", Period: period, PromptTokens: 2000, CompletionTokens: 1000},
+			{UserID: "u", CollectionID: "c", Model: "unknown", Period: period, PromptTokens: 2000},
+		},
+	}
+
+	svc := NewService("test-api-key", &mockClient{}, &mockClient{}, &mockRepository{}, WithUsageLedger(ledger, PriceTable{
+		"gpt-3.5-turbo": {Prompt: 0.0015, Completion: 0.002},
+	}))
+
+	reports, err := svc.Usage(context.Background(), UsageInput{UserID: "u"})
+	require.NoError(t, err)
+
+	require.Len(t, reports, 2)
+	assert.InDelta(t, 0.005, reports[0].Cost, 1e-9)
+	assert.Zero(t, reports[1].Cost)
+}